@@ -1,25 +1,414 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"llm-proxy/pkg/accesslog"
+	"llm-proxy/pkg/budget"
+	"llm-proxy/pkg/capture"
+	"llm-proxy/pkg/credential"
+	"llm-proxy/pkg/keyring"
+	"llm-proxy/pkg/logging"
+	"llm-proxy/pkg/proxy"
 	"llm-proxy/pkg/server"
 	"llm-proxy/pkg/session"
+	"llm-proxy/pkg/signedtoken"
+	"llm-proxy/pkg/trace"
+	"llm-proxy/pkg/usage"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	os.Exit(run())
+}
+
+// run starts the proxy and returns the process exit status once it stops.
+// It only ever returns, never exits, so the access log, session store and
+// everything else it opened are closed by its deferred calls first.
+func run() int {
 	addr := flag.String("addr", ":8090", "Listen address for the proxy")
 	adminToken := flag.String("admin-token", os.Getenv("GHOSTPROXY_ADMIN_TOKEN"), "Admin token for session registry endpoints")
+	reapInterval := flag.Duration("reap-interval", 30*time.Second, "How often expired sessions are evicted")
+	storeKind := flag.String("store", "memory", "Session store backend: memory or file")
+	storePath := flag.String("store-path", "llm-proxy-sessions.log", "Session log path for -store=file")
+	masterKeyFile := flag.String("master-key-file", "", "File holding a base64 AES-256 master key; enables API key encryption at rest (else GHOSTPROXY_MASTER_KEY)")
+	tokenSecretFile := flag.String("token-secret-file", "", "File holding the secret used to hash session tokens (else GHOSTPROXY_TOKEN_SECRET)")
+	credentialsFile := flag.String("credentials-file", "", "JSON file of catalog credentials [{id, provider, api_key}]")
+	tokenKeys := map[string]ed25519.PublicKey{}
+	flag.Func("token-public-key", "Trust signed sandbox tokens from this Ed25519 PEM public key, as [kid=]path (repeatable)", func(value string) error {
+		kid, path, ok := strings.Cut(value, "=")
+		if !ok {
+			path = value
+			kid = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		key, err := signedtoken.LoadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		tokenKeys[kid] = key
+		return nil
+	})
+	providersFile := flag.String("providers-file", "", "JSON file of extra provider definitions [{name, upstream, header, value, ...}]")
+	upstreams := map[string][]string{}
+	flag.Func("upstream", "Upstreams for a provider's sessions without upstream_url, tried in order, as provider=url[,url...] (repeatable)", func(value string) error {
+		provider, list, ok := strings.Cut(value, "=")
+		if !ok || provider == "" || list == "" {
+			return fmt.Errorf("want provider=url[,url...]")
+		}
+		urls := strings.Split(list, ",")
+		if slices.Contains(urls, "") {
+			return fmt.Errorf("empty upstream URL")
+		}
+		// There is nowhere to name a second vendor's credential here, so
+		// a provider's list must stay on one host.
+		if err := proxy.CheckUpstreams(urls, nil); err != nil {
+			return err
+		}
+		upstreams[provider] = urls
+		return nil
+	})
+	circuitFailures := flag.Int("circuit-failures", proxy.DefaultCircuitFailures, "Consecutive connection errors or 5xx after which an upstream with a fallback is skipped")
+	circuitCoolDown := flag.Duration("circuit-cool-down", proxy.DefaultCircuitCoolDown, "How long a failing upstream is skipped")
+	tokenMaxTTL := flag.Duration("token-max-ttl", 24*time.Hour, "Longest lifetime accepted for signed sandbox tokens")
+	usageAccounting := flag.Bool("usage", false, "Meter token usage from provider responses")
+	priceTableFile := flag.String("price-table", "", "JSON file of per-model prices in USD per million tokens; enables dollar budgets")
+	usageRetention := flag.Duration("usage-retention", 30*24*time.Hour, "How long hourly usage totals are kept (0 keeps them forever)")
+	otlpEndpoint := flag.String("otlp-endpoint", otlpTracesEndpoint(), "OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces; enables tracing (else OTEL_EXPORTER_OTLP_ENDPOINT)")
+	accessLogPath := flag.String("access-log", "", "Write a JSON line per proxied request to this file (disabled if empty)")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 100, "Rotate the access log once it reaches this many MiB (0 disables)")
+	accessLogMaxAge := flag.Duration("access-log-max-age", 24*time.Hour, "Rotate the access log after this long (0 disables)")
+	accessLogMaxBackups := flag.Int("access-log-max-backups", 7, "Rotated access logs to keep (0 keeps all)")
+	retryDefaults := proxy.DefaultRetryPolicy()
+	retryAttempts := flag.Int("retry-attempts", 1, "Upstream requests sent per request, including the first and failovers (1 disables retries)")
+	retryStatuses := flag.String("retry-statuses", joinStatuses(retryDefaults.Statuses), "Comma-separated upstream statuses to retry")
+	retryBaseDelay := flag.Duration("retry-base-delay", retryDefaults.BaseDelay, "Backoff before the first retry; doubles per attempt, with jitter")
+	retryMaxDelay := flag.Duration("retry-max-delay", retryDefaults.MaxDelay, "Longest wait between attempts; a longer upstream retry-after is passed to the sandbox")
+	retryMaxBody := flag.Int64("retry-max-body", retryDefaults.MaxBodySize>>20, "Largest request body in MiB buffered for retries; larger requests are sent once")
+	captureDir := flag.String("capture-dir", "", "Archive exchanges of sessions and sandboxes with capture enabled under this directory")
+	logLevel := flag.String("log-level", "info", "Minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
 	flag.Parse()
 
-	logger := log.New(os.Stderr, "[llm-proxy] ", log.LstdFlags)
-	store := session.NewMemoryStore()
-	srv := server.New(store, logger, *adminToken)
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	logger, err := logging.New(os.Stderr, level, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var store interface {
+		session.Store
+		session.Reaper
+	}
+	switch *storeKind {
+	case "memory":
+		store = session.NewMemoryStore()
+	case "file":
+		fileStore, err := session.NewFileStore(*storePath)
+		if err != nil {
+			return fatal(logger, "open session store", logging.Err(err))
+		}
+		defer fileStore.Close()
+		logger.Info("loaded sessions", "sessions", len(fileStore.List()), "path", *storePath)
+		store = fileStore
+	default:
+		return fatal(logger, "unknown -store (want memory or file)", "store", *storeKind)
+	}
+
+	var registry session.Store = store
+	if source := masterKeySource(*masterKeyFile); source != nil {
+		keys, err := keyring.New(source)
+		if err != nil {
+			return fatal(logger, "load master key", logging.Err(err))
+		}
+		encrypted, err := session.NewEncryptedStore(store, keys)
+		if err != nil {
+			return fatal(logger, "enable api key encryption", logging.Err(err))
+		}
+		logger.Info("api keys encrypted at rest", "key_id", keys.ActiveKeyID())
+		registry = encrypted
+	}
+
+	secret, err := tokenSecret(*tokenSecretFile)
+	if err != nil {
+		return fatal(logger, "load token secret", logging.Err(err))
+	}
+	if secret == nil {
+		if *storeKind == "file" {
+			return fatal(logger, "-store=file requires -token-secret-file or GHOSTPROXY_TOKEN_SECRET so stored token hashes survive restarts")
+		}
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fatal(logger, "generate token secret", logging.Err(err))
+		}
+	}
+
+	opts := []server.Option{server.WithTokenHasher(session.NewTokenHasher(secret))}
+
+	catalog := credential.NewMemoryCatalog()
+	if *credentialsFile != "" {
+		if err := catalog.LoadFile(*credentialsFile); err != nil {
+			return fatal(logger, "load credentials", logging.Err(err))
+		}
+	}
+	// Credentials created through the admin API live in the session store,
+	// sealed alongside session keys when encryption is enabled.
+	if backend, ok := registry.(credential.Backend); ok {
+		if err := catalog.Persist(backend); err != nil {
+			return fatal(logger, "load stored credentials", logging.Err(err))
+		}
+	}
+	opts = append(opts, server.WithCatalog(catalog))
+
+	if len(tokenKeys) > 0 {
+		verifier := signedtoken.NewVerifier(tokenKeys, *tokenMaxTTL)
+		// Revocations are kept in the session store too, so a killed
+		// token stays dead across restarts.
+		if backend, ok := registry.(signedtoken.Backend); ok {
+			if err := verifier.Revocations().Persist(backend); err != nil {
+				return fatal(logger, "load stored revocations", logging.Err(err))
+			}
+		}
+		logger.Info("accepting signed sandbox tokens", "keys", len(tokenKeys), "revocations", verifier.Revocations().Len())
+		opts = append(opts, server.WithSignedTokens(verifier))
+	}
+
+	if *priceTableFile != "" {
+		prices, err := budget.LoadPriceTable(*priceTableFile)
+		if err != nil {
+			return fatal(logger, "load price table", logging.Err(err))
+		}
+		logger.Info("loaded price table", "models", len(prices))
+		opts = append(opts, server.WithPriceTable(prices))
+	}
+
+	if *usageAccounting {
+		logger.Info("token usage accounting enabled")
+		opts = append(opts, server.WithUsage(usage.NewLedger(*usageRetention)))
+	}
+
+	if *accessLogPath != "" {
+		accessLog, err := accesslog.Open(*accessLogPath, accesslog.Rotation{
+			MaxSize:    *accessLogMaxSize << 20,
+			MaxAge:     *accessLogMaxAge,
+			MaxBackups: *accessLogMaxBackups,
+		})
+		if err != nil {
+			return fatal(logger, "open access log", logging.Err(err))
+		}
+		defer accessLog.Close()
+		logger.Info("writing access log", "path", *accessLogPath)
+		opts = append(opts, server.WithAccessLog(accessLog))
+	}
+
+	if *providersFile != "" {
+		configs, err := proxy.LoadProviderConfigs(*providersFile)
+		if err != nil {
+			return fatal(logger, "load providers", logging.Err(err))
+		}
+		for _, c := range configs {
+			prov, _ := c.Provider()
+			if err := proxy.Register(prov); err != nil {
+				return fatal(logger, "register provider", logging.Err(err))
+			}
+		}
+		logger.Info("loaded providers", "providers", len(configs))
+	}
+	for provider, urls := range upstreams {
+		logger.Info("provider upstreams", logging.KeyProvider, provider, "upstreams", urls)
+		opts = append(opts, server.WithUpstreams(provider, urls...))
+	}
+	if *circuitFailures < 1 || *circuitCoolDown <= 0 {
+		return fatal(logger, "-circuit-failures must be at least 1 and -circuit-cool-down positive")
+	}
+	opts = append(opts, server.WithCircuitBreaker(*circuitFailures, *circuitCoolDown))
+
+	if *retryAttempts > 1 {
+		statuses, err := parseStatuses(*retryStatuses)
+		if err != nil {
+			return fatal(logger, "invalid -retry-statuses", logging.Err(err))
+		}
+		policy := proxy.RetryPolicy{
+			MaxAttempts: *retryAttempts,
+			Statuses:    statuses,
+			BaseDelay:   *retryBaseDelay,
+			MaxDelay:    *retryMaxDelay,
+			MaxBodySize: *retryMaxBody << 20,
+		}
+		if err := policy.Validate(); err != nil {
+			return fatal(logger, "invalid retry policy", logging.Err(err))
+		}
+		logger.Info("retrying upstream failures", "attempts", policy.MaxAttempts, "statuses", *retryStatuses)
+		opts = append(opts, server.WithRetries(policy))
+	}
+
+	if *captureDir != "" {
+		recorder, err := capture.NewRecorder(*captureDir)
+		if err != nil {
+			return fatal(logger, "open capture directory", logging.Err(err))
+		}
+		logger.Info("capture available", "dir", *captureDir)
+		opts = append(opts, server.WithCapture(recorder))
+	}
+
+	var exporter *trace.OTLPExporter
+	if *otlpEndpoint != "" {
+		service := os.Getenv("OTEL_SERVICE_NAME")
+		if service == "" {
+			service = "llm-proxy"
+		}
+		logger.Info("exporting traces", "endpoint", *otlpEndpoint)
+		exporter = trace.NewOTLPExporter(*otlpEndpoint, service, logger)
+		opts = append(opts, server.WithTracer(trace.NewTracer(exporter)))
+	}
+
+	srv := server.New(registry, logger, *adminToken, opts...)
+
+	stopReaper := session.StartReaper(sandboxReaper{store, srv}, *reapInterval, func(n int) {
+		logger.Info("reaped expired sessions", "reaped", n)
+	})
+	defer stopReaper()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("starting llm-proxy", "addr", *addr)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run(*addr) }()
+
+	var runErr error
+	select {
+	case runErr = <-errc:
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	}
+
+	if exporter != nil {
+		// Send the spans still queued, but never hang shutdown on an
+		// unreachable collector.
+		flushCtx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		if err := exporter.Shutdown(flushCtx); err != nil {
+			logger.Warn("error flushing traces", logging.Err(err))
+		}
+		cancel()
+	}
+	if runErr != nil {
+		return fatal(logger, "server error", logging.Err(runErr))
+	}
+	return 0
+}
+
+// traceFlushTimeout bounds how long shutdown waits for queued spans to be
+// exported.
+const traceFlushTimeout = 5 * time.Second
+
+// sandboxReaper also drops the metrics of sandboxes left with nothing to
+// act for on every pass, including passes that evict nothing: sessions also
+// expire on lookup, and signed tokens are never stored.
+type sandboxReaper struct {
+	session.Reaper
+	srv *server.Server
+}
+
+func (r sandboxReaper) Reap() int {
+	n := r.Reaper.Reap()
+	r.srv.ForgetEndedSandboxes()
+	return n
+}
+
+// fatal logs msg at error level and returns the exit status for run.
+func fatal(logger *slog.Logger, msg string, args ...any) int {
+	logger.Error(msg, args...)
+	return 1
+}
+
+// otlpTracesEndpoint derives the traces URL from the standard OpenTelemetry
+// environment variables, preferring the traces-specific one.
+func otlpTracesEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// masterKeySource picks where the master key comes from: the key file if
+// one was given, otherwise GHOSTPROXY_MASTER_KEY. Returns nil if neither is
+// configured, leaving encryption at rest disabled.
+func masterKeySource(path string) keyring.Source {
+	if path != "" {
+		return keyring.FileSource(path)
+	}
+	if os.Getenv("GHOSTPROXY_MASTER_KEY") != "" {
+		return keyring.EnvSource("GHOSTPROXY_MASTER_KEY")
+	}
+	return nil
+}
+
+// tokenSecret loads the session token hashing secret from the given file or
+// GHOSTPROXY_TOKEN_SECRET. Returns nil if neither is configured.
+func tokenSecret(path string) ([]byte, error) {
+	value := os.Getenv("GHOSTPROXY_TOKEN_SECRET")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		value = string(data)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if len(value) < 16 {
+		return nil, fmt.Errorf("token secret must be at least 16 characters")
+	}
+	return []byte(value), nil
+}
+
+// parseStatuses parses a comma-separated list of HTTP status codes.
+func parseStatuses(s string) ([]int, error) {
+	var statuses []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status %q", field)
+		}
+		statuses = append(statuses, code)
+	}
+	return statuses, nil
+}
 
-	logger.Printf("starting llm-proxy on %s", *addr)
-	if err := srv.Run(*addr); err != nil {
-		logger.Fatalf("server error: %v", err)
+// joinStatuses formats status codes for parseStatuses.
+func joinStatuses(statuses []int) string {
+	fields := make([]string, len(statuses))
+	for i, code := range statuses {
+		fields[i] = strconv.Itoa(code)
 	}
+	return strings.Join(fields, ",")
 }
@@ -0,0 +1,213 @@
+// Package accesslog writes one JSON line per proxied request to a file that
+// is rotated by size and age.
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-proxy/pkg/usage"
+)
+
+// backupTimeFormat is the suffix appended to rotated files. It sorts
+// chronologically.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// Entry is one access log line. It deliberately has no field that could
+// hold an API key or session token, and Path never includes the query
+// string.
+type Entry struct {
+	Time              time.Time    `json:"time"`
+	RequestID         string       `json:"request_id"`
+	UpstreamRequestID string       `json:"upstream_request_id,omitempty"`
+	SandboxID         string       `json:"sandbox_id,omitempty"`
+	Provider          string       `json:"provider,omitempty"`
+	Method            string       `json:"method"`
+	Path              string       `json:"path"`
+	Status            int          `json:"status"`
+	UpstreamStatus    int          `json:"upstream_status,omitempty"`
+	Attempts          int          `json:"attempts,omitempty"`
+	LatencyMS         float64      `json:"latency_ms"`
+	TTFBMS            float64      `json:"ttfb_ms,omitempty"`
+	BytesIn           int64        `json:"bytes_in"`
+	BytesOut          int64        `json:"bytes_out"`
+	Model             string       `json:"model,omitempty"`
+	Usage             *usage.Usage `json:"usage,omitempty"`
+	Error             string       `json:"error,omitempty"`
+}
+
+// Rotation controls when the log file is rotated and how many rotated
+// files are kept. Zero values disable the corresponding limit.
+type Rotation struct {
+	// MaxSize is the size in bytes at which the file is rotated.
+	MaxSize int64
+
+	// MaxAge is how long a file is written to before it is rotated,
+	// counted from when the proxy opened it.
+	MaxAge time.Duration
+
+	// MaxBackups is how many rotated files are kept; older ones are
+	// deleted.
+	MaxBackups int
+}
+
+// Log is an access log file. It is safe for concurrent use.
+type Log struct {
+	path     string
+	rotation Rotation
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// now is the rotation clock. Overridden in tests.
+	now func() time.Time
+}
+
+// Open opens (or creates) the access log at path, appending to any
+// existing content.
+func Open(path string, rotation Rotation) (*Log, error) {
+	l := &Log{path: path, rotation: rotation, now: time.Now}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write appends e as one JSON line, rotating the file first if it is due.
+func (l *Log) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode access log entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("access log is closed")
+	}
+	if l.due(int64(len(line))) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write access log: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// due reports whether writing n more bytes should first rotate the file.
+// A file is never rotated while empty. Callers must hold l.mu.
+func (l *Log) due(n int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.rotation.MaxSize > 0 && l.size+n > l.rotation.MaxSize {
+		return true
+	}
+	return l.rotation.MaxAge > 0 && !l.now().Before(l.opened.Add(l.rotation.MaxAge))
+}
+
+// open opens the log file for appending. Callers must hold l.mu (or have
+// exclusive access during Open).
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat access log: %w", err)
+	}
+	l.file, l.size, l.opened = file, info.Size(), l.now()
+	return nil
+}
+
+// rotate renames the current file aside with a timestamp suffix, opens a
+// fresh one and prunes old backups. If the rename fails the current file
+// is reopened, so the log stays usable. Callers must hold l.mu.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("close access log: %w", err)
+	}
+	l.file = nil
+
+	backup := l.path + "." + l.now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(l.path, backup); err != nil {
+		// Keep logging to the current file; rotation is retried on the
+		// next write.
+		err = fmt.Errorf("rotate access log: %w", err)
+		opened := l.opened
+		if openErr := l.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		l.opened = opened
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	return l.prune()
+}
+
+// prune deletes the oldest rotated files beyond MaxBackups.
+func (l *Log) prune() error {
+	if l.rotation.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := l.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > l.rotation.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove old access log: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups lists rotated files of this log, oldest first.
+func (l *Log) backups() ([]string, error) {
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("list rotated access logs: %w", err)
+	}
+	var backups []string
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, l.path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
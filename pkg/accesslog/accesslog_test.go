@@ -0,0 +1,197 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"llm-proxy/pkg/usage"
+)
+
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("decode line %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestWriteAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, Rotation{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	want := Entry{
+		Time:      time.Unix(1700000000, 0).UTC(),
+		RequestID: "req-1",
+		SandboxID: "sandbox-x",
+		Provider:  "anthropic",
+		Method:    "POST",
+		Path:      "/v1/messages",
+		Status:    200,
+		Model:     "claude-sonnet-4-5",
+		Usage:     &usage.Usage{InputTokens: 10, OutputTokens: 4},
+	}
+	if err := l.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := l.Write(Entry{RequestID: "req-2", Status: 502, Error: "connection refused"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	l.Close()
+
+	// Reopening appends rather than truncating.
+	l, err = Open(path, Rotation{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	l.Write(Entry{RequestID: "req-3"})
+	l.Close()
+
+	entries := readEntries(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	got := entries[0]
+	if got.RequestID != want.RequestID || got.Model != want.Model || got.Usage == nil || *got.Usage != *want.Usage {
+		t.Fatalf("entry = %+v, want %+v", got, want)
+	}
+	if entries[1].Usage != nil || entries[1].Error != "connection refused" {
+		t.Fatalf("entry without usage = %+v", entries[1])
+	}
+	if err := l.Write(Entry{}); err == nil {
+		t.Fatal("Write after Close succeeded")
+	}
+}
+
+func TestRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := Open(path, Rotation{MaxSize: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	clock := time.Unix(1700000000, 0)
+	l.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := l.Write(Entry{RequestID: strings.Repeat("x", 60)}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	l.Close()
+
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("kept %d rotated files, want 2: %v", len(backups), backups)
+	}
+	total := 0
+	for _, p := range append(backups, path) {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 200 {
+			t.Fatalf("%s is %d bytes, want at most 200", p, info.Size())
+		}
+		total += len(readEntries(t, p))
+	}
+	if total == 0 || total >= 10 {
+		t.Fatalf("%d entries across kept files; want some but not all of 10 after pruning", total)
+	}
+}
+
+func TestRotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, Rotation{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	clock := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return clock }
+	l.opened = clock
+
+	l.Write(Entry{RequestID: "old"})
+	clock = clock.Add(30 * time.Minute)
+	l.Write(Entry{RequestID: "still-current"})
+	clock = clock.Add(31 * time.Minute)
+	l.Write(Entry{RequestID: "new"})
+	l.Close()
+
+	current := readEntries(t, path)
+	if len(current) != 1 || current[0].RequestID != "new" {
+		t.Fatalf("current file = %+v, want only the entry after rotation", current)
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("got %d rotated files, want 1", len(backups))
+	}
+	if old := readEntries(t, backups[0]); len(old) != 2 {
+		t.Fatalf("rotated file has %d entries, want 2", len(old))
+	}
+}
+
+func TestFailedRotationKeepsLogging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, Rotation{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer l.Close()
+	clock := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return clock }
+	l.opened = clock
+
+	l.Write(Entry{RequestID: "first"})
+	clock = clock.Add(2 * time.Hour)
+	// A non-empty directory where the backup should go makes the rename fail.
+	blocked := path + "." + clock.UTC().Format(backupTimeFormat)
+	if err := os.MkdirAll(filepath.Join(blocked, "x"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(Entry{RequestID: "blocked"}); err == nil {
+		t.Fatal("Write succeeded although the rotation failed")
+	}
+
+	clock = clock.Add(time.Second)
+	if err := l.Write(Entry{RequestID: "after"}); err != nil {
+		t.Fatalf("Write after a failed rotation: %v", err)
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	var rotated []Entry
+	for _, b := range backups {
+		if b != blocked {
+			rotated = append(rotated, readEntries(t, b)...)
+		}
+	}
+	if len(rotated) != 1 || rotated[0].RequestID != "first" {
+		t.Fatalf("rotated entries = %+v, want the entry written before the failure", rotated)
+	}
+	if current := readEntries(t, path); len(current) != 1 || current[0].RequestID != "after" {
+		t.Fatalf("current file = %+v, want the entry written after the retried rotation", current)
+	}
+}
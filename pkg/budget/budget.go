@@ -0,0 +1,240 @@
+// Package budget enforces spending caps, in tokens and dollars, on
+// sessions and sandboxes.
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrExhausted is returned by Check when a budget has been used up.
+var ErrExhausted = errors.New("budget exhausted")
+
+// Limits caps total spend under one key. Zero fields are unlimited.
+type Limits struct {
+	// MaxTokens caps input, output and cache tokens combined.
+	MaxTokens int64 `json:"max_tokens,omitempty"`
+
+	// MaxCostUSD caps spend in US dollars, priced with the price table.
+	MaxCostUSD float64 `json:"max_cost_usd,omitempty"`
+}
+
+// Validate checks that l is well formed.
+func (l Limits) Validate() error {
+	if l.MaxTokens < 0 || l.MaxCostUSD < 0 {
+		return fmt.Errorf("budget must not be negative")
+	}
+	return nil
+}
+
+// IsZero reports whether l imposes no cap.
+func (l Limits) IsZero() bool {
+	return l.MaxTokens == 0 && l.MaxCostUSD == 0
+}
+
+// Scope is one account a request is charged to.
+type Scope struct {
+	// Key identifies the account, e.g. "session:<id>" or "sandbox:<id>".
+	Key string
+
+	// SandboxID is the sandbox the account belongs to, so sandbox-wide
+	// top-ups reach session accounts too.
+	SandboxID string
+
+	Limits Limits
+}
+
+// SessionKey is the account key of a session, identified as in usage
+// reports.
+func SessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+// SandboxKey is the account key shared by all sessions of a sandbox.
+func SandboxKey(sandboxID string) string {
+	return "sandbox:" + sandboxID
+}
+
+// Status is a point-in-time view of one account.
+type Status struct {
+	Key              string   `json:"key"`
+	Limits           Limits   `json:"limits"`
+	TopUpTokens      int64    `json:"top_up_tokens,omitempty"`
+	TopUpCostUSD     float64  `json:"top_up_cost_usd,omitempty"`
+	SpentTokens      int64    `json:"spent_tokens"`
+	SpentCostUSD     float64  `json:"spent_cost_usd"`
+	RemainingTokens  *int64   `json:"remaining_tokens,omitempty"`
+	RemainingCostUSD *float64 `json:"remaining_cost_usd,omitempty"`
+}
+
+// account is the spend tracked under one key.
+type account struct {
+	sandboxID string
+	limits    Limits
+
+	// Top-ups raise the allowance on top of limits.
+	extraTokens int64
+	extraUSD    float64
+
+	tokens  int64
+	costUSD float64
+}
+
+// exhausted reports whether either capped dimension is used up.
+func (a *account) exhausted() bool {
+	if a.limits.MaxTokens > 0 && a.tokens >= a.limits.MaxTokens+a.extraTokens {
+		return true
+	}
+	if a.limits.MaxCostUSD > 0 && a.costUSD >= a.limits.MaxCostUSD+a.extraUSD {
+		return true
+	}
+	return false
+}
+
+func (a *account) status(key string) Status {
+	st := Status{
+		Key:          key,
+		Limits:       a.limits,
+		TopUpTokens:  a.extraTokens,
+		TopUpCostUSD: a.extraUSD,
+		SpentTokens:  a.tokens,
+		SpentCostUSD: a.costUSD,
+	}
+	if a.limits.MaxTokens > 0 {
+		remaining := max(0, a.limits.MaxTokens+a.extraTokens-a.tokens)
+		st.RemainingTokens = &remaining
+	}
+	if a.limits.MaxCostUSD > 0 {
+		remaining := max(0, a.limits.MaxCostUSD+a.extraUSD-a.costUSD)
+		st.RemainingCostUSD = &remaining
+	}
+	return st
+}
+
+// Tracker holds the spend of every budgeted session and sandbox in memory.
+type Tracker struct {
+	mu       sync.Mutex
+	accounts map[string]*account
+}
+
+// NewTracker creates an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{accounts: make(map[string]*account)}
+}
+
+// account returns the account for sc, creating it and refreshing its
+// limits. Callers must hold t.mu.
+func (t *Tracker) account(sc Scope) *account {
+	a, ok := t.accounts[sc.Key]
+	if !ok {
+		a = &account{}
+		t.accounts[sc.Key] = a
+	}
+	a.sandboxID = sc.SandboxID
+	a.limits = sc.Limits
+	return a
+}
+
+// Check returns an error wrapping ErrExhausted if any scope's budget is
+// used up. Requests already in flight when a budget runs out still
+// complete, so spend can overshoot a cap by the cost of those requests.
+func (t *Tracker) Check(scopes ...Scope) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, sc := range scopes {
+		if sc.Key == "" || sc.Limits.IsZero() {
+			continue
+		}
+		if t.account(sc).exhausted() {
+			return fmt.Errorf("%w: %s", ErrExhausted, sc.Key)
+		}
+	}
+	return nil
+}
+
+// Charge records spend against every scope.
+func (t *Tracker) Charge(tokens int64, costUSD float64, scopes ...Scope) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, sc := range scopes {
+		if sc.Key == "" || sc.Limits.IsZero() {
+			continue
+		}
+		a := t.account(sc)
+		a.tokens += tokens
+		a.costUSD += costUSD
+	}
+}
+
+// TopUpSandbox raises the allowance of the sandbox account and of every
+// session account in the sandbox. With reset, spend and earlier top-ups are
+// cleared first. It returns the resulting status of each account.
+func (t *Tracker) TopUpSandbox(sandboxID string, tokens int64, costUSD float64, reset bool) []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if key := SandboxKey(sandboxID); t.accounts[key] == nil {
+		// Top-ups may arrive before the sandbox's first request.
+		t.accounts[key] = &account{sandboxID: sandboxID}
+	}
+
+	var result []Status
+	for key, a := range t.accounts {
+		if a.sandboxID != sandboxID {
+			continue
+		}
+		if reset {
+			a.tokens, a.costUSD = 0, 0
+			a.extraTokens, a.extraUSD = 0, 0
+		}
+		a.extraTokens += tokens
+		a.extraUSD += costUSD
+		result = append(result, a.status(key))
+	}
+	sortStatuses(result)
+	return result
+}
+
+// SandboxStatus returns the status of the sandbox account and every session
+// account in the sandbox.
+func (t *Tracker) SandboxStatus(sandboxID string) []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var result []Status
+	for key, a := range t.accounts {
+		if a.sandboxID == sandboxID {
+			result = append(result, a.status(key))
+		}
+	}
+	sortStatuses(result)
+	return result
+}
+
+// Remove drops one account, e.g. once its session is revoked.
+func (t *Tracker) Remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.accounts, key)
+}
+
+// Forget drops the accounts of a sandbox, e.g. once its sessions are
+// revoked.
+func (t *Tracker) Forget(sandboxID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, a := range t.accounts {
+		if a.sandboxID == sandboxID {
+			delete(t.accounts, key)
+		}
+	}
+}
+
+func sortStatuses(s []Status) {
+	sort.Slice(s, func(i, j int) bool { return s[i].Key < s[j].Key })
+}
@@ -0,0 +1,121 @@
+package budget
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLimits_Validate(t *testing.T) {
+	if err := (Limits{MaxTokens: -1}).Validate(); err == nil {
+		t.Fatal("negative max_tokens accepted")
+	}
+	if err := (Limits{MaxCostUSD: -0.5}).Validate(); err == nil {
+		t.Fatal("negative max_cost_usd accepted")
+	}
+	if err := (Limits{MaxTokens: 10, MaxCostUSD: 1}).Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+}
+
+func TestTracker_CheckAndCharge(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		tokens int64
+		cost   float64
+	}{
+		{name: "tokens", limits: Limits{MaxTokens: 100}, tokens: 60},
+		{name: "dollars", limits: Limits{MaxCostUSD: 1}, cost: 0.6},
+		{name: "either exhausts", limits: Limits{MaxTokens: 1000, MaxCostUSD: 1}, tokens: 1, cost: 0.6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTracker()
+			scope := Scope{Key: SessionKey("s"), SandboxID: "x", Limits: tt.limits}
+
+			for i := range 2 {
+				if err := tr.Check(scope); err != nil {
+					t.Fatalf("request %d: Check() error = %v", i, err)
+				}
+				tr.Charge(tt.tokens, tt.cost, scope)
+			}
+			if err := tr.Check(scope); !errors.Is(err, ErrExhausted) {
+				t.Fatalf("Check() after overspend = %v, want ErrExhausted", err)
+			}
+		})
+	}
+}
+
+func TestTracker_UnlimitedScopesIgnored(t *testing.T) {
+	tr := NewTracker()
+	tr.Charge(1000, 10, Scope{Key: "session:s"})
+	if err := tr.Check(Scope{Key: "session:s"}); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got := tr.SandboxStatus(""); len(got) != 0 {
+		t.Fatalf("unlimited scope created accounts: %+v", got)
+	}
+}
+
+func TestTracker_TopUpSandbox(t *testing.T) {
+	tr := NewTracker()
+	session := Scope{Key: SessionKey("s"), SandboxID: "x", Limits: Limits{MaxTokens: 10}}
+	sandbox := Scope{Key: SandboxKey("x"), SandboxID: "x", Limits: Limits{MaxTokens: 50}}
+	other := Scope{Key: SandboxKey("y"), SandboxID: "y", Limits: Limits{MaxTokens: 10}}
+
+	tr.Charge(10, 0, session, sandbox)
+	tr.Charge(10, 0, other)
+	if err := tr.Check(session, sandbox); err == nil {
+		t.Fatal("exhausted session budget admitted")
+	}
+
+	statuses := tr.TopUpSandbox("x", 5, 0, false)
+	if len(statuses) != 2 {
+		t.Fatalf("TopUpSandbox() touched %d accounts, want 2", len(statuses))
+	}
+	if err := tr.Check(session, sandbox); err != nil {
+		t.Fatalf("Check() after top-up = %v", err)
+	}
+	if err := tr.Check(other); err == nil {
+		t.Fatal("top-up leaked into another sandbox")
+	}
+
+	tr.Charge(5, 0, session, sandbox)
+	st := tr.TopUpSandbox("x", 0, 0, true)
+	for _, s := range st {
+		if s.SpentTokens != 0 || s.TopUpTokens != 0 {
+			t.Fatalf("reset left state behind: %+v", s)
+		}
+	}
+	if st[1].Key != SessionKey("s") || *st[1].RemainingTokens != 10 {
+		t.Fatalf("session status after reset = %+v", st[1])
+	}
+}
+
+func TestTracker_TopUpBeforeFirstRequest(t *testing.T) {
+	tr := NewTracker()
+	tr.TopUpSandbox("x", 100, 0, false)
+
+	sandbox := Scope{Key: SandboxKey("x"), SandboxID: "x", Limits: Limits{MaxTokens: 10}}
+	tr.Charge(50, 0, sandbox)
+	if err := tr.Check(sandbox); err != nil {
+		t.Fatalf("Check() = %v, want early top-up honoured", err)
+	}
+}
+
+func TestTracker_ForgetAndRemove(t *testing.T) {
+	tr := NewTracker()
+	a := Scope{Key: SessionKey("a"), SandboxID: "x", Limits: Limits{MaxTokens: 1}}
+	b := Scope{Key: SessionKey("b"), SandboxID: "x", Limits: Limits{MaxTokens: 1}}
+	tr.Charge(1, 0, a, b)
+
+	tr.Remove(a.Key)
+	if got := tr.SandboxStatus("x"); len(got) != 1 || got[0].Key != b.Key {
+		t.Fatalf("SandboxStatus() after Remove = %+v", got)
+	}
+	tr.Forget("x")
+	if got := tr.SandboxStatus("x"); len(got) != 0 {
+		t.Fatalf("SandboxStatus() after Forget = %+v", got)
+	}
+}
@@ -0,0 +1,74 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"llm-proxy/pkg/usage"
+)
+
+// Price is what one model charges, in US dollars per million tokens.
+type Price struct {
+	Input         float64 `json:"input"`
+	Output        float64 `json:"output"`
+	CacheRead     float64 `json:"cache_read,omitempty"`
+	CacheCreation float64 `json:"cache_creation,omitempty"`
+}
+
+// PriceTable maps model names to prices. A key matches a model exactly or
+// as a prefix, so "claude-sonnet-4-5" also prices dated snapshots such as
+// "claude-sonnet-4-5-20250929"; the longest matching key wins. The key "*"
+// prices any model not otherwise listed.
+type PriceTable map[string]Price
+
+// LoadPriceTable reads a JSON price table from path.
+func LoadPriceTable(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read price table: %w", err)
+	}
+	var table PriceTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("parse price table: %w", err)
+	}
+	for model, p := range table {
+		if p.Input < 0 || p.Output < 0 || p.CacheRead < 0 || p.CacheCreation < 0 {
+			return nil, fmt.Errorf("price table: negative price for %q", model)
+		}
+	}
+	return table, nil
+}
+
+// Lookup returns the price for model.
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+	best := ""
+	for key := range t {
+		if key != "*" && strings.HasPrefix(model, key) && len(key) > len(best) {
+			best = key
+		}
+	}
+	if best != "" {
+		return t[best], true
+	}
+	p, ok := t["*"]
+	return p, ok
+}
+
+// Cost returns the dollar cost of u on model. ok is false if the model has
+// no price.
+func (t PriceTable) Cost(model string, u usage.Usage) (cost float64, ok bool) {
+	p, ok := t.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+	cost = float64(u.InputTokens)*p.Input +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.CacheReadTokens)*p.CacheRead +
+		float64(u.CacheCreationTokens)*p.CacheCreation
+	return cost / 1e6, true
+}
@@ -0,0 +1,64 @@
+package budget
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"llm-proxy/pkg/usage"
+)
+
+func TestPriceTable_Cost(t *testing.T) {
+	table := PriceTable{
+		"claude-sonnet-4":   {Input: 3, Output: 15, CacheRead: 0.3, CacheCreation: 3.75},
+		"claude-sonnet-4-5": {Input: 4, Output: 20},
+		"*":                 {Input: 1, Output: 1},
+	}
+	u := usage.Usage{InputTokens: 1_000_000, OutputTokens: 100_000, CacheReadTokens: 1_000_000, CacheCreationTokens: 0}
+
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		{"claude-sonnet-4", 3 + 1.5 + 0.3},
+		{"claude-sonnet-4-20250514", 3 + 1.5 + 0.3},
+		{"claude-sonnet-4-5-20250929", 4 + 2},
+		{"gpt-4o", 1 + 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, ok := table.Cost(tt.model, u)
+			if !ok || math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("Cost(%q) = %v, %v; want %v", tt.model, got, ok, tt.want)
+			}
+		})
+	}
+
+	delete(table, "*")
+	if _, ok := table.Cost("gpt-4o", u); ok {
+		t.Fatal("unpriced model reported a cost")
+	}
+	if _, ok := PriceTable(nil).Cost("gpt-4o", u); ok {
+		t.Fatal("nil table reported a cost")
+	}
+}
+
+func TestLoadPriceTable(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "prices.json")
+	os.WriteFile(good, []byte(`{"gpt-4o":{"input":2.5,"output":10,"cache_read":1.25}}`), 0o600)
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte(`{"gpt-4o":{"input":-1}}`), 0o600)
+
+	table, err := LoadPriceTable(good)
+	if err != nil {
+		t.Fatalf("LoadPriceTable() error = %v", err)
+	}
+	if p, _ := table.Lookup("gpt-4o"); p.CacheRead != 1.25 {
+		t.Fatalf("Lookup() = %+v", p)
+	}
+	if _, err := LoadPriceTable(bad); err == nil {
+		t.Fatal("negative price accepted")
+	}
+}
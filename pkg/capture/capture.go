@@ -0,0 +1,308 @@
+// Package capture archives the exact bytes of proxied exchanges, with
+// credentials stripped, and serves them back as a fake upstream.
+package capture
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxBodySize is how much of each request and response body is kept.
+// Longer bodies are truncated and marked as such.
+const MaxBodySize = 32 << 20
+
+// fileTimeFormat prefixes capture file names so they sort chronologically.
+const fileTimeFormat = "20060102T150405.000000000Z"
+
+// strippedHeaders never reach the archive: they carry session tokens, real
+// API keys or cookies.
+var strippedHeaders = []string{
+	"Authorization", "Proxy-Authorization", "X-Api-Key", "Api-Key",
+	"X-Goog-Api-Key", "Cookie", "Set-Cookie",
+	"X-Amz-Security-Token", "X-Amz-Content-Sha256",
+}
+
+// strippedParams are query parameters removed for the same reason.
+var strippedParams = []string{"key", "api_key", "api-key", "token", "access_token", "sig"}
+
+// Exchange is one captured request and response.
+type Exchange struct {
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+	SandboxID string    `json:"sandbox_id,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	Request   Request   `json:"request"`
+	Response  Response  `json:"response"`
+}
+
+// Request is the request as the sandbox sent it, minus credentials.
+type Request struct {
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Query     string      `json:"query,omitempty"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      Data        `json:"body"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// Response is the upstream response as relayed, minus cookies. Each chunk
+// is one read from the upstream body, with its offset from when the
+// response headers arrived.
+type Response struct {
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header,omitempty"`
+	Chunks    []Chunk     `json:"chunks"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// Chunk is one piece of a response body.
+type Chunk struct {
+	OffsetMS float64 `json:"offset_ms"`
+	Data     Data    `json:"data"`
+}
+
+// Data is raw bytes. It is stored as a JSON string when it is valid UTF-8,
+// so captures stay readable, and as {"base64": "..."} otherwise.
+type Data []byte
+
+// MarshalJSON implements json.Marshaler.
+func (d Data) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(d) {
+		return json.Marshal(string(d))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(d)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Data) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*d = Data(s)
+		return nil
+	}
+	var enc struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(b, &enc); err != nil {
+		return fmt.Errorf("capture data: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc.Base64)
+	if err != nil {
+		return fmt.Errorf("capture data: %w", err)
+	}
+	*d = raw
+	return nil
+}
+
+// StripHeaders returns a copy of h without credential-bearing headers.
+func StripHeaders(h http.Header) http.Header {
+	clean := h.Clone()
+	for _, k := range strippedHeaders {
+		clean.Del(k)
+	}
+	return clean
+}
+
+// StripQuery removes credential-bearing parameters from a raw query.
+func StripQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// Keep nothing rather than risk keeping a key.
+		return ""
+	}
+	for _, k := range strippedParams {
+		values.Del(k)
+	}
+	return values.Encode()
+}
+
+// Recorder writes captures to a directory, one JSON file per exchange under
+// a subdirectory per sandbox. It also tracks which sandboxes have capture
+// switched on.
+type Recorder struct {
+	dir string
+
+	mu        sync.Mutex
+	sandboxes map[string]bool
+}
+
+// NewRecorder creates dir if needed and returns a recorder writing to it.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create capture directory: %w", err)
+	}
+	return &Recorder{dir: dir, sandboxes: make(map[string]bool)}, nil
+}
+
+// SetSandbox turns capture on or off for every session in a sandbox.
+func (r *Recorder) SetSandbox(sandboxID string, enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if enabled {
+		r.sandboxes[sandboxID] = true
+	} else {
+		delete(r.sandboxes, sandboxID)
+	}
+}
+
+// SandboxEnabled reports whether capture is on for a sandbox.
+func (r *Recorder) SandboxEnabled(sandboxID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sandboxes[sandboxID]
+}
+
+// Save writes ex to the archive and returns the file path.
+func (r *Recorder) Save(ex *Exchange) (string, error) {
+	sandbox := ex.SandboxID
+	if sandbox == "" {
+		sandbox = "no-sandbox"
+	}
+	dir := filepath.Join(r.dir, safeName(sandbox))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create capture directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encode capture: %w", err)
+	}
+	name := ex.Time.UTC().Format(fileTimeFormat) + "-" + safeName(ex.RequestID) + ".json"
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("write capture: %w", err)
+	}
+	return path, nil
+}
+
+// Load reads every capture under dir, oldest first.
+func Load(dir string) ([]*Exchange, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".json") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list captures: %w", err)
+	}
+
+	exchanges := make([]*Exchange, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read capture: %w", err)
+		}
+		var ex Exchange
+		if err := json.Unmarshal(data, &ex); err != nil {
+			return nil, fmt.Errorf("decode capture %s: %w", path, err)
+		}
+		exchanges = append(exchanges, &ex)
+	}
+	sort.SliceStable(exchanges, func(i, j int) bool {
+		return exchanges[i].Time.Before(exchanges[j].Time)
+	})
+	return exchanges, nil
+}
+
+// safeName makes s usable as a single path element.
+func safeName(s string) string {
+	clean := strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			return c
+		}
+		return '_'
+	}, s)
+	if clean == "" {
+		return "_"
+	}
+	return clean
+}
+
+// BodyWriter keeps up to MaxBodySize bytes written to it. It is safe for
+// concurrent use, since the HTTP transport writes request bodies on its
+// own goroutine.
+type BodyWriter struct {
+	mu        sync.Mutex
+	buf       []byte
+	truncated bool
+}
+
+// Write implements io.Writer. It never fails, so it is safe to use with
+// io.TeeReader.
+func (b *BodyWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	room := MaxBodySize - len(b.buf)
+	if len(p) > room {
+		b.buf = append(b.buf, p[:room]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns what was kept and whether anything was dropped.
+func (b *BodyWriter) Bytes() (Data, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append(Data(nil), b.buf...), b.truncated
+}
+
+// ChunkWriter records each write as a timed chunk, up to MaxBodySize bytes
+// in total.
+type ChunkWriter struct {
+	start     time.Time
+	size      int
+	chunks    []Chunk
+	truncated bool
+}
+
+// NewChunkWriter returns a writer timing chunks from start.
+func NewChunkWriter(start time.Time) *ChunkWriter {
+	return &ChunkWriter{start: start}
+}
+
+// Write implements io.Writer. It never fails.
+func (c *ChunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	data := p
+	if room := MaxBodySize - c.size; len(data) > room {
+		data = data[:room]
+		c.truncated = true
+	}
+	if len(data) > 0 {
+		c.chunks = append(c.chunks, Chunk{
+			OffsetMS: float64(time.Since(c.start).Microseconds()) / 1000,
+			Data:     append(Data(nil), data...),
+		})
+		c.size += len(data)
+	}
+	return len(p), nil
+}
+
+// Chunks returns the recorded chunks and whether anything was dropped.
+func (c *ChunkWriter) Chunks() ([]Chunk, bool) {
+	return c.chunks, c.truncated
+}
@@ -0,0 +1,142 @@
+package capture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data Data
+		want string
+	}{
+		{"text", Data("data: {\"x\":1}\n\n"), `"data: {\"x\":1}\n\n"`},
+		{"binary", Data{0xff, 0x00, 0x01}, `{"base64":"/wAB"}`},
+		{"empty", Data{}, `""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.data)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(b) != tt.want {
+				t.Fatalf("Marshal = %s, want %s", b, tt.want)
+			}
+			var back Data
+			if err := json.Unmarshal(b, &back); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if string(back) != string(tt.data) {
+				t.Fatalf("round trip = %q, want %q", back, tt.data)
+			}
+		})
+	}
+}
+
+func TestStripCredentials(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer session-abc")
+	h.Set("X-Api-Key", "session-abc")
+	h.Set("X-Goog-Api-Key", "AIza...")
+	h.Set("Content-Type", "application/json")
+	h.Set("Anthropic-Version", "2023-06-01")
+
+	clean := StripHeaders(h)
+	for _, k := range []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key"} {
+		if clean.Get(k) != "" {
+			t.Errorf("%s kept", k)
+		}
+	}
+	if clean.Get("Content-Type") == "" || clean.Get("Anthropic-Version") == "" {
+		t.Errorf("ordinary headers dropped: %v", clean)
+	}
+	if h.Get("Authorization") == "" {
+		t.Error("StripHeaders modified its input")
+	}
+
+	q, _ := url.ParseQuery(StripQuery("alt=sse&key=AIza-secret&api-version=2024-06-01"))
+	if q.Get("key") != "" || q.Get("alt") != "sse" || q.Get("api-version") != "2024-06-01" {
+		t.Fatalf("StripQuery kept %v", q)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"second", "first"} {
+		ex := &Exchange{
+			RequestID: id,
+			Time:      base.Add(time.Duration(1-i) * time.Second),
+			SandboxID: "../escape",
+			Request:   Request{Method: "POST", Path: "/v1/messages", Body: Data(`{"n":` + id + `}`)},
+			Response:  Response{Status: 200, Chunks: []Chunk{{OffsetMS: 1.5, Data: Data("hi")}}},
+		}
+		path, err := rec.Save(ex)
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if !strings.HasPrefix(path, dir) || strings.Contains(strings.TrimPrefix(path, dir), "..") {
+			t.Fatalf("capture written outside the directory: %s", path)
+		}
+	}
+
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].RequestID != "first" || loaded[1].RequestID != "second" {
+		t.Fatalf("Load order = %v", loaded)
+	}
+	if string(loaded[0].Response.Chunks[0].Data) != "hi" || loaded[0].Response.Chunks[0].OffsetMS != 1.5 {
+		t.Fatalf("chunk = %+v", loaded[0].Response.Chunks[0])
+	}
+}
+
+func TestSandboxToggle(t *testing.T) {
+	rec, err := NewRecorder(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.SetSandbox("sb-1", true)
+	if !rec.SandboxEnabled("sb-1") || rec.SandboxEnabled("sb-2") {
+		t.Fatal("SandboxEnabled after enabling sb-1")
+	}
+	rec.SetSandbox("sb-1", false)
+	if rec.SandboxEnabled("sb-1") {
+		t.Fatal("sb-1 still enabled")
+	}
+}
+
+func TestWritersTruncate(t *testing.T) {
+	big := make([]byte, MaxBodySize-10)
+
+	var body BodyWriter
+	body.Write(big)
+	if n, _ := body.Write(make([]byte, 20)); n != 20 {
+		t.Fatalf("Write returned %d, want the full length so tees continue", n)
+	}
+	data, truncated := body.Bytes()
+	if len(data) != MaxBodySize || !truncated {
+		t.Fatalf("BodyWriter kept %d bytes, truncated=%v", len(data), truncated)
+	}
+
+	chunks := NewChunkWriter(time.Now())
+	chunks.Write(big)
+	chunks.Write(make([]byte, 20))
+	chunks.Write([]byte("dropped"))
+	got, truncated := chunks.Chunks()
+	if len(got) != 2 || len(got[1].Data) != 10 || !truncated {
+		t.Fatalf("ChunkWriter kept %d chunks, truncated=%v", len(got), truncated)
+	}
+}
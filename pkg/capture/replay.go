@@ -0,0 +1,138 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Replayer serves captured exchanges as a fake upstream. A request is
+// answered with the oldest unserved capture whose method, path and body
+// match exactly; failing that, the oldest unserved capture with the same
+// method and path. Once every candidate has been served, the last one is
+// served again, so a test can repeat a call.
+type Replayer struct {
+	exchanges []*Exchange
+	realtime  bool
+
+	mu     sync.Mutex
+	served map[*Exchange]bool
+}
+
+// ReplayOption configures a Replayer.
+type ReplayOption func(*Replayer)
+
+// WithRealtime reproduces the captured delay before each response chunk
+// instead of sending the body at once.
+func WithRealtime() ReplayOption {
+	return func(r *Replayer) {
+		r.realtime = true
+	}
+}
+
+// NewReplayer serves exchanges, which should be ordered oldest first as
+// returned by Load.
+func NewReplayer(exchanges []*Exchange, opts ...ReplayOption) *Replayer {
+	r := &Replayer{exchanges: exchanges, served: make(map[*Exchange]bool)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, `{"error":"read request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ex := r.match(req.Method, req.URL.Path, body)
+	if ex == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":  "no capture matches request",
+			"method": req.Method,
+			"path":   req.URL.Path,
+		})
+		return
+	}
+
+	for k, vv := range ex.Response.Header {
+		// The body is re-sent whole, so its original framing no longer
+		// applies.
+		if k == "Content-Length" || k == "Transfer-Encoding" {
+			continue
+		}
+		w.Header()[k] = vv
+	}
+	w.WriteHeader(ex.Response.Status)
+
+	start := time.Now()
+	flusher, _ := w.(http.Flusher)
+	for _, c := range ex.Response.Chunks {
+		if r.realtime {
+			at := start.Add(time.Duration(c.OffsetMS * float64(time.Millisecond)))
+			select {
+			case <-time.After(time.Until(at)):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		if _, err := w.Write(c.Data); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// match picks the capture to serve and marks it served.
+func (r *Replayer) match(method, path string, body []byte) *Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sum := sha256.Sum256(body)
+	var exact, loose, lastExact, lastLoose *Exchange
+	for _, ex := range r.exchanges {
+		if ex.Request.Method != method || ex.Request.Path != path {
+			continue
+		}
+		sameBody := !ex.Request.Truncated && sha256.Sum256(ex.Request.Body) == sum
+		if sameBody {
+			lastExact = ex
+		}
+		lastLoose = ex
+		if r.served[ex] {
+			continue
+		}
+		if sameBody && exact == nil {
+			exact = ex
+		}
+		if loose == nil {
+			loose = ex
+		}
+	}
+
+	var chosen *Exchange
+	switch {
+	case exact != nil:
+		chosen = exact
+	case lastExact != nil:
+		chosen = lastExact
+	case loose != nil:
+		chosen = loose
+	default:
+		chosen = lastLoose
+	}
+	if chosen != nil {
+		r.served[chosen] = true
+	}
+	return chosen
+}
@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captured(id, path, body, reply string) *Exchange {
+	return &Exchange{
+		RequestID: id,
+		Request:   Request{Method: http.MethodPost, Path: path, Body: Data(body)},
+		Response: Response{
+			Status: http.StatusOK,
+			Header: http.Header{"Content-Type": {"text/event-stream"}, "Content-Length": {"999"}},
+			Chunks: []Chunk{{OffsetMS: 0, Data: Data(reply[:2])}, {OffsetMS: 30, Data: Data(reply[2:])}},
+		},
+	}
+}
+
+func TestReplayerMatching(t *testing.T) {
+	r := NewReplayer([]*Exchange{
+		captured("a", "/v1/messages", `{"q":1}`, "one-a"),
+		captured("b", "/v1/messages", `{"q":2}`, "two-b"),
+		captured("c", "/v1/messages", `{"q":1}`, "one-c"),
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	call := func(path, body string) (int, string) {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	steps := []struct {
+		path, body string
+		wantStatus int
+		want       string
+	}{
+		{"/v1/messages", `{"q":2}`, 200, "two-b"}, // exact body match, out of order
+		{"/v1/messages", `{"q":1}`, 200, "one-a"}, // oldest exact match
+		{"/v1/messages", `{"q":1}`, 200, "one-c"}, // next exact match
+		{"/v1/messages", `{"q":1}`, 200, "one-c"}, // exhausted: last exact match repeats
+		{"/v1/messages", `{"q":3}`, 200, "one-c"}, // no body match: same path
+		{"/v1/other", `{}`, 404, ""},
+	}
+	for i, st := range steps {
+		status, body := call(st.path, st.body)
+		if status != st.wantStatus {
+			t.Fatalf("step %d: status = %d, want %d", i, status, st.wantStatus)
+		}
+		if st.want != "" && body != st.want {
+			t.Fatalf("step %d: body = %q, want %q", i, body, st.want)
+		}
+	}
+}
+
+func TestReplayerRealtime(t *testing.T) {
+	srv := httptest.NewServer(NewReplayer([]*Exchange{captured("a", "/v1/messages", "", "hello")}, WithRealtime()))
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Post(srv.URL+"/v1/messages", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+
+	if string(b) != "hello" {
+		t.Fatalf("body = %q", b)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("replayed in %s, want the captured 30ms delay", elapsed)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" || resp.ContentLength == 999 {
+		t.Fatalf("headers = %v, length %d", resp.Header, resp.ContentLength)
+	}
+}
@@ -0,0 +1,315 @@
+// Package credential holds the server-side catalog of real provider API
+// keys. Sessions and signed tokens reference catalog entries by ID so the
+// key itself never travels with them.
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when no credential has the requested ID.
+	ErrNotFound = errors.New("credential not found")
+
+	// ErrExists is returned by Create when the ID is already taken.
+	ErrExists = errors.New("credential already exists")
+)
+
+// Credential is a named provider API key, or a pool of interchangeable
+// keys for the same provider account.
+type Credential struct {
+	// ID is the catalog key sessions and tokens refer to.
+	ID string `json:"id"`
+
+	// Provider is the LLM provider the key belongs to.
+	Provider string `json:"provider"`
+
+	// APIKey is the real provider API key. Shorthand for a single-key pool;
+	// set either APIKey or Keys.
+	APIKey string `json:"api_key,omitempty"`
+
+	// Keys is the pool of real API keys requests are spread across.
+	Keys []Key `json:"keys,omitempty"`
+
+	// Strategy selects how pooled keys are picked: StrategyRoundRobin
+	// (default), StrategyLeastInFlight or StrategyWeighted.
+	Strategy string `json:"strategy,omitempty"`
+
+	// UpdatedAt is when the credential was last created or replaced.
+	// Maintained by the catalog.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Catalog defines the interface for credential management. Entries are
+// resolved on every proxied request, so replacing one takes effect
+// immediately for every session that references it.
+type Catalog interface {
+	// Get returns the credential with the given ID.
+	Get(id string) (*Credential, error)
+
+	// Create adds a credential, failing with ErrExists if the ID is taken.
+	Create(cred *Credential) error
+
+	// Put adds or replaces a credential.
+	Put(cred *Credential) error
+
+	// Delete removes a credential. Deleting an unknown ID is not an error.
+	Delete(id string) error
+
+	// List returns all credentials ordered by ID.
+	List() []*Credential
+
+	// Acquire checks a key out of the credential's pool for one request.
+	// The lease must be released with the upstream outcome.
+	Acquire(id string) (*Lease, error)
+
+	// Stats returns per-key health counters for a credential.
+	Stats(id string) ([]KeyStats, error)
+}
+
+// poolKeys returns the credential's keys, expanding the APIKey shorthand.
+func (c *Credential) poolKeys() []Key {
+	if len(c.Keys) > 0 {
+		return c.Keys
+	}
+	return []Key{{Value: c.APIKey, Weight: 1}}
+}
+
+// validate checks that a credential is well formed.
+func (c *Credential) validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("credential id cannot be empty")
+	}
+	if c.Provider == "" || (c.APIKey == "") == (len(c.Keys) == 0) {
+		return fmt.Errorf("credential %s needs a provider and exactly one of api_key or keys", c.ID)
+	}
+	for _, k := range c.Keys {
+		if k.Value == "" {
+			return fmt.Errorf("credential %s has an empty key", c.ID)
+		}
+		if k.Weight < 0 {
+			return fmt.Errorf("credential %s has a negative key weight", c.ID)
+		}
+	}
+	switch c.Strategy {
+	case "", StrategyRoundRobin, StrategyLeastInFlight, StrategyWeighted:
+	default:
+		return fmt.Errorf("credential %s has unknown strategy %q", c.ID, c.Strategy)
+	}
+	return nil
+}
+
+// catalogEntry is a credential plus the live key pool built from it.
+type catalogEntry struct {
+	cred *Credential
+	pool *Pool
+}
+
+// Backend persists catalog entries. Values are JSON-encoded credentials,
+// API keys included, so the backend is responsible for protecting them at
+// rest. The session stores implement Backend, sealing values when API key
+// encryption is enabled.
+type Backend interface {
+	PutSecret(id string, value []byte) error
+	DeleteSecret(id string) error
+	Secrets() (map[string][]byte, error)
+}
+
+// backendPrefix namespaces credentials among a backend's secrets.
+const backendPrefix = "credential/"
+
+// MemoryCatalog is a thread-safe in-memory credential catalog, optionally
+// written through to a Backend.
+type MemoryCatalog struct {
+	mu      sync.RWMutex
+	entries map[string]*catalogEntry
+	backend Backend
+}
+
+// NewMemoryCatalog creates an empty catalog.
+func NewMemoryCatalog() *MemoryCatalog {
+	return &MemoryCatalog{
+		entries: make(map[string]*catalogEntry),
+	}
+}
+
+// Create adds a credential. The existence check and the insert happen
+// under one lock, so of two concurrent creates for an ID exactly one wins.
+func (c *MemoryCatalog) Create(cred *Credential) error {
+	return c.put(cred, false)
+}
+
+// Put adds or replaces a credential. Health state is kept for keys that
+// are still present in the replacement.
+func (c *MemoryCatalog) Put(cred *Credential) error {
+	return c.put(cred, true)
+}
+
+func (c *MemoryCatalog) put(cred *Credential, replace bool) error {
+	if err := cred.validate(); err != nil {
+		return err
+	}
+
+	stored := *cred
+	stored.Keys = append([]Key(nil), cred.Keys...)
+	stored.UpdatedAt = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[cred.ID]; ok && !replace {
+		return fmt.Errorf("%w: %s", ErrExists, cred.ID)
+	}
+	if c.backend != nil {
+		data, err := json.Marshal(&stored)
+		if err != nil {
+			return fmt.Errorf("encode credential: %w", err)
+		}
+		if err := c.backend.PutSecret(backendPrefix+stored.ID, data); err != nil {
+			return fmt.Errorf("store credential: %w", err)
+		}
+	}
+	var prev *Pool
+	if old, ok := c.entries[cred.ID]; ok {
+		prev = old.pool
+	}
+	c.entries[cred.ID] = &catalogEntry{
+		cred: &stored,
+		pool: newPool(stored.Strategy, stored.poolKeys(), prev),
+	}
+	return nil
+}
+
+// Get returns a copy of the credential with the given ID.
+func (c *MemoryCatalog) Get(id string) (*Credential, error) {
+	entry, err := c.entry(id)
+	if err != nil {
+		return nil, err
+	}
+	found := *entry.cred
+	return &found, nil
+}
+
+// Acquire checks a key out of the credential's pool.
+func (c *MemoryCatalog) Acquire(id string) (*Lease, error) {
+	entry, err := c.entry(id)
+	if err != nil {
+		return nil, err
+	}
+	return entry.pool.Acquire()
+}
+
+// Stats returns per-key health counters for a credential.
+func (c *MemoryCatalog) Stats(id string) ([]KeyStats, error) {
+	entry, err := c.entry(id)
+	if err != nil {
+		return nil, err
+	}
+	return entry.pool.Stats(), nil
+}
+
+// Delete removes a credential.
+func (c *MemoryCatalog) Delete(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[id]; !ok {
+		return nil
+	}
+	if c.backend != nil {
+		if err := c.backend.DeleteSecret(backendPrefix + id); err != nil {
+			return fmt.Errorf("delete stored credential: %w", err)
+		}
+	}
+	delete(c.entries, id)
+	return nil
+}
+
+// List returns copies of all credentials ordered by ID.
+func (c *MemoryCatalog) List() []*Credential {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make([]*Credential, 0, len(c.entries))
+	for _, entry := range c.entries {
+		found := *entry.cred
+		result = append(result, &found)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (c *MemoryCatalog) entry(id string) (*catalogEntry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return entry, nil
+}
+
+// LoadFile reads a JSON array of credentials from path into the catalog.
+func (c *MemoryCatalog) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read credentials file: %w", err)
+	}
+
+	var creds []*Credential
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return fmt.Errorf("parse credentials file: %w", err)
+	}
+	for _, cred := range creds {
+		if err := c.Put(cred); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Persist loads the credentials stored in backend, replacing entries with
+// the same ID (such as ones read by LoadFile), and from then on writes
+// every Create, Put and Delete through to backend before applying it.
+// Credentials loaded from a file are not written to backend; the file is
+// expected to be read again at every start.
+func (c *MemoryCatalog) Persist(backend Backend) error {
+	secrets, err := backend.Secrets()
+	if err != nil {
+		return fmt.Errorf("load stored credentials: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, value := range secrets {
+		if !strings.HasPrefix(id, backendPrefix) {
+			continue
+		}
+		var cred Credential
+		if err := json.Unmarshal(value, &cred); err != nil {
+			return fmt.Errorf("decode stored credential %s: %w", strings.TrimPrefix(id, backendPrefix), err)
+		}
+		if err := cred.validate(); err != nil {
+			return fmt.Errorf("stored credential: %w", err)
+		}
+		var prev *Pool
+		if old, ok := c.entries[cred.ID]; ok {
+			prev = old.pool
+		}
+		c.entries[cred.ID] = &catalogEntry{
+			cred: &cred,
+			pool: newPool(cred.Strategy, cred.poolKeys(), prev),
+		}
+	}
+	c.backend = backend
+	return nil
+}
@@ -0,0 +1,162 @@
+package credential
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryCatalog_PutGet(t *testing.T) {
+	c := NewMemoryCatalog()
+
+	if err := c.Put(&Credential{ID: "main", Provider: "anthropic", APIKey: "k1"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, err := c.Get("main")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.APIKey != "k1" {
+		t.Fatalf("APIKey = %q, want %q", got.APIKey, "k1")
+	}
+
+	if _, err := c.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryCatalog_PutValidates(t *testing.T) {
+	c := NewMemoryCatalog()
+
+	for _, cred := range []*Credential{
+		{Provider: "anthropic", APIKey: "k"},
+		{ID: "x", APIKey: "k"},
+		{ID: "x", Provider: "anthropic"},
+	} {
+		if err := c.Put(cred); err == nil {
+			t.Fatalf("Put(%+v) expected error", cred)
+		}
+	}
+}
+
+func TestMemoryCatalog_LoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	data := `[{"id":"a","provider":"anthropic","api_key":"ka"},{"id":"o","provider":"openai","api_key":"ko"}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewMemoryCatalog()
+	if err := c.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	got, err := c.Get("o")
+	if err != nil || got.Provider != "openai" {
+		t.Fatalf("Get(o) = %+v, %v", got, err)
+	}
+}
+
+func TestMemoryCatalog_ListDelete(t *testing.T) {
+	c := NewMemoryCatalog()
+	_ = c.Put(&Credential{ID: "b", Provider: "openai", APIKey: "kb"})
+	_ = c.Put(&Credential{ID: "a", Provider: "anthropic", APIKey: "ka"})
+
+	list := c.List()
+	if len(list) != 2 || list[0].ID != "a" || list[1].ID != "b" {
+		t.Fatalf("List() = %+v, want [a b]", list)
+	}
+
+	_ = c.Delete("a")
+	if _, err := c.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(a) after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryCatalog_CreateConcurrent(t *testing.T) {
+	c := NewMemoryCatalog()
+
+	const n = 16
+	errs := make(chan error, n)
+	for i := range n {
+		go func() {
+			errs <- c.Create(&Credential{ID: "main", Provider: "anthropic", APIKey: fmt.Sprintf("k%d", i)})
+		}()
+	}
+	created := 0
+	for range n {
+		switch err := <-errs; {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrExists):
+			t.Fatalf("Create() error = %v, want ErrExists", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%d concurrent creates succeeded, want 1", created)
+	}
+}
+
+// mapBackend is an in-memory Backend that can be made to fail.
+type mapBackend struct {
+	secrets map[string][]byte
+	fail    bool
+}
+
+func (b *mapBackend) PutSecret(id string, value []byte) error {
+	if b.fail {
+		return errors.New("disk full")
+	}
+	b.secrets[id] = value
+	return nil
+}
+
+func (b *mapBackend) DeleteSecret(id string) error {
+	if b.fail {
+		return errors.New("disk full")
+	}
+	delete(b.secrets, id)
+	return nil
+}
+
+func (b *mapBackend) Secrets() (map[string][]byte, error) {
+	return b.secrets, nil
+}
+
+func TestMemoryCatalog_Persist(t *testing.T) {
+	backend := &mapBackend{secrets: map[string][]byte{"other/x": []byte("not a credential")}}
+
+	c := NewMemoryCatalog()
+	_ = c.Put(&Credential{ID: "from-file", Provider: "openai", APIKey: "file-key"})
+	if err := c.Persist(backend); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	if len(backend.secrets) != 1 {
+		t.Fatalf("file credentials were written to the backend: %q", backend.secrets)
+	}
+	_ = c.Create(&Credential{ID: "a", Provider: "anthropic", APIKey: "ka"})
+	_ = c.Create(&Credential{ID: "b", Provider: "openai", Keys: []Key{{Value: "kb1"}, {Value: "kb2"}}})
+	_ = c.Put(&Credential{ID: "from-file", Provider: "openai", APIKey: "rotated-key"})
+	_ = c.Delete("b")
+
+	backend.fail = true
+	if err := c.Create(&Credential{ID: "c", Provider: "openai", APIKey: "kc"}); err == nil {
+		t.Fatal("Create() succeeded although the backend failed")
+	}
+	if _, err := c.Get("c"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("a credential the backend rejected was added")
+	}
+	backend.fail = false
+
+	// A restart: the file is read again, then the stored entries win.
+	restarted := NewMemoryCatalog()
+	_ = restarted.Put(&Credential{ID: "from-file", Provider: "openai", APIKey: "file-key"})
+	if err := restarted.Persist(backend); err != nil {
+		t.Fatalf("Persist() after restart error = %v", err)
+	}
+	list := restarted.List()
+	if len(list) != 2 || list[0].ID != "a" || list[0].APIKey != "ka" || list[1].APIKey != "rotated-key" {
+		t.Fatalf("List() after restart = %+v", list)
+	}
+}
@@ -0,0 +1,262 @@
+package credential
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Key selection strategies for credentials with more than one key.
+const (
+	// StrategyRoundRobin cycles through keys in order. The default.
+	StrategyRoundRobin = "round_robin"
+
+	// StrategyLeastInFlight picks the key with the fewest open requests.
+	StrategyLeastInFlight = "least_in_flight"
+
+	// StrategyWeighted spreads requests in proportion to key weights.
+	StrategyWeighted = "weighted"
+)
+
+const (
+	// unauthorizedEjection is how long a key rejected with 401 is skipped.
+	unauthorizedEjection = 5 * time.Minute
+
+	// rateLimitEjection is how long a key that hit 429 is skipped when the
+	// upstream gave no retry-after.
+	rateLimitEjection = 30 * time.Second
+)
+
+// Key is one real API key in a credential's pool.
+type Key struct {
+	// Value is the API key itself.
+	Value string `json:"value"`
+
+	// Weight is the relative share of traffic for StrategyWeighted.
+	// Defaults to 1.
+	Weight int `json:"weight,omitempty"`
+}
+
+// KeyStats is a point-in-time view of one pooled key's health. It never
+// includes the key value.
+type KeyStats struct {
+	// Fingerprint identifies the key without revealing it.
+	Fingerprint  string     `json:"fingerprint"`
+	Weight       int        `json:"weight"`
+	InFlight     int        `json:"in_flight"`
+	Requests     uint64     `json:"requests"`
+	Errors       uint64     `json:"errors"`
+	LastStatus   int        `json:"last_status,omitempty"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+}
+
+// pooledKey is a key plus its live counters.
+type pooledKey struct {
+	Key
+	fingerprint  string
+	current      int // smooth weighted round-robin state
+	inFlight     int
+	requests     uint64
+	errors       uint64
+	lastStatus   int
+	ejectedUntil time.Time
+}
+
+// Pool picks keys for outgoing requests and tracks their health.
+type Pool struct {
+	strategy string
+
+	mu   sync.Mutex
+	keys []*pooledKey
+	next int
+
+	// now is the clock used for ejection. Overridden in tests.
+	now func() time.Time
+}
+
+// newPool builds a pool for keys. State from prev is carried over for keys
+// whose value is unchanged, so replacing one key in a credential doesn't
+// reset the health of the others.
+func newPool(strategy string, keys []Key, prev *Pool) *Pool {
+	p := &Pool{strategy: strategy, now: time.Now}
+	if p.strategy == "" {
+		p.strategy = StrategyRoundRobin
+	}
+
+	carried := make(map[string]*pooledKey)
+	if prev != nil {
+		prev.mu.Lock()
+		for _, k := range prev.keys {
+			carried[k.Value] = k
+		}
+		prev.mu.Unlock()
+	}
+
+	for _, k := range keys {
+		if k.Weight <= 0 {
+			k.Weight = 1
+		}
+		pk := &pooledKey{Key: k, fingerprint: Fingerprint(k.Value)}
+		if old, ok := carried[k.Value]; ok {
+			pk.requests, pk.errors = old.requests, old.errors
+			pk.lastStatus, pk.ejectedUntil = old.lastStatus, old.ejectedUntil
+			// In-flight leases still point at the old entry and release
+			// against it; the new entry starts from zero.
+		}
+		p.keys = append(p.keys, pk)
+	}
+	return p
+}
+
+// Fingerprint returns a short non-reversible identifier for an API key.
+func Fingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Acquire picks a key according to the pool's strategy, skipping ejected
+// keys. If every key is ejected, the one whose ejection ends soonest is
+// used rather than failing outright; the upstream remains the authority on
+// whether it is usable. The returned lease must be released.
+func (p *Pool) Acquire() (*Lease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.keys) == 0 {
+		return nil, fmt.Errorf("credential has no keys")
+	}
+
+	now := p.now()
+	healthy := make([]*pooledKey, 0, len(p.keys))
+	for _, k := range p.keys {
+		if !now.Before(k.ejectedUntil) {
+			healthy = append(healthy, k)
+		}
+	}
+
+	var chosen *pooledKey
+	if len(healthy) == 0 {
+		chosen = p.keys[0]
+		for _, k := range p.keys[1:] {
+			if k.ejectedUntil.Before(chosen.ejectedUntil) {
+				chosen = k
+			}
+		}
+	} else {
+		chosen = p.pick(healthy)
+	}
+
+	chosen.inFlight++
+	chosen.requests++
+	return &Lease{Key: chosen.Value, Fingerprint: chosen.fingerprint, pool: p, key: chosen}, nil
+}
+
+// pick applies the strategy to a non-empty set of healthy keys. Callers
+// must hold p.mu.
+func (p *Pool) pick(healthy []*pooledKey) *pooledKey {
+	switch p.strategy {
+	case StrategyLeastInFlight:
+		// Start from the round-robin cursor so ties rotate.
+		start := p.next % len(healthy)
+		p.next++
+		best := healthy[start]
+		for i := 1; i < len(healthy); i++ {
+			k := healthy[(start+i)%len(healthy)]
+			if k.inFlight < best.inFlight {
+				best = k
+			}
+		}
+		return best
+
+	case StrategyWeighted:
+		// Smooth weighted round-robin: deterministic and evenly interleaved.
+		total := 0
+		var best *pooledKey
+		for _, k := range healthy {
+			k.current += k.Weight
+			total += k.Weight
+			if best == nil || k.current > best.current {
+				best = k
+			}
+		}
+		best.current -= total
+		return best
+
+	default:
+		k := healthy[p.next%len(healthy)]
+		p.next++
+		return k
+	}
+}
+
+// Stats returns the health counters of every key in the pool.
+func (p *Pool) Stats() []KeyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	stats := make([]KeyStats, len(p.keys))
+	for i, k := range p.keys {
+		stats[i] = KeyStats{
+			Fingerprint: k.fingerprint,
+			Weight:      k.Weight,
+			InFlight:    k.inFlight,
+			Requests:    k.requests,
+			Errors:      k.errors,
+			LastStatus:  k.lastStatus,
+		}
+		if now.Before(k.ejectedUntil) {
+			until := k.ejectedUntil
+			stats[i].EjectedUntil = &until
+		}
+	}
+	return stats
+}
+
+// Lease is a key checked out of a pool for one upstream request.
+type Lease struct {
+	// Key is the API key to inject.
+	Key string
+
+	// Fingerprint identifies Key in logs and stats.
+	Fingerprint string
+
+	pool *Pool
+	key  *pooledKey
+	once sync.Once
+}
+
+// Release returns the key to the pool with the upstream status (0 for a
+// transport error). Transport errors, auth failures, rate limits and 5xx
+// count as key errors; other 4xx are the caller's fault. A 401 ejects the
+// key for several minutes; a 429 ejects it for retryAfter, or a default
+// cool-down if the upstream gave none. Release is idempotent.
+func (l *Lease) Release(status int, retryAfter time.Duration) {
+	if l == nil || l.pool == nil {
+		return
+	}
+	l.once.Do(func() {
+		p := l.pool
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		k := l.key
+		k.inFlight--
+		k.lastStatus = status
+		if status == 0 || status == 401 || status == 403 || status == 429 || status >= 500 {
+			k.errors++
+		}
+
+		switch status {
+		case 401:
+			k.ejectedUntil = p.now().Add(unauthorizedEjection)
+		case 429:
+			if retryAfter <= 0 {
+				retryAfter = rateLimitEjection
+			}
+			k.ejectedUntil = p.now().Add(retryAfter)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package credential
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPool(strategy string, keys ...Key) (*Pool, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newPool(strategy, keys, nil)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+// acquireN checks out n keys, releasing each with status, and returns the
+// key values in order.
+func acquireN(t *testing.T, p *Pool, n, status int) []string {
+	t.Helper()
+	got := make([]string, n)
+	for i := range got {
+		lease, err := p.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		got[i] = lease.Key
+		lease.Release(status, 0)
+	}
+	return got
+}
+
+func TestPool_Strategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		keys     []Key
+		want     []string
+	}{
+		{
+			name: "round robin",
+			keys: []Key{{Value: "a"}, {Value: "b"}, {Value: "c"}},
+			want: []string{"a", "b", "c", "a", "b", "c"},
+		},
+		{
+			name:     "weighted",
+			strategy: StrategyWeighted,
+			keys:     []Key{{Value: "a", Weight: 3}, {Value: "b", Weight: 1}},
+			want:     []string{"a", "a", "b", "a", "a", "a", "b", "a"},
+		},
+		{
+			name:     "least in flight rotates ties",
+			strategy: StrategyLeastInFlight,
+			keys:     []Key{{Value: "a"}, {Value: "b"}},
+			want:     []string{"a", "b", "a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestPool(tt.strategy, tt.keys...)
+			got := acquireN(t, p, len(tt.want), 200)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("sequence = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPool_LeastInFlight(t *testing.T) {
+	p, _ := newTestPool(StrategyLeastInFlight, Key{Value: "a"}, Key{Value: "b"})
+
+	held, _ := p.Acquire()
+	for range 3 {
+		lease, _ := p.Acquire()
+		if lease.Key == held.Key {
+			t.Fatalf("Acquire() picked busy key %q", lease.Key)
+		}
+		lease.Release(200, 0)
+	}
+	held.Release(200, 0)
+}
+
+func TestPool_Ejection(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter time.Duration
+		ejectedFor time.Duration
+	}{
+		{name: "unauthorized", status: 401, ejectedFor: unauthorizedEjection},
+		{name: "rate limited with retry-after", status: 429, retryAfter: 10 * time.Second, ejectedFor: 10 * time.Second},
+		{name: "rate limited without retry-after", status: 429, ejectedFor: rateLimitEjection},
+		{name: "server error", status: 503},
+		{name: "client error", status: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, now := newTestPool("", Key{Value: "a"}, Key{Value: "b"})
+
+			lease, _ := p.Acquire()
+			lease.Release(tt.status, tt.retryAfter)
+
+			got := acquireN(t, p, 2, 200)
+			if tt.ejectedFor == 0 {
+				if got[0] != "b" || got[1] != "a" {
+					t.Fatalf("sequence = %v, want [b a]", got)
+				}
+				return
+			}
+			if got[0] != "b" || got[1] != "b" {
+				t.Fatalf("sequence while a is ejected = %v, want [b b]", got)
+			}
+
+			*now = now.Add(tt.ejectedFor)
+			got = acquireN(t, p, 2, 200)
+			if got[0] != "a" && got[1] != "a" {
+				t.Fatalf("sequence after ejection = %v, want a back in rotation", got)
+			}
+		})
+	}
+}
+
+func TestPool_AllEjectedFailsOpen(t *testing.T) {
+	p, _ := newTestPool("", Key{Value: "a"}, Key{Value: "b"})
+
+	a, _ := p.Acquire()
+	a.Release(401, 0)
+	b, _ := p.Acquire()
+	b.Release(429, time.Second)
+
+	lease, err := p.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if lease.Key != "b" {
+		t.Fatalf("Acquire() = %q, want the key whose ejection ends first", lease.Key)
+	}
+}
+
+func TestPool_Stats(t *testing.T) {
+	p, _ := newTestPool("", Key{Value: "a"}, Key{Value: "b", Weight: 2})
+
+	first, _ := p.Acquire()
+	second, _ := p.Acquire()
+	second.Release(500, 0)
+	second.Release(500, 0) // idempotent
+
+	stats := p.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("len(Stats()) = %d, want 2", len(stats))
+	}
+	if stats[0].Fingerprint != Fingerprint("a") || stats[0].InFlight != 1 || stats[0].Requests != 1 {
+		t.Fatalf("stats[0] = %+v", stats[0])
+	}
+	if stats[1].Weight != 2 || stats[1].InFlight != 0 || stats[1].Errors != 1 || stats[1].LastStatus != 500 {
+		t.Fatalf("stats[1] = %+v", stats[1])
+	}
+	if stats[1].EjectedUntil != nil {
+		t.Fatalf("5xx should not eject: %+v", stats[1])
+	}
+
+	first.Release(200, 0)
+	if stats := p.Stats(); stats[0].InFlight != 0 {
+		t.Fatalf("in-flight after release = %d, want 0", stats[0].InFlight)
+	}
+}
+
+func TestMemoryCatalog_PutKeepsKeyHealth(t *testing.T) {
+	c := NewMemoryCatalog()
+	if err := c.Put(&Credential{ID: "pool", Provider: "openai", Keys: []Key{{Value: "a"}, {Value: "b"}}}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	lease, _ := c.Acquire("pool")
+	lease.Release(401, 0)
+
+	if err := c.Put(&Credential{ID: "pool", Provider: "openai", Keys: []Key{{Value: "a"}, {Value: "c"}}}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	stats, err := c.Stats("pool")
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats[0].Errors != 1 || stats[0].EjectedUntil == nil {
+		t.Fatalf("kept key lost its health: %+v", stats[0])
+	}
+	if stats[1].Requests != 0 {
+		t.Fatalf("new key has stale counters: %+v", stats[1])
+	}
+}
+
+func TestMemoryCatalog_PutValidatesPool(t *testing.T) {
+	c := NewMemoryCatalog()
+
+	for _, cred := range []*Credential{
+		{ID: "x", Provider: "openai", APIKey: "k", Keys: []Key{{Value: "k2"}}},
+		{ID: "x", Provider: "openai", Keys: []Key{{Value: ""}}},
+		{ID: "x", Provider: "openai", Keys: []Key{{Value: "k", Weight: -1}}},
+		{ID: "x", Provider: "openai", APIKey: "k", Strategy: "random"},
+	} {
+		if err := c.Put(cred); err == nil {
+			t.Fatalf("Put(%+v) expected error", cred)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package eventstream decodes the AWS event stream framing
+// (application/vnd.amazon.eventstream) that Bedrock uses for streamed
+// responses. Each message is a length-prefixed frame of typed headers and
+// a payload, with CRC32 checksums over the prelude and the whole message.
+package eventstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ContentType is the Content-Type of an event stream.
+const ContentType = "application/vnd.amazon.eventstream"
+
+// MaxMessageSize caps the size of a single message.
+const MaxMessageSize = 16 << 20
+
+// preludeLen is the total length, headers length and prelude CRC.
+const preludeLen = 12
+
+// Header value types.
+const (
+	typeTrue byte = iota
+	typeFalse
+	typeByte
+	typeShort
+	typeInt
+	typeLong
+	typeBytes
+	typeString
+	typeTimestamp
+	typeUUID
+)
+
+// ErrChecksum is returned for a message whose CRC does not match.
+var ErrChecksum = errors.New("eventstream: checksum mismatch")
+
+// Message is one decoded message. Only string headers are kept, which
+// covers the :message-type, :event-type, :exception-type and
+// :content-type headers services send.
+type Message struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// Decoder reads messages from a stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next message. It returns io.EOF at a clean end of
+// stream and io.ErrUnexpectedEOF if the stream ends inside a message.
+func (d *Decoder) Decode() (*Message, error) {
+	var prelude [preludeLen]byte
+	if _, err := io.ReadFull(d.r, prelude[:]); err != nil {
+		return nil, err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, ErrChecksum
+	}
+	if total > MaxMessageSize || uint64(total) < uint64(preludeLen)+uint64(headersLen)+4 {
+		return nil, fmt.Errorf("eventstream: invalid message length %d", total)
+	}
+
+	msg := make([]byte, total)
+	copy(msg, prelude[:])
+	if _, err := io.ReadFull(d.r, msg[preludeLen:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(msg[:total-4]) != binary.BigEndian.Uint32(msg[total-4:]) {
+		return nil, ErrChecksum
+	}
+
+	headers, err := decodeHeaders(msg[preludeLen : preludeLen+headersLen])
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Headers: headers, Payload: msg[preludeLen+headersLen : total-4]}, nil
+}
+
+func decodeHeaders(b []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if len(b) < 1+nameLen+1 {
+			return nil, errors.New("eventstream: truncated header")
+		}
+		name := string(b[1 : 1+nameLen])
+		typ := b[1+nameLen]
+		b = b[2+nameLen:]
+
+		var size int
+		switch typ {
+		case typeTrue, typeFalse:
+		case typeByte:
+			size = 1
+		case typeShort:
+			size = 2
+		case typeInt:
+			size = 4
+		case typeLong, typeTimestamp:
+			size = 8
+		case typeUUID:
+			size = 16
+		case typeBytes, typeString:
+			if len(b) < 2 {
+				return nil, errors.New("eventstream: truncated header")
+			}
+			size = int(binary.BigEndian.Uint16(b))
+			b = b[2:]
+		default:
+			return nil, fmt.Errorf("eventstream: unknown header type %d", typ)
+		}
+		if len(b) < size {
+			return nil, errors.New("eventstream: truncated header")
+		}
+		if typ == typeString {
+			headers[name] = string(b[:size])
+		}
+		b = b[size:]
+	}
+	return headers, nil
+}
+
+// Encode frames a message with string headers. The proxy only decodes;
+// Encode exists for tests and fake upstreams.
+func Encode(m *Message) []byte {
+	var headers bytes.Buffer
+	for name, value := range m.Headers {
+		headers.WriteByte(byte(len(name)))
+		headers.WriteString(name)
+		headers.WriteByte(typeString)
+		binary.Write(&headers, binary.BigEndian, uint16(len(value)))
+		headers.WriteString(value)
+	}
+
+	total := preludeLen + headers.Len() + len(m.Payload) + 4
+	msg := make([]byte, 0, total)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(total))
+	msg = binary.BigEndian.AppendUint32(msg, uint32(headers.Len()))
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+	msg = append(msg, headers.Bytes()...)
+	msg = append(msg, m.Payload...)
+	return binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+}
@@ -0,0 +1,95 @@
+package eventstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func TestDecode_RoundTrip(t *testing.T) {
+	msgs := []*Message{
+		{Headers: map[string]string{":message-type": "event", ":event-type": "chunk"}, Payload: []byte(`{"bytes":"e30="}`)},
+		{Headers: map[string]string{":message-type": "exception", ":exception-type": "throttlingException"}, Payload: []byte(`{"message":"slow down"}`)},
+		{Headers: map[string]string{}, Payload: nil},
+	}
+	var stream bytes.Buffer
+	for _, m := range msgs {
+		stream.Write(Encode(m))
+	}
+
+	d := NewDecoder(&stream)
+	for i, want := range msgs {
+		got, err := d.Decode()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if len(got.Headers) != len(want.Headers) || !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("message %d = %+v, want %+v", i, got, want)
+		}
+		for k, v := range want.Headers {
+			if got.Headers[k] != v {
+				t.Fatalf("message %d header %s = %q, want %q", i, k, got.Headers[k], v)
+			}
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Fatalf("after last message: %v, want io.EOF", err)
+	}
+}
+
+func TestDecode_SkipsNonStringHeaders(t *testing.T) {
+	// An int32 header, a bool header and a string header, built by hand.
+	var headers bytes.Buffer
+	headers.Write([]byte{3, 'n', 'u', 'm', typeInt, 0, 0, 0, 42})
+	headers.Write([]byte{2, 'o', 'k', typeTrue})
+	headers.Write([]byte{4, 't', 'y', 'p', 'e', typeString, 0, 5})
+	headers.WriteString("chunk")
+	payload := []byte("hi")
+
+	total := preludeLen + headers.Len() + len(payload) + 4
+	msg := binary.BigEndian.AppendUint32(nil, uint32(total))
+	msg = binary.BigEndian.AppendUint32(msg, uint32(headers.Len()))
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+	msg = append(msg, headers.Bytes()...)
+	msg = append(msg, payload...)
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+
+	got, err := NewDecoder(bytes.NewReader(msg)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Headers) != 1 || got.Headers["type"] != "chunk" || string(got.Payload) != "hi" {
+		t.Fatalf("Decode = %+v", got)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	good := Encode(&Message{Headers: map[string]string{":event-type": "chunk"}, Payload: []byte(`{}`)})
+
+	corrupt := bytes.Clone(good)
+	corrupt[len(corrupt)-6] ^= 0xff
+
+	badPrelude := bytes.Clone(good)
+	badPrelude[9] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"payload corrupted", corrupt, ErrChecksum},
+		{"prelude corrupted", badPrelude, ErrChecksum},
+		{"truncated", good[:len(good)-3], io.ErrUnexpectedEOF},
+		{"truncated prelude", good[:5], io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDecoder(bytes.NewReader(tt.data)).Decode(); !errors.Is(err, tt.want) {
+				t.Fatalf("Decode error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// Package keyring implements envelope encryption for secrets held by the
+// proxy. Each secret is sealed with its own random data key (AES-256-GCM),
+// and the data key is wrapped by a master key. Rotating the master key only
+// re-wraps data keys; sealed secrets themselves are never re-encrypted.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeySize is the required master key length in bytes (AES-256).
+const KeySize = 32
+
+// ErrUnchanged is returned by Rotate when the new key is the active one
+// and no older key is left to retire, so rotating would change nothing.
+// A key read by EnvSource always fails this way: a running process's
+// environment never changes.
+var ErrUnchanged = errors.New("master key unchanged")
+
+// Sealed is an envelope-encrypted secret.
+type Sealed struct {
+	// KeyID identifies the master key that wrapped the data key.
+	KeyID string `json:"key_id"`
+
+	// WrappedKey is the nonce-prefixed data key sealed by the master key.
+	WrappedKey []byte `json:"wrapped_key"`
+
+	// Ciphertext is the nonce-prefixed secret sealed by the data key.
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Source loads raw master key material.
+type Source func() ([]byte, error)
+
+// FileSource reads a base64-encoded master key from a file.
+func FileSource(path string) Source {
+	return func() ([]byte, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file: %w", err)
+		}
+		return decodeKey(string(data))
+	}
+}
+
+// EnvSource reads a base64-encoded master key from an environment variable.
+func EnvSource(name string) Source {
+	return func() ([]byte, error) {
+		value := os.Getenv(name)
+		if value == "" {
+			return nil, fmt.Errorf("master key env var %s is not set", name)
+		}
+		return decodeKey(value)
+	}
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode master key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Keyring holds the active master key plus any older keys still needed to
+// open data keys that have not yet been re-wrapped.
+type Keyring struct {
+	source Source
+
+	mu     sync.RWMutex
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// New creates a keyring whose initial master key is loaded from source.
+// The source is consulted again by Rotate when no explicit key is given.
+func New(source Source) (*Keyring, error) {
+	key, err := source()
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{
+		source: source,
+		keys:   make(map[string]cipher.AEAD),
+	}
+	if _, err := k.add(key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the master key used for new seals.
+func (k *Keyring) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Seal encrypts plaintext under a fresh data key wrapped by the active
+// master key. aad is bound to the ciphertext and must be supplied again to
+// Open.
+func (k *Keyring) Seal(plaintext, aad []byte) (*Sealed, error) {
+	dek := make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	data, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(data, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	wrapped, err := seal(k.keys[k.active], dek, []byte(k.active))
+	if err != nil {
+		return nil, err
+	}
+	return &Sealed{KeyID: k.active, WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts a sealed secret.
+func (k *Keyring) Open(s *Sealed, aad []byte) ([]byte, error) {
+	dek, err := k.unwrap(s)
+	if err != nil {
+		return nil, err
+	}
+	data, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(data, s.Ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("open sealed secret: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Rewrap returns a copy of s whose data key is wrapped by the active master
+// key. The ciphertext is unchanged.
+func (k *Keyring) Rewrap(s *Sealed) (*Sealed, error) {
+	dek, err := k.unwrap(s)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	wrapped, err := seal(k.keys[k.active], dek, []byte(k.active))
+	if err != nil {
+		return nil, err
+	}
+	return &Sealed{KeyID: k.active, WrappedKey: wrapped, Ciphertext: s.Ciphertext}, nil
+}
+
+// Rotate makes key the active master key, or reloads the key from the
+// keyring's source if key is nil. Previous keys stay available to Open and
+// Rewrap until Retire is called. Rotating to the active key is allowed
+// only while older keys remain, so an interrupted rotation can be resumed.
+func (k *Keyring) Rotate(key []byte) (string, error) {
+	if key == nil {
+		var err error
+		if key, err = k.source(); err != nil {
+			return "", err
+		}
+	}
+	k.mu.RLock()
+	unchanged := keyID(key) == k.active && len(k.keys) == 1
+	k.mu.RUnlock()
+	if unchanged {
+		return "", ErrUnchanged
+	}
+	return k.add(key)
+}
+
+// Retire drops every master key except the active one.
+func (k *Keyring) Retire() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id := range k.keys {
+		if id != k.active {
+			delete(k.keys, id)
+		}
+	}
+}
+
+// add installs key as the active master key and returns its ID.
+func (k *Keyring) add(key []byte) (string, error) {
+	if len(key) != KeySize {
+		return "", fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	id := keyID(key)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	k.active = id
+	return id, nil
+}
+
+// keyID derives a master key's ID from its hash.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// unwrap recovers the data key of a sealed secret.
+func (k *Keyring) unwrap(s *Sealed) ([]byte, error) {
+	k.mu.RLock()
+	master, ok := k.keys[s.KeyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", s.KeyID)
+	}
+
+	dek, err := open(master, s.WrappedKey, []byte(s.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return dek, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+	return aead, nil
+}
+
+// seal encrypts plaintext with a random nonce prefixed to the output.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
@@ -0,0 +1,141 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func staticSource(key []byte) Source {
+	return func() ([]byte, error) { return key, nil }
+}
+
+func testKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, KeySize)
+}
+
+func TestSealOpen(t *testing.T) {
+	k, err := New(staticSource(testKey(1)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	sealed, err := k.Seal([]byte("sk-ant-real"), []byte("token-a"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if bytes.Contains(sealed.Ciphertext, []byte("sk-ant-real")) {
+		t.Fatal("ciphertext contains plaintext")
+	}
+
+	got, err := k.Open(sealed, []byte("token-a"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if string(got) != "sk-ant-real" {
+		t.Fatalf("Open() = %q, want %q", got, "sk-ant-real")
+	}
+
+	if _, err := k.Open(sealed, []byte("token-b")); err == nil {
+		t.Fatal("Open() with different aad should fail")
+	}
+}
+
+func TestRotateRewrapRetire(t *testing.T) {
+	k, _ := New(staticSource(testKey(1)))
+	oldID := k.ActiveKeyID()
+
+	sealed, _ := k.Seal([]byte("secret"), nil)
+
+	newID, err := k.Rotate(testKey(2))
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if newID == oldID {
+		t.Fatal("Rotate() kept the same key ID")
+	}
+
+	// Old seals stay readable until the old key is retired.
+	if _, err := k.Open(sealed, nil); err != nil {
+		t.Fatalf("Open() old seal before retire error = %v", err)
+	}
+
+	rewrapped, err := k.Rewrap(sealed)
+	if err != nil {
+		t.Fatalf("Rewrap() error = %v", err)
+	}
+	if rewrapped.KeyID != newID {
+		t.Fatalf("Rewrap() KeyID = %q, want %q", rewrapped.KeyID, newID)
+	}
+
+	k.Retire()
+	if _, err := k.Open(sealed, nil); err == nil {
+		t.Fatal("Open() with retired key should fail")
+	}
+	got, err := k.Open(rewrapped, nil)
+	if err != nil {
+		t.Fatalf("Open() rewrapped error = %v", err)
+	}
+	if string(got) != "secret" {
+		t.Fatalf("Open() = %q, want %q", got, "secret")
+	}
+}
+
+func TestRotateReloadsSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.key")
+	write := func(key []byte) {
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(testKey(1))
+	k, err := New(FileSource(path))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	first := k.ActiveKeyID()
+
+	write(testKey(2))
+	second, err := k.Rotate(nil)
+	if err != nil {
+		t.Fatalf("Rotate(nil) error = %v", err)
+	}
+	if second == first {
+		t.Fatal("Rotate(nil) did not pick up the new key file")
+	}
+
+	// Until the first key is retired, rotating again resumes re-wrapping.
+	if _, err := k.Rotate(nil); err != nil {
+		t.Fatalf("Rotate(nil) before Retire error = %v", err)
+	}
+	k.Retire()
+	if _, err := k.Rotate(nil); !errors.Is(err, ErrUnchanged) {
+		t.Fatalf("Rotate(nil) with an unchanged key file error = %v, want ErrUnchanged", err)
+	}
+}
+
+func TestRotateEnvSourceUnchanged(t *testing.T) {
+	t.Setenv("TEST_MASTER_KEY", base64.StdEncoding.EncodeToString(testKey(1)))
+	k, err := New(EnvSource("TEST_MASTER_KEY"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := k.Rotate(nil); !errors.Is(err, ErrUnchanged) {
+		t.Fatalf("Rotate(nil) error = %v, want ErrUnchanged", err)
+	}
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	if _, err := New(staticSource([]byte("short"))); err == nil {
+		t.Fatal("New() expected error for short key")
+	}
+
+	t.Setenv("TEST_MASTER_KEY", "not base64!")
+	if _, err := New(EnvSource("TEST_MASTER_KEY")); err == nil {
+		t.Fatal("New() expected error for invalid base64")
+	}
+}
@@ -0,0 +1,161 @@
+// Package logging builds the proxy's slog loggers and scrubs credentials
+// from everything they write.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Attribute keys shared across packages, so the same value is always
+// logged under the same name.
+const (
+	KeyRequestID         = "request_id"
+	KeyUpstreamRequestID = "upstream_request_id"
+	KeySandboxID         = "sandbox_id"
+	KeyProvider          = "provider"
+	KeyError             = "error"
+)
+
+// Redacted replaces scrubbed values.
+const Redacted = "[REDACTED]"
+
+// New returns a logger writing to w in the given format ("text" or "json")
+// at or above level, with credentials redacted.
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+	return slog.New(NewRedactingHandler(h)), nil
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// Err returns an attribute for err under the shared error key.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// secretPatterns match credentials embedded in free text. The first
+// submatch, if any, is kept so the context stays readable.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`),
+	regexp.MustCompile(`(?i)([?&](?:key|api[_-]?key|token|access_token|sig)=)[^&\s"']+`),
+	regexp.MustCompile(`()\bsk-[A-Za-z0-9_\-]{8,}`),
+	regexp.MustCompile(`()\bsession-[A-Za-z0-9_\-]{8,}`),
+	regexp.MustCompile(`()\bAIza[0-9A-Za-z_\-]{30,}`),
+	regexp.MustCompile(`()\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`()\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`),
+}
+
+// sensitiveKeys are attribute names whose values are always redacted. A
+// key also matches if it ends in "_" followed by one of these, so
+// session_token is redacted but input_tokens is not.
+var sensitiveKeys = []string{"api_key", "apikey", "authorization", "token", "secret", "password"}
+
+// RedactString scrubs anything in s that looks like a bearer token, API
+// key, session token or signed token.
+func RedactString(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	return s
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	for _, k := range sensitiveKeys {
+		if key == k || strings.HasSuffix(key, "_"+k) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactingHandler wraps a handler and scrubs credentials from messages
+// and attribute values, including errors and nested groups.
+type RedactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler wraps next.
+func NewRedactingHandler(next slog.Handler) *RedactingHandler {
+	return &RedactingHandler{next: next}
+}
+
+// Enabled reports whether next handles level.
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle scrubs r and passes it on.
+func (h *RedactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, RedactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, clean)
+}
+
+// WithAttrs scrubs attrs once, up front.
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = redactAttr(a)
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(clean)}
+}
+
+// WithGroup opens a group on next.
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		group := v.Group()
+		clean := make([]slog.Attr, len(group))
+		for i, g := range group {
+			clean[i] = redactAttr(g)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(clean...)}
+	}
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, RedactString(v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, RedactString(x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, RedactString(x.String()))
+		case []byte:
+			return slog.String(a.Key, RedactString(string(x)))
+		}
+		return slog.String(a.Key, RedactString(fmt.Sprint(v.Any())))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,383 @@
+// Package metrics is a small self-contained implementation of counters,
+// gauges and histograms exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds suited to LLM calls,
+// which range from sub-second to several minutes.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// collector is one metric family.
+type collector interface {
+	write(w *bufio.Writer)
+
+	// forget deletes every series whose label has the given value.
+	forget(label, value string)
+}
+
+// Registry holds metric families and renders them for scraping.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Forget deletes every series, across all families, whose label has the
+// given value. Families labelled by something short-lived, such as a
+// sandbox, would otherwise grow for the life of the process.
+func (r *Registry) Forget(label, value string) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.forget(label, value)
+	}
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// family holds what every metric type shares: name, help and the series
+// keyed by label values.
+type family[T any] struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	newT   func() *T
+}
+
+func newFamily[T any](name, help, typ string, labels []string, newT func() *T) *family[T] {
+	return &family[T]{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*T), newT: newT}
+}
+
+// with returns the series for values, creating it on first use.
+func (f *family[T]) with(values []string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = f.newT()
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family[T]) forget(label, value string) {
+	i := slices.Index(f.labels, label)
+	if i < 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k := range f.series {
+		if strings.Split(k, "\xff")[i] == value {
+			delete(f.series, k)
+		}
+	}
+}
+
+// each calls fn for every series in label order.
+func (f *family[T]) each(fn func(values []string, s *T)) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]*T, len(keys))
+	for i, k := range keys {
+		series[i] = f.series[k]
+	}
+	f.mu.Unlock()
+
+	for i, k := range keys {
+		var values []string
+		if len(f.labels) > 0 {
+			values = strings.Split(k, "\xff")
+		}
+		fn(values, series[i])
+	}
+}
+
+func (f *family[T]) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+}
+
+// value is a float64 guarded by a mutex. Counters and gauges
+// are both values; only their API differs.
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(d float64) {
+	v.mu.Lock()
+	v.v += d
+	v.mu.Unlock()
+}
+
+func (v *value) set(x float64) {
+	v.mu.Lock()
+	v.v = x
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct{ value }
+
+// Inc adds one.
+func (c *Counter) Inc() { c.add(1) }
+
+// Add adds d, which must not be negative.
+func (c *Counter) Add(d float64) {
+	if d < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.add(d)
+}
+
+// CounterVec is a counter family partitioned by labels.
+type CounterVec struct{ f *family[Counter] }
+
+// NewCounter registers a counter family.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{f: newFamily(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(c)
+	return c
+}
+
+// With returns the counter for the given label values.
+func (c *CounterVec) With(values ...string) *Counter { return c.f.with(values) }
+
+func (c *CounterVec) forget(label, value string) { c.f.forget(label, value) }
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.f.header(w)
+	c.f.each(func(values []string, s *Counter) {
+		writeSample(w, c.f.name, c.f.labels, values, "", "", s.get())
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ value }
+
+// Set sets the gauge to x.
+func (g *Gauge) Set(x float64) { g.set(x) }
+
+// Add adds d, which may be negative.
+func (g *Gauge) Add(d float64) { g.add(d) }
+
+// Inc adds one.
+func (g *Gauge) Inc() { g.add(1) }
+
+// Dec subtracts one.
+func (g *Gauge) Dec() { g.add(-1) }
+
+// GaugeVec is a gauge family partitioned by labels.
+type GaugeVec struct{ f *family[Gauge] }
+
+// NewGauge registers a gauge family.
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{f: newFamily(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.register(g)
+	return g
+}
+
+// With returns the gauge for the given label values.
+func (g *GaugeVec) With(values ...string) *Gauge { return g.f.with(values) }
+
+func (g *GaugeVec) forget(label, value string) { g.f.forget(label, value) }
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.f.header(w)
+	g.f.each(func(values []string, s *Gauge) {
+		writeSample(w, g.f.name, g.f.labels, values, "", "", s.get())
+	})
+}
+
+// gaugeFunc is an unlabelled gauge read at scrape time.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn on every
+// scrape.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+// forget is a no-op: a gaugeFunc has no labels.
+func (g *gaugeFunc) forget(string, string) {}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	writeSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	upper []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// Observe records one observation.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// HistogramVec is a histogram family partitioned by labels.
+type HistogramVec struct {
+	f     *family[Histogram]
+	upper []float64
+}
+
+// NewHistogram registers a histogram family with the given bucket upper
+// bounds, which must be sorted ascending. A +Inf bucket is implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	upper := append([]float64(nil), buckets...)
+	h := &HistogramVec{upper: upper}
+	h.f = newFamily(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{upper: upper, counts: make([]uint64, len(upper)+1)}
+	})
+	r.register(h)
+	return h
+}
+
+// With returns the histogram for the given label values.
+func (h *HistogramVec) With(values ...string) *Histogram { return h.f.with(values) }
+
+func (h *HistogramVec) forget(label, value string) { h.f.forget(label, value) }
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.f.header(w)
+	h.f.each(func(values []string, s *Histogram) {
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, count := s.sum, s.count
+		s.mu.Unlock()
+
+		var cumulative uint64
+		for i, upper := range h.upper {
+			cumulative += counts[i]
+			writeSample(w, h.f.name+"_bucket", h.f.labels, values, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.f.name+"_bucket", h.f.labels, values, "le", "+Inf", float64(count))
+		writeSample(w, h.f.name+"_sum", h.f.labels, values, "", "", sum)
+		writeSample(w, h.f.name+"_count", h.f.labels, values, "", "", float64(count))
+	})
+}
+
+// writeSample writes one sample line, with an optional extra label such as
+// a histogram's le.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("requests_total", "Requests handled.", "provider", "code")
+	requests.With("openai", "200").Inc()
+	requests.With("anthropic", "429").Add(2)
+	requests.With("anthropic", "200").Inc()
+
+	inFlight := r.NewGauge("in_flight", "Requests in flight.\nMultiline help.")
+	inFlight.With().Inc()
+	inFlight.With().Inc()
+	inFlight.With().Dec()
+
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "provider")
+	latency.With("openai").Observe(0.05)
+	latency.With("openai").Observe(0.1)
+	latency.With("openai").Observe(3)
+
+	r.NewGaugeFunc("sessions", "Active sessions.", func() float64 { return 7 })
+
+	escaped := r.NewCounter("escaped_total", "Escaping.", "sandbox")
+	escaped.With("a\"b\\c\nd").Inc()
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{provider="anthropic",code="200"} 1
+requests_total{provider="anthropic",code="429"} 2
+requests_total{provider="openai",code="200"} 1
+# HELP in_flight Requests in flight.\nMultiline help.
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{provider="openai",le="0.1"} 2
+latency_seconds_bucket{provider="openai",le="1"} 2
+latency_seconds_bucket{provider="openai",le="+Inf"} 3
+latency_seconds_sum{provider="openai"} 3.15
+latency_seconds_count{provider="openai"} 3
+# HELP sessions Active sessions.
+# TYPE sessions gauge
+sessions 7
+# HELP escaped_total Escaping.
+# TYPE escaped_total counter
+escaped_total{sandbox="a\"b\\c\nd"} 1
+`
+	if b.String() != want {
+		t.Fatalf("WriteText() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("hits_total", "Hits.").With().Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "hits_total 1\n") {
+		t.Fatalf("body = %s", rec.Body.String())
+	}
+}
+
+func TestFamily_WrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("With() with the wrong number of labels did not panic")
+		}
+	}()
+	NewRegistry().NewCounter("x_total", "X.", "a", "b").With("only-one")
+}
+
+func TestRegistry_Forget(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests.", "sandbox", "code")
+	requests.With("sb-1", "200").Inc()
+	requests.With("sb-1", "429").Inc()
+	requests.With("sb-2", "200").Inc()
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{1}, "sandbox")
+	latency.With("sb-1").Observe(0.5)
+	r.NewGauge("in_flight", "In flight.", "provider").With("sb-1").Inc()
+	r.NewGaugeFunc("sessions", "Sessions.", func() float64 { return 1 })
+
+	r.Forget("sandbox", "sb-1")
+
+	var b strings.Builder
+	r.WriteText(&b)
+	got := b.String()
+	if strings.Contains(got, `sandbox="sb-1"`) {
+		t.Fatalf("forgotten sandbox still exported:\n%s", got)
+	}
+	for _, want := range []string{
+		`requests_total{sandbox="sb-2",code="200"} 1`,
+		`in_flight{provider="sb-1"} 1`,
+		"sessions 1",
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing %q after Forget:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"llm-proxy/pkg/session"
+)
+
+const (
+	// ProviderAzureOpenAI is OpenAI served through Azure.
+	ProviderAzureOpenAI = "azure-openai"
+
+	// DefaultAzureAPIVersion is the api-version sent for sessions that
+	// name none.
+	DefaultAzureAPIVersion = "2024-10-21"
+)
+
+// azureDeploymentPaths are the OpenAI endpoints Azure serves per
+// deployment, under /openai/deployments/{deployment}. The deployment is
+// chosen by the model named in the JSON request body.
+var azureDeploymentPaths = []string{
+	"/chat/completions",
+	"/completions",
+	"/embeddings",
+	"/images/generations",
+	"/audio/speech",
+}
+
+// azureProvider speaks the OpenAI API to Azure OpenAI. Sandboxes may call
+// the standard OpenAI paths, which are rewritten into Azure form, so
+// unmodified OpenAI SDKs work; Azure's own /openai/ paths pass through.
+type azureProvider struct {
+	*headerProvider
+}
+
+func newAzureProvider() *azureProvider {
+	return &azureProvider{&headerProvider{
+		name:   ProviderAzureOpenAI,
+		header: "api-key",
+		stream: StreamSSE,
+		paths:  []string{"/v1/", "/openai/"},
+		errors: openAIErrors,
+		shape:  openAIError,
+	}}
+}
+
+func (p *azureProvider) ValidateSession(sess *session.Session) error {
+	az := sess.Azure
+	if az == nil {
+		return fmt.Errorf("azure settings are required for provider %s", ProviderAzureOpenAI)
+	}
+	if az.Resource == "" {
+		if len(sess.Upstreams()) == 0 {
+			return fmt.Errorf("azure.resource or an upstream URL is required")
+		}
+	} else if !validAzureResource(az.Resource) {
+		return fmt.Errorf("azure.resource %s is not a valid resource name", az.Resource)
+	}
+	for model, deployment := range az.Deployments {
+		if model == "" || deployment == "" || strings.Contains(deployment, "/") {
+			return fmt.Errorf("azure.deployments entry for model %s is not a valid deployment name", model)
+		}
+	}
+	return nil
+}
+
+// validAzureResource reports whether name can be used as a hostname
+// label, so a session cannot point the proxy at another host.
+func validAzureResource(name string) bool {
+	if len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *azureProvider) SessionUpstream(sess *session.Session) string {
+	if sess.Azure == nil || sess.Azure.Resource == "" {
+		return ""
+	}
+	return "https://" + sess.Azure.Resource + ".openai.azure.com"
+}
+
+func (p *azureProvider) PrepareRequest(req *http.Request, path string, sess *session.Session, _ string, body []byte) error {
+	var az session.AzureOpenAI
+	if sess.Azure != nil {
+		az = *sess.Azure
+	}
+
+	// The upstream base URL may carry a path of its own, which is kept as
+	// is; only the sandbox's part is rewritten.
+	prefix, ok := strings.CutSuffix(req.URL.Path, path)
+	if !ok {
+		return fmt.Errorf("upstream path %s does not end with the request path", req.URL.Path)
+	}
+	rewritten, err := azurePath(path, az.Deployments, body)
+	if err != nil {
+		return err
+	}
+	req.URL.Path = prefix + rewritten
+	req.URL.RawPath = ""
+
+	q := req.URL.Query()
+	if q.Get("api-version") == "" {
+		version := az.APIVersion
+		if version == "" {
+			version = DefaultAzureAPIVersion
+		}
+		q.Set("api-version", version)
+		req.URL.RawQuery = q.Encode()
+	}
+	return nil
+}
+
+// azurePath maps a sandbox request path to its Azure form.
+func azurePath(path string, deployments map[string]string, body []byte) (string, error) {
+	if strings.HasPrefix(path, "/openai/") {
+		return path, nil
+	}
+	rest := strings.TrimPrefix(path, "/v1")
+	if rest == "/models" || strings.HasPrefix(rest, "/models/") {
+		return "/openai" + rest, nil
+	}
+	for _, endpoint := range azureDeploymentPaths {
+		if rest != endpoint {
+			continue
+		}
+		var req struct {
+			Model string `json:"model"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Model == "" {
+			return "", &RequestError{Message: "request body must be JSON naming a model"}
+		}
+		deployment := req.Model
+		if d, ok := deployments[req.Model]; ok {
+			deployment = d
+		}
+		if strings.Contains(deployment, "/") {
+			return "", &RequestError{Message: fmt.Sprintf("model %q has no valid deployment name", req.Model)}
+		}
+		return "/openai/deployments/" + deployment + rest, nil
+	}
+	return "", &RequestError{Message: fmt.Sprintf("%s is not supported by %s", path, ProviderAzureOpenAI)}
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm-proxy/pkg/session"
+)
+
+func TestAzurePath(t *testing.T) {
+	deployments := map[string]string{"gpt-4o": "prod-4o"}
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"mapped deployment", "/v1/chat/completions", `{"model":"gpt-4o"}`, "/openai/deployments/prod-4o/chat/completions", false},
+		{"model as deployment", "/v1/embeddings", `{"model":"text-embedding-3-small"}`, "/openai/deployments/text-embedding-3-small/embeddings", false},
+		{"models listing", "/v1/models", "", "/openai/models", false},
+		{"azure path passes through", "/openai/deployments/x/chat/completions", "", "/openai/deployments/x/chat/completions", false},
+		{"no model", "/v1/chat/completions", `{}`, "", true},
+		{"model with slash", "/v1/chat/completions", `{"model":"../x"}`, "", true},
+		{"unsupported endpoint", "/v1/assistants", `{}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := azurePath(tt.path, deployments, []byte(tt.body))
+			var reqErr *RequestError
+			if tt.wantErr != errors.As(err, &reqErr) {
+				t.Fatalf("azurePath error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("azurePath = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAzure_ValidateSession(t *testing.T) {
+	prov := newAzureProvider()
+
+	tests := []struct {
+		name    string
+		sess    session.Session
+		wantErr bool
+	}{
+		{"resource", session.Session{Azure: &session.AzureOpenAI{Resource: "my-res"}}, false},
+		{"upstream instead of resource", session.Session{UpstreamURL: "https://gw.example", Azure: &session.AzureOpenAI{}}, false},
+		{"no settings", session.Session{}, true},
+		{"no resource or upstream", session.Session{Azure: &session.AzureOpenAI{}}, true},
+		{"resource with dot", session.Session{Azure: &session.AzureOpenAI{Resource: "evil.example"}}, true},
+		{"bad deployment", session.Session{Azure: &session.AzureOpenAI{Resource: "r", Deployments: map[string]string{"m": "a/b"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := prov.ValidateSession(&tt.sess); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateSession error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	sess := &session.Session{Azure: &session.AzureOpenAI{Resource: "my-res"}}
+	if got := prov.SessionUpstream(sess); got != "https://my-res.openai.azure.com" {
+		t.Fatalf("SessionUpstream = %q", got)
+	}
+}
+
+func TestServeHTTP_AzureOpenAI(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer upstream.Close()
+
+	// A base path that itself contains /openai/ and /v1/ is kept as is.
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{
+		Token:       "session-az",
+		Provider:    ProviderAzureOpenAI,
+		APIKey:      "azure-real",
+		UpstreamURL: upstream.URL + "/openai/v1/gw",
+		Azure: &session.AzureOpenAI{
+			Deployments: map[string]string{"gpt-4o": "prod-4o"},
+			APIVersion:  "2025-01-01-preview",
+		},
+	})
+	p := New(store, slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		want        int
+		wantPath    string
+		wantVersion string
+	}{
+		{"openai path rewritten", "/v1/chat/completions", `{"model":"gpt-4o"}`, http.StatusOK, "/openai/v1/gw/openai/deployments/prod-4o/chat/completions", "2025-01-01-preview"},
+		{"sandbox api-version kept", "/openai/models?api-version=2024-06-01", "", http.StatusOK, "/openai/v1/gw/openai/models", "2024-06-01"},
+		{"unsupported path", "/v1/assistants", `{}`, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer session-az")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.wantPath == "" {
+				if got != nil {
+					t.Fatal("rejected request reached the upstream")
+				}
+				return
+			}
+			if got.URL.Path != tt.wantPath {
+				t.Errorf("upstream path = %q, want %q", got.URL.Path, tt.wantPath)
+			}
+			if v := got.URL.Query().Get("api-version"); v != tt.wantVersion {
+				t.Errorf("api-version = %q, want %q", v, tt.wantVersion)
+			}
+			if k := got.Header.Get("api-key"); k != "azure-real" {
+				t.Errorf("api-key = %q", k)
+			}
+			if a := got.Header.Get("Authorization"); a != "" {
+				t.Errorf("Authorization = %q, want it stripped", a)
+			}
+			if gotBody != tt.body {
+				t.Errorf("upstream body = %q, want %q", gotBody, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"llm-proxy/pkg/eventstream"
+	"llm-proxy/pkg/session"
+	"llm-proxy/pkg/sigv4"
+)
+
+const (
+	// ProviderBedrock is Anthropic's models served through AWS Bedrock.
+	ProviderBedrock = "bedrock"
+
+	// bedrockService is the SigV4 service name of the Bedrock runtime.
+	bedrockService = "bedrock"
+
+	// bedrockAnthropicVersion is the anthropic_version Bedrock requires in
+	// the request body in place of the anthropic-version header.
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+)
+
+// bedrockProvider speaks the Anthropic Messages API to Bedrock. Requests
+// to /v1/messages are rewritten to /model/{id}/invoke, or
+// invoke-with-response-stream for streams, and signed with SigV4 using
+// the AWS credentials in the session's API key. Bedrock's binary event
+// stream is converted back into Anthropic's SSE events, so unmodified
+// Anthropic SDKs work.
+type bedrockProvider struct {
+	*headerProvider
+
+	// now is the signing clock.
+	now func() time.Time
+}
+
+func newBedrockProvider() *bedrockProvider {
+	return &bedrockProvider{
+		headerProvider: &headerProvider{
+			// No header: InjectAuth only strips the sandbox's token and
+			// PrepareRequest signs.
+			name:   ProviderBedrock,
+			stream: StreamSSE,
+			paths:  []string{"/v1/messages"},
+			errors: anthropicErrors,
+			shape:  anthropicError,
+		},
+		now: time.Now,
+	}
+}
+
+func (p *bedrockProvider) ValidateSession(sess *session.Session) error {
+	br := sess.Bedrock
+	if br == nil {
+		return fmt.Errorf("bedrock settings are required for provider %s", ProviderBedrock)
+	}
+	if !validAWSRegion(br.Region) {
+		return fmt.Errorf("bedrock.region %s is not a valid AWS region", br.Region)
+	}
+	for model, id := range br.Models {
+		if model == "" || id == "" || strings.Contains(id, "/") {
+			return fmt.Errorf("bedrock.models entry for model %s is not a valid model ID", model)
+		}
+	}
+	if sess.APIKey != "" {
+		// The error never includes the key.
+		if _, err := sigv4.ParseCredentials(sess.APIKey); err != nil {
+			return fmt.Errorf("api_key for bedrock: %w", err)
+		}
+	}
+	return nil
+}
+
+// validAWSRegion reports whether region looks like an AWS region name,
+// so a session cannot point the proxy at another host.
+func validAWSRegion(region string) bool {
+	if region == "" || len(region) > 32 {
+		return false
+	}
+	for _, c := range region {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *bedrockProvider) SessionUpstream(sess *session.Session) string {
+	if sess.Bedrock == nil || sess.Bedrock.Region == "" {
+		return ""
+	}
+	return "https://bedrock-runtime." + sess.Bedrock.Region + ".amazonaws.com"
+}
+
+func (p *bedrockProvider) PrepareRequest(req *http.Request, path string, sess *session.Session, apiKey string, body []byte) error {
+	if sess.Bedrock == nil {
+		return fmt.Errorf("session has no bedrock settings")
+	}
+	creds, err := sigv4.ParseCredentials(apiKey)
+	if err != nil {
+		return fmt.Errorf("bedrock credentials: %w", err)
+	}
+
+	if path != "/v1/messages" {
+		return &RequestError{Message: fmt.Sprintf("%s is not supported by %s", path, ProviderBedrock)}
+	}
+	// The upstream base URL may carry a path of its own, which is kept.
+	prefix, ok := strings.CutSuffix(req.URL.Path, path)
+	if !ok {
+		return fmt.Errorf("upstream path %s does not end with the request path", req.URL.Path)
+	}
+
+	model, stream, invokeBody, err := bedrockBody(body, req.Header.Values("anthropic-beta"))
+	if err != nil {
+		return err
+	}
+	modelID := model
+	if id, ok := sess.Bedrock.Models[model]; ok {
+		modelID = id
+	}
+	if strings.Contains(modelID, "/") {
+		return &RequestError{Message: fmt.Sprintf("model %q has no valid Bedrock model ID", model)}
+	}
+	action := "invoke"
+	if stream {
+		action = "invoke-with-response-stream"
+	}
+	// Model IDs contain colons, which AWS SDKs send escaped.
+	escapedID := strings.ReplaceAll(url.PathEscape(modelID), ":", "%3A")
+	req.URL.Path = prefix + "/model/" + modelID + "/" + action
+	req.URL.RawPath = (&url.URL{Path: prefix}).EscapedPath() + "/model/" + escapedID + "/" + action
+
+	req.Body = io.NopCloser(bytes.NewReader(invokeBody))
+	req.ContentLength = int64(len(invokeBody))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(invokeBody)), nil
+	}
+
+	for name := range req.Header {
+		// Nothing the sandbox sent may be mistaken for part of the
+		// signature.
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			req.Header.Del(name)
+		}
+	}
+	req.Header.Del("anthropic-version")
+	req.Header.Del("anthropic-beta")
+	// Let the transport handle compression so event streams arrive
+	// decoded.
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("Content-Type", "application/json")
+
+	sigv4.Sign(req, invokeBody, creds, sess.Bedrock.Region, bedrockService, p.now())
+	return nil
+}
+
+// bedrockBody converts an Anthropic Messages request body to a Bedrock
+// invoke body: model and stream move into the URL, anthropic_version
+// (and anthropic_beta, from the header) into the body.
+func bedrockBody(body []byte, betas []string) (model string, stream bool, invokeBody []byte, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", false, nil, &RequestError{Message: "request body must be a JSON object"}
+	}
+	if err := json.Unmarshal(fields["model"], &model); err != nil || model == "" {
+		return "", false, nil, &RequestError{Message: "request body must name a model"}
+	}
+	if raw, ok := fields["stream"]; ok {
+		if err := json.Unmarshal(raw, &stream); err != nil {
+			return "", false, nil, &RequestError{Message: "stream must be a boolean"}
+		}
+	}
+	delete(fields, "model")
+	delete(fields, "stream")
+	if _, ok := fields["anthropic_version"]; !ok {
+		fields["anthropic_version"] = json.RawMessage(`"` + bedrockAnthropicVersion + `"`)
+	}
+	if _, ok := fields["anthropic_beta"]; !ok && len(betas) > 0 {
+		var list []string
+		for _, v := range betas {
+			for b := range strings.SplitSeq(v, ",") {
+				if b = strings.TrimSpace(b); b != "" {
+					list = append(list, b)
+				}
+			}
+		}
+		raw, _ := json.Marshal(list)
+		fields["anthropic_beta"] = raw
+	}
+	invokeBody, err = json.Marshal(fields)
+	return model, stream, invokeBody, err
+}
+
+func (p *bedrockProvider) TransformResponse(resp *http.Response) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), eventstream.ContentType) {
+		return
+	}
+	resp.Header.Set("Content-Type", StreamSSE)
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Body = &bedrockStream{dec: eventstream.NewDecoder(resp.Body), body: resp.Body}
+}
+
+// bedrockStream reads a Bedrock event stream as Anthropic SSE. Each chunk
+// event carries one base64-encoded Anthropic stream event; exceptions
+// become an Anthropic error event, after which the stream ends.
+type bedrockStream struct {
+	dec  *eventstream.Decoder
+	body io.ReadCloser
+	buf  bytes.Buffer
+	done bool
+}
+
+func (s *bedrockStream) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		msg, err := s.dec.Decode()
+		if errors.Is(err, io.EOF) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			s.writeError("api_error", "error reading Bedrock stream: "+err.Error())
+			continue
+		}
+		s.convert(msg)
+	}
+	return s.buf.Read(p)
+}
+
+func (s *bedrockStream) Close() error {
+	return s.body.Close()
+}
+
+// bedrockExceptions maps Bedrock stream exceptions to Anthropic error
+// types, so SDKs treat them the way they would the Anthropic API's own.
+var bedrockExceptions = map[string]string{
+	"throttlingException":         "rate_limit_error",
+	"serviceUnavailableException": "overloaded_error",
+	"modelNotReadyException":      "overloaded_error",
+	"validationException":         "invalid_request_error",
+}
+
+func (s *bedrockStream) convert(msg *eventstream.Message) {
+	switch msg.Headers[":message-type"] {
+	case "event":
+		if msg.Headers[":event-type"] != "chunk" {
+			return
+		}
+		var chunk struct {
+			Bytes []byte `json:"bytes"`
+		}
+		var event struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(msg.Payload, &chunk) != nil || json.Unmarshal(chunk.Bytes, &event) != nil || event.Type == "" {
+			return
+		}
+		fmt.Fprintf(&s.buf, "event: %s\ndata: %s\n\n", event.Type, chunk.Bytes)
+	case "exception", "error":
+		name := msg.Headers[":exception-type"]
+		if name == "" {
+			name = msg.Headers[":error-code"]
+		}
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(msg.Payload, &body)
+		if body.Message == "" {
+			body.Message = name
+		}
+		typ, ok := bedrockExceptions[name]
+		if !ok {
+			typ = "api_error"
+		}
+		s.writeError(typ, body.Message)
+	}
+}
+
+// writeError appends an Anthropic error event and ends the stream.
+func (s *bedrockStream) writeError(typ, message string) {
+	data, _ := json.Marshal(map[string]any{
+		"type":  "error",
+		"error": map[string]string{"type": typ, "message": message},
+	})
+	fmt.Fprintf(&s.buf, "event: error\ndata: %s\n\n", data)
+	s.done = true
+}
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"llm-proxy/pkg/eventstream"
+	"llm-proxy/pkg/session"
+	"llm-proxy/pkg/sigv4"
+	"llm-proxy/pkg/usage"
+)
+
+func TestBedrockBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		betas      []string
+		wantModel  string
+		wantStream bool
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:      "model and stream move out",
+			body:      `{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[]}`,
+			wantModel: "claude-sonnet-4-5",
+			want:      `{"anthropic_version":"bedrock-2023-05-31","max_tokens":10,"messages":[]}`,
+		},
+		{
+			name:       "stream with betas",
+			body:       `{"model":"m","stream":true}`,
+			betas:      []string{"a-2025-01-01, b-2025-02-02"},
+			wantModel:  "m",
+			wantStream: true,
+			want:       `{"anthropic_beta":["a-2025-01-01","b-2025-02-02"],"anthropic_version":"bedrock-2023-05-31"}`,
+		},
+		{
+			name:      "explicit anthropic_version kept",
+			body:      `{"model":"m","anthropic_version":"custom"}`,
+			wantModel: "m",
+			want:      `{"anthropic_version":"custom"}`,
+		},
+		{name: "no model", body: `{"max_tokens":10}`, wantErr: true},
+		{name: "not an object", body: `[]`, wantErr: true},
+		{name: "bad stream", body: `{"model":"m","stream":"yes"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, stream, got, err := bedrockBody([]byte(tt.body), tt.betas)
+			var reqErr *RequestError
+			if tt.wantErr != errors.As(err, &reqErr) {
+				t.Fatalf("bedrockBody error = %v, want error %v", err, tt.wantErr)
+			}
+			if model != tt.wantModel || stream != tt.wantStream || string(got) != tt.want {
+				t.Fatalf("bedrockBody = %q, %v, %s; want %q, %v, %s", model, stream, got, tt.wantModel, tt.wantStream, tt.want)
+			}
+		})
+	}
+}
+
+func TestBedrock_ValidateSession(t *testing.T) {
+	prov := newBedrockProvider()
+
+	tests := []struct {
+		name    string
+		sess    session.Session
+		wantErr bool
+	}{
+		{"valid", session.Session{APIKey: "AKID:secret", Bedrock: &session.Bedrock{Region: "us-east-1"}}, false},
+		{"catalog credential", session.Session{CredentialID: "aws", Bedrock: &session.Bedrock{Region: "eu-west-3"}}, false},
+		{"no settings", session.Session{APIKey: "AKID:secret"}, true},
+		{"bad region", session.Session{APIKey: "AKID:secret", Bedrock: &session.Bedrock{Region: "evil.example/"}}, true},
+		{"key without secret", session.Session{APIKey: "AKIDONLY", Bedrock: &session.Bedrock{Region: "us-east-1"}}, true},
+		{"bad model ID", session.Session{APIKey: "AKID:secret", Bedrock: &session.Bedrock{Region: "us-east-1", Models: map[string]string{"m": "a/b"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := prov.ValidateSession(&tt.sess)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateSession error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && tt.sess.APIKey != "" && strings.Contains(err.Error(), tt.sess.APIKey) {
+				t.Fatalf("error leaks the key: %v", err)
+			}
+		})
+	}
+}
+
+// verifySigV4 re-signs the request the upstream received and reports
+// whether the signature matches, so the test covers the path, query and
+// body exactly as they went over the wire.
+func verifySigV4(r *http.Request, body []byte, creds sigv4.Credentials, region string) bool {
+	signedAt, err := time.Parse(sigv4.TimeFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.RequestURI, nil)
+	check.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	sigv4.Sign(check, body, creds, region, bedrockService, signedAt)
+	return check.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+// bedrockChunk frames one Anthropic stream event as Bedrock sends it.
+func bedrockChunk(event string) []byte {
+	payload, _ := json.Marshal(map[string][]byte{"bytes": []byte(event)})
+	return eventstream.Encode(&eventstream.Message{
+		Headers: map[string]string{":message-type": "event", ":event-type": "chunk", ":content-type": "application/json"},
+		Payload: payload,
+	})
+}
+
+func TestServeHTTP_Bedrock(t *testing.T) {
+	creds := sigv4.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret/key", SessionToken: "tok"}
+
+	var stream []byte
+	stream = append(stream, bedrockChunk(`{"type":"message_start","message":{"model":"claude-sonnet-4-5","usage":{"input_tokens":25,"output_tokens":1}}}`)...)
+	stream = append(stream, bedrockChunk(`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15}}`)...)
+	stream = append(stream, bedrockChunk(`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":25}}`)...)
+	const wantSSE = "event: message_start\n" +
+		`data: {"type":"message_start","message":{"model":"claude-sonnet-4-5","usage":{"input_tokens":25,"output_tokens":1}}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15}}` + "\n\n" +
+		"event: message_stop\n" +
+		`data: {"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":25}}` + "\n\n"
+
+	var gotPath string
+	var gotBody map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath = r.URL.EscapedPath()
+		gotBody = nil
+		json.Unmarshal(body, &gotBody)
+		if !verifySigV4(r, body, creds, "us-west-2") {
+			http.Error(w, `{"message":"The request signature we calculated does not match"}`, http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "tok" || r.Header.Get("x-api-key") != "" {
+			http.Error(w, `{"message":"bad headers"}`, http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/invoke-with-response-stream") {
+			w.Header().Set("Content-Type", eventstream.ContentType)
+			w.Write(stream)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"type":"message","model":"claude-sonnet-4-5","content":[],"usage":{"input_tokens":3,"output_tokens":4}}`)
+	}))
+	defer upstream.Close()
+
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{
+		Token:       "session-br",
+		Provider:    ProviderBedrock,
+		APIKey:      "AKIDEXAMPLE:secret/key:tok",
+		UpstreamURL: upstream.URL,
+		Bedrock: &session.Bedrock{
+			Region: "us-west-2",
+			Models: map[string]string{"claude-sonnet-4-5": "us.anthropic.claude-sonnet-4-5-20250929-v1:0"},
+		},
+	})
+	ledger := usage.NewLedger(0)
+	p := New(store, slog.New(slog.DiscardHandler), WithUsage(ledger))
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		want     int
+		wantPath string
+		wantBody string
+	}{
+		{
+			name:     "invoke",
+			path:     "/v1/messages",
+			body:     `{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[]}`,
+			want:     http.StatusOK,
+			wantPath: "/model/us.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke",
+		},
+		{
+			name:     "stream decoded to SSE",
+			path:     "/v1/messages",
+			body:     `{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[],"stream":true}`,
+			want:     http.StatusOK,
+			wantPath: "/model/us.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke-with-response-stream",
+			wantBody: wantSSE,
+		},
+		{
+			name: "unsupported path",
+			path: "/v1/messages/count_tokens",
+			body: `{"model":"claude-sonnet-4-5"}`,
+			want: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath = ""
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("x-api-key", "session-br")
+			req.Header.Set("anthropic-version", "2023-06-01")
+			req.Header.Set("X-Amz-Security-Token", "sandbox-forged")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if gotPath != tt.wantPath {
+				t.Fatalf("upstream path = %q, want %q", gotPath, tt.wantPath)
+			}
+			if tt.wantPath != "" && (gotBody["model"] != nil || gotBody["anthropic_version"] != bedrockAnthropicVersion) {
+				t.Fatalf("upstream body = %v", gotBody)
+			}
+			if tt.wantBody != "" {
+				if rec.Body.String() != tt.wantBody || rec.Header().Get("Content-Type") != StreamSSE || !rec.Flushed {
+					t.Fatalf("sandbox got %q (%s), want SSE flushed through", rec.Body.String(), rec.Header().Get("Content-Type"))
+				}
+			}
+		})
+	}
+
+	var total usage.Usage
+	for _, b := range ledger.Buckets() {
+		total.Add(b.Usage)
+	}
+	if total.InputTokens != 28 || total.OutputTokens != 19 {
+		t.Fatalf("metered usage = %+v, want both responses counted", total)
+	}
+}
+
+func TestBedrock_PrepareRequestKeepsBasePath(t *testing.T) {
+	sess := &session.Session{Bedrock: &session.Bedrock{Region: "us-east-1"}}
+	req := httptest.NewRequest(http.MethodPost, "https://gw.example.com/v1/bedrock/v1/messages", nil)
+	if err := newBedrockProvider().PrepareRequest(req, "/v1/messages", sess, "AKID:secret", []byte(`{"model":"m"}`)); err != nil {
+		t.Fatalf("PrepareRequest: %v", err)
+	}
+	if want := "/v1/bedrock/model/m/invoke"; req.URL.EscapedPath() != want {
+		t.Fatalf("path = %q, want %q", req.URL.EscapedPath(), want)
+	}
+
+	// A base path ending in /v1/messages does not make another sandbox
+	// path acceptable.
+	req = httptest.NewRequest(http.MethodPost, "https://gw.example.com/v1/messages/v1/models", nil)
+	var reqErr *RequestError
+	if err := newBedrockProvider().PrepareRequest(req, "/v1/models", sess, "AKID:secret", []byte(`{"model":"m"}`)); !errors.As(err, &reqErr) {
+		t.Fatalf("PrepareRequest error = %v, want a RequestError", err)
+	}
+}
+
+func TestBedrockStream_Exception(t *testing.T) {
+	frames := append(bedrockChunk(`{"type":"message_start","message":{}}`), eventstream.Encode(&eventstream.Message{
+		Headers: map[string]string{":message-type": "exception", ":exception-type": "throttlingException"},
+		Payload: []byte(`{"message":"Too many requests"}`),
+	})...)
+	frames = append(frames, bedrockChunk(`{"type":"message_stop"}`)...)
+
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": {eventstream.ContentType}},
+		Body:   io.NopCloser(strings.NewReader(string(frames))),
+	}
+	newBedrockProvider().TransformResponse(resp)
+	got, _ := io.ReadAll(resp.Body)
+
+	want := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{}}\n\n" +
+		"event: error\ndata: {\"error\":{\"message\":\"Too many requests\",\"type\":\"rate_limit_error\"},\"type\":\"error\"}\n\n"
+	if string(got) != want {
+		t.Fatalf("stream =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker defaults.
+const (
+	// DefaultCircuitFailures is how many consecutive failures open an
+	// upstream's circuit.
+	DefaultCircuitFailures = 3
+
+	// DefaultCircuitCoolDown is how long an open circuit skips its upstream.
+	DefaultCircuitCoolDown = 30 * time.Second
+)
+
+// circuitBreaker tracks consecutive failures (connection errors and 5xx)
+// per upstream base URL. Once an upstream reaches the threshold it is
+// skipped for the cool-down; after that it is tried again and a single
+// further failure reopens the circuit, while a success closes it.
+//
+// Only upstreams that have somewhere to fail over to are tracked.
+type circuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+
+	// now is the breaker clock. Overridden in tests.
+	now func() time.Time
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, coolDown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		coolDown:  coolDown,
+		circuits:  make(map[string]*circuit),
+		now:       time.Now,
+	}
+}
+
+// order returns the upstreams to try, in their configured order, without
+// those whose circuit is open. If every circuit is open, the upstream
+// whose cool-down ends soonest is tried rather than failing outright.
+func (b *circuitBreaker) order(upstreams []string) []string {
+	if len(upstreams) < 2 {
+		return upstreams
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	healthy := make([]string, 0, len(upstreams))
+	var soonest string
+	var soonestUntil time.Time
+	for _, u := range upstreams {
+		c := b.circuits[u]
+		if c == nil || !now.Before(c.openUntil) {
+			healthy = append(healthy, u)
+			continue
+		}
+		if soonest == "" || c.openUntil.Before(soonestUntil) {
+			soonest, soonestUntil = u, c.openUntil
+		}
+	}
+	if len(healthy) == 0 {
+		return []string{soonest}
+	}
+	return healthy
+}
+
+// record notes the outcome of a request to upstream, one of a failover
+// list. It reports whether this failure opened the circuit.
+func (b *circuitBreaker) record(upstream string, failed bool) (opened bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuits[upstream]
+	if !failed {
+		if c != nil {
+			delete(b.circuits, upstream)
+		}
+		return false
+	}
+	if c == nil {
+		c = &circuit{}
+		b.circuits[upstream] = c
+	}
+	c.failures++
+	if c.failures < b.threshold {
+		return false
+	}
+	c.openUntil = b.now().Add(b.coolDown)
+	return true
+}
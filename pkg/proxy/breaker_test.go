@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"llm-proxy/pkg/metrics"
+	"llm-proxy/pkg/session"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	upstreams := []string{"http://a", "http://b"}
+
+	if b.record("http://a", true) {
+		t.Fatal("circuit opened before threshold")
+	}
+	if !b.record("http://a", true) {
+		t.Fatal("circuit not opened at threshold")
+	}
+	if got := b.order(upstreams); !slices.Equal(got, []string{"http://b"}) {
+		t.Fatalf("order with a open = %v", got)
+	}
+
+	// Both open: the one cooling down soonest is still tried.
+	now = now.Add(time.Second)
+	b.record("http://b", true)
+	b.record("http://b", true)
+	if got := b.order(upstreams); !slices.Equal(got, []string{"http://a"}) {
+		t.Fatalf("order with both open = %v", got)
+	}
+
+	// After its cool-down a is tried again; one more failure reopens it,
+	// a success closes it.
+	now = now.Add(59 * time.Second)
+	if got := b.order(upstreams); !slices.Equal(got, []string{"http://a"}) {
+		t.Fatalf("order after a's cool-down = %v", got)
+	}
+	if !b.record("http://a", true) {
+		t.Fatal("half-open circuit not reopened by a failure")
+	}
+	now = now.Add(time.Minute)
+	b.record("http://a", false)
+	b.record("http://b", false)
+	if got := b.order(upstreams); !slices.Equal(got, upstreams) {
+		t.Fatalf("order after recovery = %v", got)
+	}
+}
+
+func TestServeHTTP_Failover(t *testing.T) {
+	var primaryCalls, secondaryCalls atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryCalls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer sk-real" || string(body) != `{"n":1}` || r.URL.RawQuery != "x=1" {
+			t.Errorf("secondary got auth %q body %q query %q", r.Header.Get("Authorization"), body, r.URL.RawQuery)
+		}
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer secondary.Close()
+
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{
+		Token:        "session-tok",
+		Provider:     ProviderOpenAI,
+		APIKey:       "sk-real",
+		UpstreamURLs: []string{primary.URL, secondary.URL},
+		SandboxID:    "sb",
+	})
+	reg := metrics.NewRegistry()
+	p := New(store, slog.New(slog.DiscardHandler), WithMetrics(reg), WithCircuitBreaker(2, time.Minute))
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions?x=1", strings.NewReader(`{"n":1}`))
+		req.Header.Set("Authorization", "Bearer session-tok")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 3 {
+		if rec := send(); rec.Code != http.StatusOK || rec.Body.String() != `{"ok":true}` {
+			t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	// The primary's circuit opens after two failures, so the third
+	// request goes straight to the secondary.
+	if primaryCalls.Load() != 2 || secondaryCalls.Load() != 3 {
+		t.Fatalf("calls = %d primary, %d secondary; want 2, 3", primaryCalls.Load(), secondaryCalls.Load())
+	}
+
+	var buf bytes.Buffer
+	reg.WriteText(&buf)
+	if want := `llmproxy_upstream_failovers_total{provider="openai",sandbox="sb"} 2`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("metrics missing %s:\n%s", want, buf.String())
+	}
+}
+
+func TestServeHTTP_FailoverLastUpstreamRelayed(t *testing.T) {
+	var calls atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{
+		Token:        "session-tok",
+		Provider:     ProviderAnthropic,
+		APIKey:       "sk-real",
+		UpstreamURLs: []string{down.URL, failing.URL},
+	})
+	p := New(store, slog.New(slog.DiscardHandler))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`))
+	req.Header.Set("x-api-key", "session-tok")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	// The connection error fails over; the last upstream's 503 goes back
+	// to the sandbox as is.
+	if rec.Code != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("status = %d after %d calls, want 503 after 1", rec.Code, calls.Load())
+	}
+}
+
+func TestServeHTTP_NoFailoverAfterRequestSent(t *testing.T) {
+	dropping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer dropping.Close()
+	var calls atomic.Int32
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer secondary.Close()
+
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{
+		Token:        "session-tok",
+		Provider:     ProviderAnthropic,
+		APIKey:       "sk-real",
+		UpstreamURLs: []string{dropping.URL, secondary.URL},
+	})
+	p := New(store, slog.New(slog.DiscardHandler))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`))
+	req.Header.Set("x-api-key", "session-tok")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	// The first upstream may have acted on the request before dropping
+	// the connection, so it is not sent to the second.
+	if rec.Code != http.StatusBadGateway || calls.Load() != 0 {
+		t.Fatalf("status = %d after %d failovers, want 502 after none", rec.Code, calls.Load())
+	}
+}
+
+func TestServeHTTP_ProviderUpstreams(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{Token: "session-tok", Provider: ProviderOpenAI, APIKey: "sk-real"})
+	p := New(store, slog.New(slog.DiscardHandler), WithUpstreams(ProviderOpenAI, down.URL, upstream.URL))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer session-tok")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+	}
+}
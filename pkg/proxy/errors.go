@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind is a class of error the proxy itself returns to sandboxes.
+type ErrorKind int
+
+const (
+	// ErrorRateLimited means a session or sandbox rate limit was hit.
+	ErrorRateLimited ErrorKind = iota
+
+	// ErrorBudgetExhausted means a session or sandbox budget is used up.
+	ErrorBudgetExhausted
+)
+
+// providerError describes how one provider reports an error kind. Rate
+// limits map to statuses SDKs retry after Retry-After, exhausted budgets
+// to ones they do not.
+type providerError struct {
+	status int
+	// typ and code fill the provider's error type fields.
+	typ  string
+	code string
+}
+
+// anthropicErrors mirror what Anthropic returns for each error kind.
+var anthropicErrors = map[ErrorKind]providerError{
+	ErrorRateLimited:     {status: http.StatusTooManyRequests, typ: "rate_limit_error"},
+	ErrorBudgetExhausted: {status: http.StatusBadRequest, typ: "invalid_request_error"},
+}
+
+// openAIErrors mirror what OpenAI returns for each error kind.
+var openAIErrors = map[ErrorKind]providerError{
+	ErrorRateLimited:     {status: http.StatusTooManyRequests, typ: "requests", code: "rate_limit_exceeded"},
+	ErrorBudgetExhausted: {status: http.StatusTooManyRequests, typ: "insufficient_quota", code: "insufficient_quota"},
+}
+
+// geminiErrors mirror what Gemini returns for each error kind, with typ
+// holding the Google RPC status.
+var geminiErrors = map[ErrorKind]providerError{
+	ErrorRateLimited:     {status: http.StatusTooManyRequests, typ: "RESOURCE_EXHAUSTED"},
+	ErrorBudgetExhausted: {status: http.StatusBadRequest, typ: "FAILED_PRECONDITION"},
+}
+
+// anthropicError is Anthropic's error body, with the request ID where
+// Anthropic puts its own.
+func anthropicError(pe providerError, message, requestID string) any {
+	return map[string]any{
+		"type": "error",
+		"error": map[string]string{
+			"type":    pe.typ,
+			"message": message,
+		},
+		"request_id": requestID,
+	}
+}
+
+// openAIError is OpenAI's error body, also used by OpenAI-compatible
+// APIs.
+func openAIError(pe providerError, message, requestID string) any {
+	return map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    pe.typ,
+			"param":   nil,
+			"code":    pe.code,
+		},
+		"request_id": requestID,
+	}
+}
+
+// geminiError is the Google API error body Gemini uses.
+func geminiError(pe providerError, message, requestID string) any {
+	return map[string]any{
+		"error": map[string]any{
+			"code":    pe.status,
+			"message": message,
+			"status":  pe.typ,
+		},
+		"request_id": requestID,
+	}
+}
+
+// plainError is the body of the proxy's own errors.
+func plainError(message, requestID string) any {
+	return map[string]string{"error": message, "request_id": requestID}
+}
+
+// writeError sends one of the proxy's own errors, tagged with the request
+// ID so the sandbox can quote it when reporting the failure.
+func writeError(w http.ResponseWriter, requestID string, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(plainError(message, requestID))
+}
+
+// writeProviderError rejects a request in the error format of the
+// session's provider, with the request ID alongside. A positive
+// retryAfter is sent as Retry-After.
+func writeProviderError(w http.ResponseWriter, prov Provider, requestID string, kind ErrorKind, message string, retryAfter time.Duration) {
+	status, body := prov.ErrorResponse(kind, message, requestID)
+
+	if retryAfter > 0 {
+		// Retry-After only carries whole seconds; round up so clients
+		// never retry early.
+		secs := int(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
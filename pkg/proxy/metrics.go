@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"llm-proxy/pkg/metrics"
+)
+
+// proxyMetrics are the proxy's Prometheus metrics. Every series is labelled
+// by provider and sandbox; requests rejected before a session is found
+// have both labels empty.
+type proxyMetrics struct {
+	requests        *metrics.CounterVec
+	upstreamStatus  *metrics.CounterVec
+	upstreamLatency *metrics.HistogramVec
+	streamFirstByte *metrics.HistogramVec
+	bytesStreamed   *metrics.CounterVec
+	inFlight        *metrics.GaugeVec
+	retries         *metrics.CounterVec
+	failovers       *metrics.CounterVec
+
+	reg *metrics.Registry
+
+	// sandboxes holds every sandbox that has series, with the expiry of
+	// the latest signed token seen for it. Signed tokens are not in the
+	// session store, so their sandbox's series are kept until then.
+	mu        sync.Mutex
+	sandboxes map[string]time.Time
+}
+
+func newProxyMetrics(reg *metrics.Registry) *proxyMetrics {
+	return &proxyMetrics{
+		reg:       reg,
+		sandboxes: make(map[string]time.Time),
+		requests: reg.NewCounter("llmproxy_requests_total",
+			"Requests handled by the proxy, by status code returned to the sandbox.",
+			"provider", "sandbox", "code"),
+		upstreamStatus: reg.NewCounter("llmproxy_upstream_responses_total",
+			"Responses received from upstream providers, by status code.",
+			"provider", "sandbox", "code"),
+		upstreamLatency: reg.NewHistogram("llmproxy_upstream_latency_seconds",
+			"Time from sending a request upstream to receiving response headers.",
+			metrics.DefaultBuckets, "provider", "sandbox"),
+		streamFirstByte: reg.NewHistogram("llmproxy_stream_first_byte_seconds",
+			"Time from sending a streaming request upstream to its first body byte.",
+			metrics.DefaultBuckets, "provider", "sandbox"),
+		bytesStreamed: reg.NewCounter("llmproxy_response_bytes_total",
+			"Response body bytes relayed to sandboxes.",
+			"provider", "sandbox"),
+		inFlight: reg.NewGauge("llmproxy_in_flight_requests",
+			"Requests currently being proxied, including streams still being relayed.",
+			"provider", "sandbox"),
+		retries: reg.NewCounter("llmproxy_upstream_retries_total",
+			"Upstream attempts retried, by upstream status or \"error\" for connection failures.",
+			"provider", "sandbox", "reason"),
+		failovers: reg.NewCounter("llmproxy_upstream_failovers_total",
+			"Requests moved to the next upstream after a connection error or 5xx.",
+			"provider", "sandbox"),
+	}
+}
+
+// observeRequest records a finished request.
+func (m *proxyMetrics) observeRequest(ex *exchange, w *responseWriter) {
+	status := w.status
+	if status == 0 {
+		// Nothing was written; net/http will send 200.
+		status = 200
+	}
+	m.requests.With(ex.provider, ex.sandboxID, strconv.Itoa(status)).Inc()
+	if w.bytes > 0 {
+		m.bytesStreamed.With(ex.provider, ex.sandboxID).Add(float64(w.bytes))
+	}
+	m.track(ex.sandboxID, time.Time{})
+}
+
+// track notes that sandboxID has series, and that a signed token lets it
+// make requests until signedExpiry.
+func (m *proxyMetrics) track(sandboxID string, signedExpiry time.Time) {
+	if sandboxID == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cur, ok := m.sandboxes[sandboxID]; !ok || signedExpiry.After(cur) {
+		m.sandboxes[sandboxID] = signedExpiry
+	}
+}
+
+// forget deletes a sandbox's series.
+func (m *proxyMetrics) forget(sandboxID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sandboxes, sandboxID)
+	m.reg.Forget("sandbox", sandboxID)
+}
+
+// forgetEnded deletes the series of every tracked sandbox that is not live
+// and holds no signed token valid at now, and returns how many it deleted.
+func (m *proxyMetrics) forgetEnded(live map[string]bool, now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	forgotten := 0
+	for id, signedExpiry := range m.sandboxes {
+		if live[id] || signedExpiry.After(now) {
+			continue
+		}
+		delete(m.sandboxes, id)
+		m.reg.Forget("sandbox", id)
+		forgotten++
+	}
+	return forgotten
+}
+
+// observeUpstream records upstream response headers arriving after sent.
+func (m *proxyMetrics) observeUpstream(ex *exchange, status int, sent time.Time) {
+	m.upstreamStatus.With(ex.provider, ex.sandboxID, strconv.Itoa(status)).Inc()
+	m.upstreamLatency.With(ex.provider, ex.sandboxID).Observe(time.Since(sent).Seconds())
+}
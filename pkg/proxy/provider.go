@@ -1,6 +1,15 @@
 package proxy
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"llm-proxy/pkg/session"
+)
 
 const (
 	// ProviderAnthropic is the Anthropic LLM provider.
@@ -11,36 +20,270 @@ const (
 
 	// ProviderOllama is the Ollama local LLM provider.
 	ProviderOllama = "ollama"
+
+	// ProviderGemini is Google's Gemini API.
+	ProviderGemini = "gemini"
 )
 
-// InjectAuth sets the provider-specific authentication headers on the
-// outgoing upstream request. It removes any existing auth headers from
-// the sandbox request first, then injects the real credentials.
-func InjectAuth(req *http.Request, provider, apiKey string) {
-	// Remove sandbox auth — these carried the session token, not real keys.
-	req.Header.Del("Authorization")
-	req.Header.Del("x-api-key")
-
-	switch provider {
-	case ProviderAnthropic:
-		req.Header.Set("x-api-key", apiKey)
-	case ProviderOpenAI:
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-	case ProviderOllama:
-		// No auth needed for Ollama.
-	}
-}
-
-// DefaultUpstream returns the default upstream URL for a provider.
-func DefaultUpstream(provider string) string {
-	switch provider {
-	case ProviderAnthropic:
-		return "https://api.anthropic.com"
-	case ProviderOpenAI:
-		return "https://api.openai.com"
-	case ProviderOllama:
-		return "http://localhost:11434"
-	default:
-		return ""
+// Stream formats, named by the Content-Type of the stream.
+const (
+	// StreamSSE is server-sent events.
+	StreamSSE = "text/event-stream"
+
+	// StreamNDJSON is newline-delimited JSON.
+	StreamNDJSON = "application/x-ndjson"
+)
+
+// Provider adapts the proxy to one LLM API. Built-in providers are in
+// DefaultProviders; others are added with Register.
+type Provider interface {
+	// Name is the provider name sessions are registered with.
+	Name() string
+
+	// DefaultUpstream is the base URL used when neither the session nor
+	// the proxy configuration names one. Empty means one is required.
+	DefaultUpstream() string
+
+	// InjectAuth removes the credentials the sandbox sent (its session
+	// token) from req and adds the real API key.
+	InjectAuth(req *http.Request, apiKey string)
+
+	// StreamFormat is how the provider frames streamed responses,
+	// StreamSSE or StreamNDJSON.
+	StreamFormat() string
+
+	// ErrorResponse returns the status and JSON body the provider would
+	// answer with for kind, so SDKs in the sandbox react to the proxy's
+	// own rejections as they would to the provider's.
+	ErrorResponse(kind ErrorKind, message, requestID string) (status int, body any)
+
+	// AllowPath reports whether sandboxes may call path.
+	AllowPath(path string) bool
+}
+
+// SessionProvider is implemented by providers that take settings from the
+// session, such as a cloud resource or region.
+type SessionProvider interface {
+	// ValidateSession checks the session's provider settings when it is
+	// registered.
+	ValidateSession(sess *session.Session) error
+
+	// SessionUpstream returns the base URL implied by the session's
+	// settings, or "" to fall back to the configured or default one.
+	SessionUpstream(sess *session.Session) string
+}
+
+// RequestPreparer is implemented by providers that need more than auth
+// injection, such as rewriting the path or signing the request. The proxy
+// buffers the whole request body for them (up to MaxPreparedBodySize) and
+// calls PrepareRequest on every attempt, after InjectAuth and once all
+// other headers are set. It may replace the body. path is the sandbox's
+// own request path: req.URL.Path is the upstream base path followed by
+// path, and only that trailing part is the preparer's to rewrite.
+type RequestPreparer interface {
+	PrepareRequest(req *http.Request, path string, sess *session.Session, apiKey string, body []byte) error
+}
+
+// ResponseTransformer is implemented by providers whose responses must be
+// converted before they reach the sandbox, such as a binary stream framing.
+// TransformResponse is called on the response that will be relayed, before
+// its headers are copied, and may replace its headers and body.
+type ResponseTransformer interface {
+	TransformResponse(resp *http.Response)
+}
+
+// MaxPreparedBodySize is the largest request body accepted for providers
+// that implement RequestPreparer.
+const MaxPreparedBodySize = 32 << 20
+
+// RequestError is returned by PrepareRequest when the sandbox's request
+// cannot be expressed for the provider. Message is returned to the
+// sandbox with a 400.
+type RequestError struct {
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// ProviderRegistry holds the providers sessions may use, by name.
+type ProviderRegistry struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+// NewProviderRegistry creates an empty registry.
+func NewProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{providers: make(map[string]Provider)}
+}
+
+// Register adds p. It fails if p has no name or the name is taken.
+func (r *ProviderRegistry) Register(p Provider) error {
+	name := p.Name()
+	if name == "" {
+		return fmt.Errorf("provider has no name")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.providers[name]; ok {
+		return fmt.Errorf("provider %q is already registered", name)
+	}
+	r.providers[name] = p
+	return nil
+}
+
+// Lookup returns the provider registered as name.
+func (r *ProviderRegistry) Lookup(name string) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names returns the registered provider names, sorted.
+func (r *ProviderRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultProviders is the registry used unless WithProviders gives
+// another. It starts with the built-in providers.
+var DefaultProviders = builtinProviders()
+
+// Register adds p to DefaultProviders.
+func Register(p Provider) error {
+	return DefaultProviders.Register(p)
+}
+
+func builtinProviders() *ProviderRegistry {
+	r := NewProviderRegistry()
+	for _, p := range []Provider{
+		&headerProvider{
+			name:     ProviderAnthropic,
+			upstream: "https://api.anthropic.com",
+			header:   "x-api-key",
+			stream:   StreamSSE,
+			paths:    []string{"/v1/"},
+			errors:   anthropicErrors,
+			shape:    anthropicError,
+		},
+		&headerProvider{
+			name:     ProviderOpenAI,
+			upstream: "https://api.openai.com",
+			header:   "Authorization",
+			prefix:   "Bearer ",
+			stream:   StreamSSE,
+			paths:    []string{"/v1/"},
+			errors:   openAIErrors,
+			shape:    openAIError,
+		},
+		&headerProvider{
+			// Ollama runs locally and needs no auth. It serves its own API
+			// under /api/ and an OpenAI-compatible one under /v1/.
+			name:     ProviderOllama,
+			upstream: "http://localhost:11434",
+			stream:   StreamNDJSON,
+			paths:    []string{"/api/", "/v1/"},
+		},
+		&headerProvider{
+			// Gemini streams SSE from :streamGenerateContent?alt=sse.
+			name:     ProviderGemini,
+			upstream: "https://generativelanguage.googleapis.com",
+			header:   "x-goog-api-key",
+			stream:   StreamSSE,
+			paths:    []string{"/v1/", "/v1beta/", "/upload/v1beta/"},
+			errors:   geminiErrors,
+			shape:    geminiError,
+		},
+		newAzureProvider(),
+		newBedrockProvider(),
+	} {
+		if err := r.Register(p); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// sandboxAuthHeaders carry the session token on sandbox requests and are
+// removed before the real credentials are injected.
+var sandboxAuthHeaders = []string{"Authorization", "x-api-key", "api-key", "x-goog-api-key"}
+
+// sandboxAuthQuery is the query parameter that can carry the session token
+// instead, as Google SDKs send it. It is removed the same way.
+const sandboxAuthQuery = "key"
+
+// headerProvider is a provider that authenticates with the API key in a
+// single header or query parameter. The built-ins and providers declared
+// in a ProviderConfig are all headerProviders.
+type headerProvider struct {
+	name     string
+	upstream string
+
+	// header carries prefix+key+suffix; empty sends no header.
+	header string
+	prefix string
+	suffix string
+
+	// query carries the key as a query parameter instead, replacing any
+	// the sandbox sent.
+	query string
+
+	// headers are set on every request, before the key.
+	headers map[string]string
+
+	stream string
+
+	// paths are the allowed path prefixes.
+	paths []string
+
+	errors map[ErrorKind]providerError
+	shape  func(pe providerError, message, requestID string) any
+}
+
+func (p *headerProvider) Name() string            { return p.name }
+func (p *headerProvider) DefaultUpstream() string { return p.upstream }
+func (p *headerProvider) StreamFormat() string    { return p.stream }
+
+func (p *headerProvider) InjectAuth(req *http.Request, apiKey string) {
+	for _, h := range sandboxAuthHeaders {
+		req.Header.Del(h)
+	}
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	if p.header != "" {
+		req.Header.Set(p.header, p.prefix+apiKey+p.suffix)
+	}
+	if q := req.URL.Query(); q.Has(sandboxAuthQuery) || p.query != "" {
+		q.Del(sandboxAuthQuery)
+		if p.query != "" {
+			q.Set(p.query, apiKey)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+}
+
+func (p *headerProvider) AllowPath(path string) bool {
+	return slices.ContainsFunc(p.paths, func(prefix string) bool {
+		return strings.HasPrefix(path, prefix)
+	})
+}
+
+func (p *headerProvider) ErrorResponse(kind ErrorKind, message, requestID string) (int, any) {
+	pe, ok := p.errors[kind]
+	if !ok {
+		pe = providerError{status: http.StatusTooManyRequests}
+	}
+	if p.shape == nil {
+		return pe.status, plainError(message, requestID)
 	}
+	return pe.status, p.shape(pe, message, requestID)
 }
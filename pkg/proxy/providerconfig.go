@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// keyPlaceholder marks where the API key goes in ProviderConfig.Value.
+const keyPlaceholder = "{{key}}"
+
+// ProviderConfig declares a provider that only differs from the built-ins
+// in where it is and how the key is sent, such as an OpenAI-compatible
+// vendor. See LoadProviderConfigs.
+type ProviderConfig struct {
+	// Name is the provider name sessions are registered with.
+	Name string `json:"name"`
+
+	// Upstream is the default base URL. If empty, sessions must name one.
+	Upstream string `json:"upstream,omitempty"`
+
+	// Header carries the key, formatted by Value.
+	Header string `json:"header,omitempty"`
+
+	// Value is the Header value with {{key}} standing for the key, e.g.
+	// "Bearer {{key}}". Defaults to "{{key}}".
+	Value string `json:"value,omitempty"`
+
+	// Query carries the key as a query parameter instead of a header.
+	Query string `json:"query,omitempty"`
+
+	// Headers are static headers set on every request.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Stream is how responses are streamed: "sse" (the default) or
+	// "ndjson".
+	Stream string `json:"stream,omitempty"`
+
+	// Paths are the path prefixes sandboxes may call. Defaults to "/v1/".
+	Paths []string `json:"paths,omitempty"`
+
+	// Errors is the shape of the proxy's own error responses: "openai"
+	// (the default), "anthropic" or "plain".
+	Errors string `json:"errors,omitempty"`
+}
+
+// LoadProviderConfigs reads a JSON array of provider definitions from path
+// and checks each one.
+func LoadProviderConfigs(path string) ([]ProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read provider config: %w", err)
+	}
+	var configs []ProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse provider config: %w", err)
+	}
+	for _, c := range configs {
+		if _, err := c.Provider(); err != nil {
+			return nil, fmt.Errorf("provider config: %w", err)
+		}
+	}
+	return configs, nil
+}
+
+// Provider builds the provider c describes.
+func (c ProviderConfig) Provider() (Provider, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("provider has no name")
+	}
+	if c.Header == "" && c.Query == "" {
+		return nil, fmt.Errorf("provider %q: header or query is required", c.Name)
+	}
+	if c.Header != "" && c.Query != "" {
+		return nil, fmt.Errorf("provider %q: header and query are mutually exclusive", c.Name)
+	}
+
+	p := &headerProvider{
+		name:     c.Name,
+		upstream: strings.TrimSuffix(c.Upstream, "/"),
+		header:   c.Header,
+		query:    c.Query,
+		headers:  c.Headers,
+		paths:    c.Paths,
+	}
+	if len(p.paths) == 0 {
+		p.paths = []string{"/v1/"}
+	}
+
+	if c.Header != "" {
+		value := c.Value
+		if value == "" {
+			value = keyPlaceholder
+		}
+		if strings.Count(value, keyPlaceholder) != 1 {
+			return nil, fmt.Errorf("provider %q: value must contain %s exactly once", c.Name, keyPlaceholder)
+		}
+		p.prefix, p.suffix, _ = strings.Cut(value, keyPlaceholder)
+	} else if c.Value != "" {
+		return nil, fmt.Errorf("provider %q: value only applies to header auth", c.Name)
+	}
+	for name := range c.Headers {
+		if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(c.Header) {
+			return nil, fmt.Errorf("provider %q: static header %q would replace the key", c.Name, name)
+		}
+	}
+
+	switch c.Stream {
+	case "", "sse":
+		p.stream = StreamSSE
+	case "ndjson":
+		p.stream = StreamNDJSON
+	default:
+		return nil, fmt.Errorf("provider %q: unknown stream type %q (want sse or ndjson)", c.Name, c.Stream)
+	}
+
+	switch c.Errors {
+	case "", "openai":
+		p.errors, p.shape = openAIErrors, openAIError
+	case "anthropic":
+		p.errors, p.shape = anthropicErrors, anthropicError
+	case "plain":
+	default:
+		return nil, fmt.Errorf("provider %q: unknown error shape %q (want openai, anthropic or plain)", c.Name, c.Errors)
+	}
+	return p, nil
+}
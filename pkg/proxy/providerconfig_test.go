@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProviderConfig_Provider(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ProviderConfig
+		wantErr bool
+	}{
+		{"bearer header", ProviderConfig{Name: "groq", Header: "Authorization", Value: "Bearer {{key}}"}, false},
+		{"query", ProviderConfig{Name: "q", Query: "key", Stream: "ndjson"}, false},
+		{"no name", ProviderConfig{Header: "Authorization"}, true},
+		{"no auth", ProviderConfig{Name: "x"}, true},
+		{"header and query", ProviderConfig{Name: "x", Header: "X-Key", Query: "key"}, true},
+		{"value without placeholder", ProviderConfig{Name: "x", Header: "X-Key", Value: "Token"}, true},
+		{"value with query", ProviderConfig{Name: "x", Query: "key", Value: "{{key}}"}, true},
+		{"static header replaces key", ProviderConfig{Name: "x", Header: "X-Key", Headers: map[string]string{"x-key": "v"}}, true},
+		{"unknown stream", ProviderConfig{Name: "x", Header: "X-Key", Stream: "websocket"}, true},
+		{"unknown error shape", ProviderConfig{Name: "x", Header: "X-Key", Errors: "soap"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.config.Provider()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Provider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProviderConfig_InjectAuth(t *testing.T) {
+	prov, err := ProviderConfig{
+		Name:    "together",
+		Header:  "Authorization",
+		Value:   "Token {{key}}",
+		Headers: map[string]string{"X-Title": "sandbox"},
+	}.Provider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", "https://api.together.xyz/v1/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer session-token")
+	req.Header.Set("x-api-key", "session-token")
+	prov.InjectAuth(req, "real-key")
+	if got := req.Header.Get("Authorization"); got != "Token real-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := req.Header.Get("x-api-key"); got != "" {
+		t.Errorf("x-api-key = %q, want removed", got)
+	}
+	if got := req.Header.Get("X-Title"); got != "sandbox" {
+		t.Errorf("X-Title = %q", got)
+	}
+
+	prov, err = ProviderConfig{Name: "q", Query: "key"}.Provider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("GET", "https://example.com/v1/models?key=session-token&page=2", nil)
+	prov.InjectAuth(req, "real-key")
+	if q := req.URL.Query(); q.Get("key") != "real-key" || len(q["key"]) != 1 || q.Get("page") != "2" {
+		t.Errorf("query = %q", req.URL.RawQuery)
+	}
+}
+
+func TestLoadProviderConfigs(t *testing.T) {
+	configs := []ProviderConfig{
+		{Name: "groq", Upstream: "https://api.groq.com/openai/", Header: "Authorization", Value: "Bearer {{key}}"},
+		{Name: "vllm", Upstream: "http://vllm:8000", Header: "Authorization", Value: "Bearer {{key}}", Errors: "plain"},
+	}
+	data, _ := json.Marshal(configs)
+	path := filepath.Join(t.TempDir(), "providers.json")
+	os.WriteFile(path, data, 0o600)
+
+	got, err := LoadProviderConfigs(path)
+	if err != nil {
+		t.Fatalf("LoadProviderConfigs: %v", err)
+	}
+	reg := NewProviderRegistry()
+	for _, c := range got {
+		prov, _ := c.Provider()
+		if err := reg.Register(prov); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groq, _ := reg.Lookup("groq")
+	if groq.DefaultUpstream() != "https://api.groq.com/openai" || !groq.AllowPath("/v1/chat/completions") || groq.StreamFormat() != StreamSSE {
+		t.Fatalf("groq = %+v", groq)
+	}
+	status, body := groq.ErrorResponse(ErrorBudgetExhausted, "spent", "req-1")
+	if status != http.StatusTooManyRequests || body.(map[string]any)["error"].(map[string]any)["code"] != "insufficient_quota" {
+		t.Fatalf("groq budget error = %d %v", status, body)
+	}
+
+	os.WriteFile(path, []byte(`[{"name":"broken"}]`), 0o600)
+	if _, err := LoadProviderConfigs(path); err == nil {
+		t.Fatal("expected error for a provider without auth")
+	}
+}
@@ -4,26 +4,197 @@
 package proxy
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"llm-proxy/pkg/accesslog"
+	"llm-proxy/pkg/budget"
+	"llm-proxy/pkg/capture"
+	"llm-proxy/pkg/credential"
+	"llm-proxy/pkg/logging"
+	"llm-proxy/pkg/metrics"
+	"llm-proxy/pkg/ratelimit"
 	"llm-proxy/pkg/session"
+	"llm-proxy/pkg/signedtoken"
+	"llm-proxy/pkg/trace"
+	"llm-proxy/pkg/usage"
+)
+
+const (
+	// RequestIDHeader carries the proxy's request ID. It is accepted from
+	// the sandbox, forwarded upstream and echoed in every response.
+	RequestIDHeader = "X-Request-ID"
+
+	// UpstreamRequestIDHeader returns a provider's X-Request-ID to the
+	// sandbox when the proxy's own ID takes its place.
+	UpstreamRequestIDHeader = "X-Upstream-Request-ID"
+
+	// maxRequestIDLength bounds accepted incoming request IDs.
+	maxRequestIDLength = 128
 )
 
 // Proxy is the credential-injecting LLM reverse proxy.
 type Proxy struct {
-	store      session.Store
-	httpClient *http.Client
-	logger     *log.Logger
+	store     session.Store
+	hasher    *session.TokenHasher
+	verifier  *signedtoken.Verifier
+	catalog   credential.Catalog
+	limiter   *ratelimit.Limiter
+	usage     *usage.Ledger
+	budgets   *budget.Tracker
+	prices    budget.PriceTable
+	registry  *metrics.Registry
+	metrics   *proxyMetrics
+	tracer    *trace.Tracer
+	accessLog *accesslog.Log
+	capture   *capture.Recorder
+	retry     *RetryPolicy
+	breaker   *circuitBreaker
+
+	// providers resolves session provider names.
+	providers *ProviderRegistry
+
+	// providerUpstreams overrides Provider.DefaultUpstream per provider.
+	providerUpstreams map[string][]string
+	httpClient        *http.Client
+	logger            *slog.Logger
+}
+
+// Option configures optional Proxy behaviour.
+type Option func(*Proxy)
+
+// WithTokenHasher makes the proxy hash session tokens before looking them
+// up, matching a store populated with hashed tokens.
+func WithTokenHasher(h *session.TokenHasher) Option {
+	return func(p *Proxy) {
+		p.hasher = h
+	}
+}
+
+// WithSignedTokens accepts stateless signed tokens verified by v in
+// addition to registered session tokens.
+func WithSignedTokens(v *signedtoken.Verifier) Option {
+	return func(p *Proxy) {
+		p.verifier = v
+	}
+}
+
+// WithCatalog resolves credential references in sessions and signed
+// tokens against c.
+func WithCatalog(c credential.Catalog) Option {
+	return func(p *Proxy) {
+		p.catalog = c
+	}
+}
+
+// WithUsage meters the tokens consumed by every successful response and
+// records them in l.
+func WithUsage(l *usage.Ledger) Option {
+	return func(p *Proxy) {
+		p.usage = l
+	}
+}
+
+// WithBudgets tracks session and sandbox spend in t instead of a private
+// tracker, so it can be inspected and topped up.
+func WithBudgets(t *budget.Tracker) Option {
+	return func(p *Proxy) {
+		p.budgets = t
+	}
+}
+
+// WithPriceTable prices metered usage for dollar budgets and cost
+// reporting.
+func WithPriceTable(t budget.PriceTable) Option {
+	return func(p *Proxy) {
+		p.prices = t
+	}
+}
+
+// WithMetrics registers the proxy's metrics in reg.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(p *Proxy) {
+		p.registry = reg
+	}
+}
+
+// WithTracer records a span for every proxied request and propagates the
+// trace to the upstream via the traceparent header.
+func WithTracer(t *trace.Tracer) Option {
+	return func(p *Proxy) {
+		p.tracer = t
+	}
+}
+
+// WithAccessLog writes one entry per proxied request to l.
+func WithAccessLog(l *accesslog.Log) Option {
+	return func(p *Proxy) {
+		p.accessLog = l
+	}
+}
+
+// WithCapture archives exchanges of sessions and sandboxes with capture
+// switched on to rec.
+func WithCapture(rec *capture.Recorder) Option {
+	return func(p *Proxy) {
+		p.capture = rec
+	}
+}
+
+// WithRetries retries failed connections and retryable upstream statuses
+// under rp. Without it every request is sent once.
+func WithRetries(rp RetryPolicy) Option {
+	return func(p *Proxy) {
+		p.retry = &rp
+	}
+}
+
+// WithProviders resolves session providers in reg instead of
+// DefaultProviders.
+func WithProviders(reg *ProviderRegistry) Option {
+	return func(p *Proxy) {
+		p.providers = reg
+	}
+}
+
+// WithUpstreams sends sessions of provider that have no upstream URL of
+// their own to urls, tried in order, instead of the provider default.
+func WithUpstreams(provider string, urls ...string) Option {
+	return func(p *Proxy) {
+		if p.providerUpstreams == nil {
+			p.providerUpstreams = make(map[string][]string)
+		}
+		p.providerUpstreams[provider] = urls
+	}
+}
+
+// WithCircuitBreaker sets how many consecutive failures take an upstream
+// out of a failover list and for how long. Defaults to
+// DefaultCircuitFailures and DefaultCircuitCoolDown.
+func WithCircuitBreaker(failures int, coolDown time.Duration) Option {
+	return func(p *Proxy) {
+		p.breaker = newCircuitBreaker(failures, coolDown)
+	}
 }
 
 // New creates a new Proxy with the given session store and logger.
-func New(store session.Store, logger *log.Logger) *Proxy {
-	return &Proxy{
-		store: store,
+func New(store session.Store, logger *slog.Logger, opts ...Option) *Proxy {
+	p := &Proxy{
+		store:   store,
+		limiter: ratelimit.New(),
+		budgets: budget.NewTracker(),
 		httpClient: &http.Client{
 			// LLM requests can be slow, especially with thinking blocks.
 			Timeout: 5 * time.Minute,
@@ -34,74 +205,584 @@ func New(store session.Store, logger *log.Logger) *Proxy {
 		},
 		logger: logger,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.providers == nil {
+		p.providers = DefaultProviders
+	}
+	if p.breaker == nil {
+		p.breaker = newCircuitBreaker(DefaultCircuitFailures, DefaultCircuitCoolDown)
+	}
+	if p.registry == nil {
+		p.registry = metrics.NewRegistry()
+	}
+	p.metrics = newProxyMetrics(p.registry)
+	return p
 }
 
 // ServeHTTP implements http.Handler. Every request is authenticated via
 // session token, has its credentials swapped, and is forwarded upstream.
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w}
+	ex := &exchange{requestID: requestID(r), start: time.Now()}
+	ex.logger = p.logger.With(logging.KeyRequestID, ex.requestID)
+	rw.Header().Set(RequestIDHeader, ex.requestID)
+	defer p.logAccess(r, ex, rw)
+	defer p.metrics.observeRequest(ex, rw)
+	p.startSpan(r, ex)
+	defer p.finishSpan(ex, rw)
+
+	p.serve(rw, r, ex)
+}
+
+// serve handles one request, recording what it learns in ex.
+func (p *Proxy) serve(w *responseWriter, r *http.Request, ex *exchange) {
 	// Extract session token from auth header.
 	token := extractToken(r)
 	if token == "" {
-		http.Error(w, `{"error":"missing or invalid authorization header"}`, http.StatusUnauthorized)
+		writeError(w, ex.requestID, http.StatusUnauthorized, "missing or invalid authorization header")
 		return
 	}
 
 	// Look up session.
 	sess, err := p.lookupSession(token)
 	if err != nil {
-		http.Error(w, `{"error":"invalid session token"}`, http.StatusUnauthorized)
+		writeError(w, ex.requestID, http.StatusUnauthorized, "invalid session token")
 		return
 	}
+	ex.provider, ex.sandboxID = sess.Provider, sess.SandboxID
+	if strings.HasPrefix(sess.Token, signedSessionPrefix) {
+		p.metrics.track(sess.SandboxID, sess.ExpiresAt)
+	}
+	ex.logger = ex.logger.With(logging.KeySandboxID, sess.SandboxID, logging.KeyProvider, sess.Provider)
 
-	// Resolve upstream URL.
-	upstream := sess.UpstreamURL
-	if upstream == "" {
-		upstream = DefaultUpstream(sess.Provider)
+	// Resolve the provider and its upstream URLs.
+	prov, ok := p.providers.Lookup(sess.Provider)
+	if !ok {
+		writeError(w, ex.requestID, http.StatusBadRequest, "unknown provider")
+		return
+	}
+	if !prov.AllowPath(r.URL.Path) {
+		writeError(w, ex.requestID, http.StatusForbidden, "path not allowed for provider")
+		return
 	}
-	if upstream == "" {
-		http.Error(w, `{"error":"unknown provider"}`, http.StatusBadRequest)
+	upstreams := p.upstreams(sess, prov)
+	if len(upstreams) == 0 {
+		writeError(w, ex.requestID, http.StatusBadRequest, "no upstream configured for provider")
 		return
 	}
 
-	upstreamURL := upstream + r.URL.Path
-	if r.URL.RawQuery != "" {
-		upstreamURL += "?" + r.URL.RawQuery
+	// Refuse new work once a session or sandbox budget is spent.
+	scopes := budgetScopes(sess)
+	if err := p.budgets.Check(scopes...); err != nil {
+		ex.logger.Warn("budget exhausted", logging.Err(err))
+		writeProviderError(w, prov, ex.requestID, ErrorBudgetExhausted, "Spending budget exhausted for this sandbox session.", 0)
+		return
 	}
 
-	// Build upstream request.
-	upstreamReq, err := http.NewRequestWithContext(r.Context(), r.Method, upstreamURL, r.Body)
-	if err != nil {
-		p.logger.Printf("error creating upstream request: %v", err)
-		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+	// Enforce session and sandbox limits. The slot is held until the
+	// response body has been relayed, so long streams count as in flight.
+	release, wait, ok := p.limiter.Acquire(limitScopes(sess)...)
+	if !ok {
+		ex.logger.Warn("rate limited", "retry_after", wait)
+		writeProviderError(w, prov, ex.requestID, ErrorRateLimited, "Rate limit exceeded for this sandbox session. Please retry later.", wait)
 		return
 	}
+	defer release()
 
-	// Copy client headers, then inject real credentials.
-	copyHeaders(upstreamReq.Header, r.Header)
-	InjectAuth(upstreamReq, sess.Provider, sess.APIKey)
+	inFlight := p.metrics.inFlight.With(ex.provider, ex.sandboxID)
+	inFlight.Inc()
+	defer inFlight.Dec()
 
-	p.logger.Printf("proxying %s %s -> %s (provider=%s sandbox=%s)",
-		r.Method, r.URL.Path, upstreamURL, sess.Provider, sess.SandboxID)
+	// Usage is metered for reporting, to charge budgets and to annotate
+	// spans.
+	metered := p.usage != nil || sess.Budget != nil || sess.SandboxBudget != nil || ex.span != nil
 
-	// Execute upstream request.
-	resp, err := p.httpClient.Do(upstreamReq)
+	// The body is counted (and captured) as it is read, once, however many
+	// attempts it takes. It is buffered if it may be resent to another
+	// upstream or on retry. An empty body is passed through as is so it is
+	// not sent chunked.
+	capturing := p.capture != nil && (sess.Capture || p.capture.SandboxEnabled(sess.SandboxID))
+	var reqCapture *capture.BodyWriter
+	reqBody := r.Body
+	if reqBody != nil && reqBody != http.NoBody {
+		if capturing {
+			reqCapture = &capture.BodyWriter{}
+			reqBody = teeReadCloser{Reader: io.TeeReader(reqBody, reqCapture), Closer: reqBody}
+		}
+		reqBody = &countingReader{ReadCloser: reqBody, n: &ex.bytesIn}
+	}
+	// Preparers and budgets need the whole body: budgets to price the
+	// requested model and to estimate spend a response does not report.
+	limit := p.resendLimit(len(upstreams))
+	_, preparing := prov.(RequestPreparer)
+	capped := budgeted(scopes)
+	dollars := dollarBudget(scopes)
+	if preparing || capped {
+		limit = max(limit, MaxPreparedBodySize)
+	}
+	payload, err := newRequestBody(reqBody, limit)
 	if err != nil {
-		p.logger.Printf("upstream request failed: %v", err)
-		http.Error(w, `{"error":"upstream request failed"}`, http.StatusBadGateway)
+		ex.logger.Warn("error reading request body", logging.Err(err))
+		writeError(w, ex.requestID, http.StatusBadRequest, "error reading request body")
+		return
+	}
+	if (preparing || capped) && !payload.replayable() {
+		writeError(w, ex.requestID, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+
+	// A dollar budget can only be charged for priced models, so a request
+	// under one must name a model the price table covers. Reads such as
+	// model listings use no tokens.
+	model := requestModel(r.URL.Path, payload.buffered)
+	if dollars && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if _, priced := p.prices.Lookup(model); !priced {
+			ex.logger.Warn("unpriced model under a dollar budget", "model", model)
+			writeProviderError(w, prov, ex.requestID, ErrorBudgetExhausted, "This model has no price, so it cannot be used under a spending budget.", 0)
+			return
+		}
+	}
+
+	// A budget is charged even for responses that report no usage: streams
+	// are asked to report it, and whatever still goes unreported is charged
+	// as an estimate of the most the request could have used.
+	var estimate *usage.Usage
+	if capped && r.Method != http.MethodGet && r.Method != http.MethodHead && !countsTokens(r.URL.Path) {
+		u := estimateUsage(payload.buffered)
+		estimate = &u
+		if body, ok := includeStreamUsage(r.URL.Path, payload.buffered); ok {
+			payload.buffered = body
+		}
+	}
+
+	resp, lease, sent, ok := p.forward(w, r, sess, prov, upstreams, payload, metered, ex)
+	if !ok {
 		return
 	}
+	if rt, ok := prov.(ResponseTransformer); ok {
+		rt.TransformResponse(resp)
+	}
 	defer resp.Body.Close()
+	ex.upstreamStatus = resp.StatusCode
+	if id := upstreamRequestID(resp.Header); id != "" {
+		ex.upstreamRequestID = id
+		ex.logger = ex.logger.With(logging.KeyUpstreamRequestID, id)
+	}
+	// A pooled key stays in flight until the body has been relayed.
+	defer lease.Release(resp.StatusCode, retryAfter(resp.Header))
 
-	// Copy response headers.
+	// Copy response headers. The sandbox keeps seeing the proxy's request
+	// ID; a provider ID sent under the same header moves aside.
 	copyHeaders(w.Header(), resp.Header)
+	w.Header().Set(RequestIDHeader, ex.requestID)
+	if id := resp.Header.Get(RequestIDHeader); id != "" {
+		w.Header().Set(UpstreamRequestIDHeader, id)
+	}
 	w.WriteHeader(resp.StatusCode)
 
-	// Stream or copy response body.
-	if isStreamingResponse(resp) {
-		StreamResponse(w, resp.Body)
+	// Stream or copy response body. The usage meter reads along without
+	// holding anything back from the client.
+	var body io.Reader = resp.Body
+	var chunks *capture.ChunkWriter
+	if capturing {
+		chunks = capture.NewChunkWriter(time.Now())
+		body = io.TeeReader(body, chunks)
+	}
+	streaming := isStreamingResponse(resp)
+	var meter *usage.Meter
+	if metered && resp.StatusCode < 300 {
+		meter = usage.NewMeter(streamContentType(resp, prov, streaming))
+		body = io.TeeReader(body, meter)
+	}
+	body = &firstByteReader{r: body, onFirst: func() {
+		ex.ttfb = time.Since(ex.start)
+		if streaming {
+			p.metrics.streamFirstByte.With(ex.provider, ex.sandboxID).Observe(time.Since(sent).Seconds())
+		}
+	}}
+	if streaming {
+		ex.streaming = true
+		relayed := time.Now()
+		StreamResponse(w, body)
+		ex.streamDuration = time.Since(relayed)
 	} else {
-		io.Copy(w, resp.Body)
+		io.Copy(w, body)
+	}
+
+	if meter != nil {
+		p.recordUsage(sess, meter, model, estimate, ex)
+	}
+	if capturing {
+		p.saveCapture(r, resp, ex, reqCapture, chunks)
+	}
+}
+
+// forward sends the request upstream and returns the response to relay
+// together with the lease of the key that produced it and when that
+// attempt was sent. Each round walks the session's upstreams in order,
+// skipping those with an open circuit and failing over on connection
+// errors and 5xx; when the last one fails the retry policy decides
+// whether to back off and start another round. A connection error is
+// only failed over or retried if the request never reached the upstream. If no response is to be
+// relayed the sandbox has already been answered and ok is false.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, sess *session.Session, prov Provider, upstreams []string, body *requestBody, metered bool, ex *exchange) (resp *http.Response, lease *credential.Lease, sent time.Time, ok bool) {
+	target := r.URL.Path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+rounds:
+	for round := 1; ; round++ {
+		order := p.breaker.order(upstreams)
+		for i, upstream := range order {
+			ex.attempts++
+			attempt, err := upstreamSession(sess, upstreams[0], upstream)
+			var upstreamReq *http.Request
+			var lease *credential.Lease
+			if err == nil {
+				upstreamReq, lease, err = p.upstreamRequest(r, attempt, prov, upstream+target, body, metered, ex)
+			}
+			var reqErr *RequestError
+			if errors.As(err, &reqErr) {
+				ex.logger.Warn("request rejected by provider", logging.Err(err))
+				writeError(w, ex.requestID, http.StatusBadRequest, reqErr.Message)
+				return nil, nil, sent, false
+			}
+			if err != nil {
+				ex.logger.Error("error creating upstream request", logging.Err(err))
+				writeError(w, ex.requestID, http.StatusInternalServerError, "internal error")
+				return nil, nil, sent, false
+			}
+			ex.logger.Debug("proxying request", "method", r.Method, "path", r.URL.Path, "upstream", upstream, "attempt", ex.attempts)
+
+			upstreamReq, connected := traceConnection(upstreamReq)
+			sent = time.Now()
+			resp, err := p.httpClient.Do(upstreamReq)
+			err = transportError(err)
+			failed := err != nil || resp.StatusCode >= 500
+			if len(upstreams) > 1 && p.breaker.record(upstream, failed) {
+				ex.logger.Warn("upstream circuit opened", "upstream", upstream, "cool_down", p.breaker.coolDown)
+			}
+			// A transport error is only resent if the upstream never got
+			// the request.
+			resendable := body.replayable() && r.Context().Err() == nil && (err == nil || !connected.Load())
+			failover := failed && i < len(order)-1 && resendable && p.mayFailOver(round, ex.attempts)
+
+			if err != nil {
+				lease.Release(0, 0)
+				if failover {
+					ex.logger.Warn("failing over to next upstream", "upstream", upstream, logging.Err(err))
+					p.metrics.failovers.With(ex.provider, ex.sandboxID).Inc()
+					continue
+				}
+				if wait, retry := p.shouldRetry(r.Context(), round, ex.attempts, body, 0, 0); retry && resendable {
+					ex.logger.Warn("retrying upstream request", "attempt", ex.attempts, "delay", wait, logging.Err(err))
+					p.metrics.retries.With(ex.provider, ex.sandboxID, "error").Inc()
+					if sleepCtx(r.Context(), wait) {
+						continue rounds
+					}
+				}
+				ex.upstreamErr = err
+				ex.logger.Error("upstream request failed", "attempts", ex.attempts, logging.Err(err))
+				writeError(w, ex.requestID, http.StatusBadGateway, "upstream request failed")
+				return nil, nil, sent, false
+			}
+			p.metrics.observeUpstream(ex, resp.StatusCode, sent)
+
+			hint := retryAfter(resp.Header)
+			if failover {
+				// Nothing has been sent to the sandbox yet; drop this
+				// response.
+				discard(resp, lease, hint)
+				ex.logger.Warn("failing over to next upstream", "upstream", upstream, "status", resp.StatusCode)
+				p.metrics.failovers.With(ex.provider, ex.sandboxID).Inc()
+				continue
+			}
+			if wait, retry := p.shouldRetry(r.Context(), round, ex.attempts, body, resp.StatusCode, hint); retry {
+				discard(resp, lease, hint)
+				ex.logger.Warn("retrying upstream request", "attempt", ex.attempts, "delay", wait, "status", resp.StatusCode)
+				p.metrics.retries.With(ex.provider, ex.sandboxID, strconv.Itoa(resp.StatusCode)).Inc()
+				if sleepCtx(r.Context(), wait) {
+					continue rounds
+				}
+				ex.upstreamErr = r.Context().Err()
+				writeError(w, ex.requestID, http.StatusBadGateway, "upstream request failed")
+				return nil, nil, sent, false
+			}
+			return resp, lease, sent, true
+		}
+	}
+}
+
+// transportError drops the request URL from an HTTP client error, keeping
+// its operation and cause. With query-string auth the URL carries the API
+// key, and this error is logged, written to the access log and exported on
+// the span.
+func transportError(err error) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", ue.Op, ue.Err)
+}
+
+// upstreamRequest builds one attempt's request to url. Client headers are
+// copied, then real credentials injected; sealed keys are decrypted here
+// and nowhere else. Every attempt checks out a fresh pooled key, so a
+// rate-limited key is skipped. The lease must be released.
+func (p *Proxy) upstreamRequest(r *http.Request, sess *session.Session, prov Provider, url string, body *requestBody, metered bool, ex *exchange) (*http.Request, *credential.Lease, error) {
+	upstreamReq, err := http.NewRequestWithContext(r.Context(), r.Method, url, body.reader())
+	if err != nil {
+		return nil, nil, err
+	}
+	apiKey, lease, err := p.apiKey(sess)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve api key: %w", err)
+	}
+	copyHeaders(upstreamReq.Header, r.Header)
+	prov.InjectAuth(upstreamReq, apiKey)
+	upstreamReq.Header.Set(RequestIDHeader, ex.requestID)
+	if ex.span != nil {
+		// The upstream call continues the trace as a child of this span.
+		// tracestate describes the trace rather than the span, so the
+		// sandbox's entries go along unchanged.
+		upstreamReq.Header.Set("traceparent", ex.span.Context.Traceparent())
+		if state := r.Header.Values("tracestate"); len(state) > 0 {
+			upstreamReq.Header["Tracestate"] = slices.Clone(state)
+		}
+	}
+	if metered {
+		// Let the transport negotiate compression so the meter sees the
+		// decoded body.
+		upstreamReq.Header.Del("Accept-Encoding")
+	}
+	if rp, ok := prov.(RequestPreparer); ok {
+		if err := rp.PrepareRequest(upstreamReq, r.URL.Path, sess, apiKey, body.buffered); err != nil {
+			lease.Release(http.StatusBadRequest, 0)
+			return nil, nil, err
+		}
+	}
+	return upstreamReq, lease, nil
+}
+
+// discard drops a response that will not be relayed and releases its key.
+func discard(resp *http.Response, lease *credential.Lease, retryAfter time.Duration) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	lease.Release(resp.StatusCode, retryAfter)
+}
+
+// upstreams returns the base URLs to try for sess in failover order: the
+// session's own, else one derived from its provider settings, else those
+// configured for its provider, else the provider default.
+func (p *Proxy) upstreams(sess *session.Session, prov Provider) []string {
+	if u := sess.Upstreams(); len(u) > 0 {
+		return u
+	}
+	if sp, ok := prov.(SessionProvider); ok {
+		if u := sp.SessionUpstream(sess); u != "" {
+			return []string{u}
+		}
+	}
+	if u := p.providerUpstreams[sess.Provider]; len(u) > 0 {
+		return u
+	}
+	if u := prov.DefaultUpstream(); u != "" {
+		return []string{u}
+	}
+	return nil
+}
+
+// resendLimit is the largest request body buffered so it can be sent more
+// than once, or zero if it will only be sent once.
+func (p *Proxy) resendLimit(upstreams int) int64 {
+	switch {
+	case p.retry != nil && p.retry.MaxAttempts > 1:
+		return p.retry.MaxBodySize
+	case upstreams > 1:
+		return DefaultRetryPolicy().MaxBodySize
+	}
+	return 0
+}
+
+// mayFailOver reports whether a failed attempt may move on to the next
+// upstream of its round. The first pass over the list always completes;
+// later rounds only happen under a retry policy, and there every attempt
+// counts against MaxAttempts.
+func (p *Proxy) mayFailOver(round, attempts int) bool {
+	return round == 1 || p.retry != nil && attempts < p.retry.MaxAttempts
+}
+
+// shouldRetry decides whether a failed round (status 0 for a transport
+// error) is retried and after how long, given the attempts sent so far.
+func (p *Proxy) shouldRetry(ctx context.Context, round, attempts int, body *requestBody, status int, hint time.Duration) (time.Duration, bool) {
+	rp := p.retry
+	if rp == nil || attempts >= rp.MaxAttempts || !body.replayable() || ctx.Err() != nil {
+		return 0, false
+	}
+	if status != 0 && !rp.retryableStatus(status) {
+		return 0, false
+	}
+	return rp.delay(round, hint)
+}
+
+// saveCapture archives one exchange. Credentials are stripped from headers
+// and the query string; bodies are kept byte for byte.
+func (p *Proxy) saveCapture(r *http.Request, resp *http.Response, ex *exchange, reqBody *capture.BodyWriter, chunks *capture.ChunkWriter) {
+	rec := &capture.Exchange{
+		RequestID: ex.requestID,
+		Time:      ex.start,
+		SandboxID: ex.sandboxID,
+		Provider:  ex.provider,
+		Request: capture.Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  capture.StripQuery(r.URL.RawQuery),
+			Header: capture.StripHeaders(r.Header),
+		},
+		Response: capture.Response{
+			Status: resp.StatusCode,
+			Header: capture.StripHeaders(resp.Header),
+		},
+	}
+	if reqBody != nil {
+		rec.Request.Body, rec.Request.Truncated = reqBody.Bytes()
+	}
+	rec.Response.Chunks, rec.Response.Truncated = chunks.Chunks()
+
+	path, err := p.capture.Save(rec)
+	if err != nil {
+		ex.logger.Error("error saving capture", logging.Err(err))
+		return
+	}
+	ex.logger.Debug("captured exchange", "path", path)
+}
+
+// recordUsage adds the usage measured by meter to the ledger and charges
+// it to budgets. The model and usage are also noted in ex. If the model
+// the response reports has no price, the usage is priced as the model the
+// request named, which a dollar budget has already required to be priced.
+// If the response reports no usage, budgets are charged estimate instead,
+// when given; the ledger only ever records measured usage.
+func (p *Proxy) recordUsage(sess *session.Session, meter *usage.Meter, requested string, estimate *usage.Usage, ex *exchange) {
+	model, u, ok := meter.Finish()
+	if !ok {
+		if estimate != nil {
+			cost, _ := p.prices.Cost(requested, *estimate)
+			p.budgets.Charge(estimate.Total(), cost, budgetScopes(sess)...)
+			ex.logger.Warn("no usage in response; charged budgets an estimate",
+				"model", requested, "tokens", estimate.Total(), "cost_usd", cost)
+		}
+		return
+	}
+	ex.model, ex.usage = model, u
+	cost, priced := p.prices.Cost(model, u)
+	if !priced && requested != "" {
+		cost, priced = p.prices.Cost(requested, u)
+	}
+
+	scopes := budgetScopes(sess)
+	p.budgets.Charge(u.Total(), cost, scopes...)
+	if !priced && len(scopes) > 0 {
+		ex.logger.Warn("no price for model; dollar budgets not charged", "model", model)
+	}
+
+	if p.usage != nil {
+		p.usage.Add(usage.Record{
+			Time:      time.Now(),
+			SessionID: usage.SessionID(sess.Token),
+			SandboxID: sess.SandboxID,
+			Provider:  sess.Provider,
+			Model:     model,
+			Usage:     u,
+			CostUSD:   cost,
+		})
+	}
+	ex.logger.Info("usage", "model", model,
+		"input_tokens", u.InputTokens, "output_tokens", u.OutputTokens,
+		"cache_read_tokens", u.CacheReadTokens, "cache_creation_tokens", u.CacheCreationTokens,
+		"cost_usd", cost)
+}
+
+// logAccess writes the request's access log entry.
+func (p *Proxy) logAccess(r *http.Request, ex *exchange, w *responseWriter) {
+	if p.accessLog == nil {
+		return
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	entry := accesslog.Entry{
+		Time:              ex.start,
+		RequestID:         ex.requestID,
+		UpstreamRequestID: ex.upstreamRequestID,
+		SandboxID:         ex.sandboxID,
+		Provider:          ex.provider,
+		Method:            r.Method,
+		Path:              r.URL.Path,
+		Status:            status,
+		UpstreamStatus:    ex.upstreamStatus,
+		Attempts:          ex.attempts,
+		LatencyMS:         milliseconds(time.Since(ex.start)),
+		TTFBMS:            milliseconds(ex.ttfb),
+		BytesIn:           ex.bytesIn.Load(),
+		BytesOut:          w.bytes,
+		Model:             ex.model,
+	}
+	if ex.model != "" {
+		u := ex.usage
+		entry.Usage = &u
+	}
+	if ex.upstreamErr != nil {
+		entry.Error = ex.upstreamErr.Error()
 	}
+	if err := p.accessLog.Write(entry); err != nil {
+		ex.logger.Error("error writing access log", logging.Err(err))
+	}
+}
+
+// milliseconds converts d to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// requestID returns the sandbox's X-Request-ID if it is usable, or mints
+// a new one. Incoming IDs are limited to a conservative character set so
+// they are safe to echo in headers and logs.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// upstreamRequestID returns the provider's own request ID from a response.
+// Anthropic sends request-id; OpenAI and most others send x-request-id.
+func upstreamRequestID(h http.Header) string {
+	if id := h.Get("request-id"); id != "" {
+		return id
+	}
+	return h.Get(RequestIDHeader)
 }
 
 // extractToken extracts the session token from the request's auth headers.
@@ -118,13 +799,31 @@ func extractToken(r *http.Request) string {
 		return apiKey
 	}
 
+	// Check api-key header (Azure OpenAI style).
+	if apiKey := r.Header.Get("api-key"); apiKey != "" {
+		return apiKey
+	}
+
+	// Check x-goog-api-key header and key query parameter (Gemini style).
+	if apiKey := r.Header.Get("x-goog-api-key"); apiKey != "" {
+		return apiKey
+	}
+	if apiKey := r.URL.Query().Get("key"); apiKey != "" {
+		return apiKey
+	}
+
 	return ""
 }
 
 // lookupSession performs token lookup with compatibility for either token
-// format ("session-<hex>" and "<hex>").
+// format ("session-<hex>" and "<hex>"). Each candidate is hashed before it
+// reaches the store. Signed tokens are verified instead of looked up.
 func (p *Proxy) lookupSession(token string) (*session.Session, error) {
-	sess, err := p.store.Lookup(token)
+	if p.verifier != nil && signedtoken.LooksSigned(token) {
+		return p.verifySignedToken(token)
+	}
+
+	sess, err := p.store.Lookup(p.hasher.Hash(token))
 	if err == nil {
 		return sess, nil
 	}
@@ -139,7 +838,243 @@ func (p *Proxy) lookupSession(token string) (*session.Session, error) {
 	if alternate == token || alternate == "" {
 		return nil, err
 	}
-	return p.store.Lookup(alternate)
+	return p.store.Lookup(p.hasher.Hash(alternate))
+}
+
+// signedSessionPrefix marks the token of a session made from a signed
+// token's claims.
+const signedSessionPrefix = "jti:"
+
+// verifySignedToken checks a stateless token and turns its claims into an
+// ephemeral session that references a catalog credential.
+func (p *Proxy) verifySignedToken(token string) (*session.Session, error) {
+	claims, err := p.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return &session.Session{
+		Token:        signedSessionPrefix + claims.ID,
+		Provider:     claims.Provider,
+		SandboxID:    claims.SandboxID,
+		CredentialID: claims.KeyRef,
+		CreatedAt:    time.Unix(claims.IssuedAt, 0),
+		ExpiresAt:    time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// ForgetSandbox deletes the metric series of a sandbox, for example once
+// its sessions are revoked.
+func (p *Proxy) ForgetSandbox(sandboxID string) {
+	p.metrics.forget(sandboxID)
+}
+
+// ForgetEndedSandboxes deletes the metric series of every sandbox that no
+// live session or unexpired signed token can act for any more, and
+// returns how many sandboxes were forgotten. Sandbox IDs are unbounded, so
+// this should run periodically, such as after each reaper pass.
+func (p *Proxy) ForgetEndedSandboxes() int {
+	live := make(map[string]bool)
+	for _, s := range p.store.List() {
+		live[s.SandboxID] = true
+	}
+	return p.metrics.forgetEnded(live, time.Now())
+}
+
+// limitScopes returns the rate limit keys a request for sess counts
+// against: the session itself and, if set, its sandbox.
+func limitScopes(sess *session.Session) []ratelimit.Scope {
+	var scopes []ratelimit.Scope
+	if sess.Limits != nil {
+		scopes = append(scopes, ratelimit.Scope{Key: "session:" + sess.Token, Limits: *sess.Limits})
+	}
+	if sess.SandboxLimits != nil && sess.SandboxID != "" {
+		scopes = append(scopes, ratelimit.Scope{Key: "sandbox:" + sess.SandboxID, Limits: *sess.SandboxLimits})
+	}
+	return scopes
+}
+
+// budgetScopes returns the budget accounts a request for sess is charged
+// to: the session itself and, if set, its sandbox.
+func budgetScopes(sess *session.Session) []budget.Scope {
+	var scopes []budget.Scope
+	if sess.Budget != nil {
+		scopes = append(scopes, budget.Scope{
+			Key:       budget.SessionKey(usage.SessionID(sess.Token)),
+			SandboxID: sess.SandboxID,
+			Limits:    *sess.Budget,
+		})
+	}
+	if sess.SandboxBudget != nil && sess.SandboxID != "" {
+		scopes = append(scopes, budget.Scope{
+			Key:       budget.SandboxKey(sess.SandboxID),
+			SandboxID: sess.SandboxID,
+			Limits:    *sess.SandboxBudget,
+		})
+	}
+	return scopes
+}
+
+// budgeted reports whether any of scopes caps spend.
+func budgeted(scopes []budget.Scope) bool {
+	for _, s := range scopes {
+		if !s.Limits.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// dollarBudget reports whether any of scopes caps spend in dollars.
+func dollarBudget(scopes []budget.Scope) bool {
+	for _, s := range scopes {
+		if s.Limits.MaxCostUSD > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// requestModel returns the model a request names: the model field of a
+// JSON body, or else the model of a Gemini-style
+// .../models/{model}:{method} path. It returns "" if neither is present.
+func requestModel(path string, body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &req) == nil && req.Model != "" {
+		return req.Model
+	}
+	if i := strings.LastIndex(path, "/models/"); i >= 0 {
+		if model, _, ok := strings.Cut(path[i+len("/models/"):], ":"); ok {
+			return model
+		}
+	}
+	return ""
+}
+
+// defaultOutputEstimate is the output charged for a request that reports
+// no usage and sets no output cap of its own.
+const defaultOutputEstimate = 4096
+
+// estimateUsage returns what a request is charged when its response reports
+// no usage: one input token per four bytes of body, and its output cap
+// (max_tokens, max_completion_tokens, max_output_tokens, Gemini's
+// generationConfig.maxOutputTokens or Ollama's options.num_predict) as
+// output, or defaultOutputEstimate if it sets none.
+func estimateUsage(body []byte) usage.Usage {
+	var req struct {
+		MaxTokens           int64 `json:"max_tokens"`
+		MaxCompletionTokens int64 `json:"max_completion_tokens"`
+		MaxOutputTokens     int64 `json:"max_output_tokens"`
+		GenerationConfig    struct {
+			MaxOutputTokens int64 `json:"maxOutputTokens"`
+		} `json:"generationConfig"`
+		Options struct {
+			NumPredict int64 `json:"num_predict"`
+		} `json:"options"`
+	}
+	_ = json.Unmarshal(body, &req)
+	output := max(req.MaxTokens, req.MaxCompletionTokens, req.MaxOutputTokens,
+		req.GenerationConfig.MaxOutputTokens, req.Options.NumPredict)
+	if output <= 0 {
+		output = defaultOutputEstimate
+	}
+	return usage.Usage{InputTokens: (int64(len(body)) + 3) / 4, OutputTokens: output}
+}
+
+// countsTokens reports whether path is a token counting endpoint, which
+// uses no tokens and reports none.
+func countsTokens(path string) bool {
+	return strings.HasSuffix(path, "/count_tokens") || strings.HasSuffix(path, ":countTokens")
+}
+
+// includeStreamUsage asks an OpenAI-style streaming completion to end with
+// a usage chunk, which OpenAI only sends if the client sets
+// stream_options.include_usage. It returns the rewritten body, or false if
+// the request is not such a stream or already asks for usage.
+func includeStreamUsage(path string, body []byte) ([]byte, bool) {
+	if !strings.HasSuffix(path, "/completions") {
+		return nil, false
+	}
+	var req map[string]json.RawMessage
+	var stream bool
+	if json.Unmarshal(body, &req) != nil || json.Unmarshal(req["stream"], &stream) != nil || !stream {
+		return nil, false
+	}
+	opts := map[string]json.RawMessage{}
+	if raw, ok := req["stream_options"]; ok && string(raw) != "null" {
+		if json.Unmarshal(raw, &opts) != nil {
+			return nil, false
+		}
+	}
+	if string(opts["include_usage"]) == "true" {
+		return nil, false
+	}
+	opts["include_usage"] = json.RawMessage("true")
+	var err error
+	if req["stream_options"], err = json.Marshal(opts); err != nil {
+		return nil, false
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// apiKey returns the session's real API key, decrypting it if the store
+// keeps keys sealed. For catalog credentials a key is checked out of the
+// credential's pool and the returned lease must be released with the
+// upstream outcome; the lease is nil otherwise (Release on nil is a no-op).
+func (p *Proxy) apiKey(sess *session.Session) (string, *credential.Lease, error) {
+	if sess.CredentialID != "" {
+		if p.catalog == nil {
+			return "", nil, fmt.Errorf("session references credential %s but no catalog is configured", sess.CredentialID)
+		}
+		cred, err := p.catalog.Get(sess.CredentialID)
+		if err != nil {
+			return "", nil, err
+		}
+		if cred.Provider != sess.Provider {
+			return "", nil, fmt.Errorf("credential %s is for provider %s, not %s", cred.ID, cred.Provider, sess.Provider)
+		}
+		lease, err := p.catalog.Acquire(sess.CredentialID)
+		if err != nil {
+			return "", nil, err
+		}
+		return lease.Key, lease, nil
+	}
+	if sess.SealedAPIKey == nil {
+		return sess.APIKey, nil, nil
+	}
+	opener, ok := p.store.(session.KeyOpener)
+	if !ok {
+		return "", nil, fmt.Errorf("session key is sealed but the store cannot open it")
+	}
+	key, err := opener.OpenAPIKey(sess)
+	return key, nil, err
+}
+
+// retryAfter parses the retry-after-ms header some providers send, or a
+// Retry-After header given in seconds or as an HTTP date. Returns zero if
+// absent or unparseable.
+func retryAfter(h http.Header) time.Duration {
+	if ms, err := strconv.Atoi(h.Get("Retry-After-Ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	value := h.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
 }
 
 // copyHeaders copies HTTP headers, excluding hop-by-hop headers that
@@ -157,6 +1092,17 @@ func copyHeaders(dst, src http.Header) {
 	}
 }
 
+// streamContentType returns the Content-Type to parse resp as. A stream
+// whose Content-Type doesn't say how it is framed is taken to use the
+// provider's stream format.
+func streamContentType(resp *http.Response, prov Provider, streaming bool) string {
+	ct := resp.Header.Get("Content-Type")
+	if streaming && !strings.HasPrefix(ct, StreamSSE) && !strings.Contains(ct, StreamNDJSON) {
+		return prov.StreamFormat()
+	}
+	return ct
+}
+
 // isStreamingResponse checks if the upstream response is a streaming
 // response that should be flushed incrementally.
 func isStreamingResponse(resp *http.Response) bool {
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy controls how failed upstream requests are retried. Retries
+// only happen before anything has been written to the sandbox: once
+// response headers or stream bytes have been relayed, a failure is passed
+// on as is.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of upstream requests sent for one
+	// sandbox request, including the first and any failovers. The first
+	// pass over a failover list is always completed, even if it is longer.
+	MaxAttempts int
+
+	// Statuses are the upstream statuses worth retrying. Transport errors
+	// are retried only when no connection was made, so the upstream
+	// provably never saw the request.
+	Statuses []int
+
+	// BaseDelay is the backoff before the second attempt; it doubles for
+	// each attempt after that, up to MaxDelay, with jitter.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the wait between attempts. An upstream retry-after
+	// longer than this is returned to the sandbox instead of waited out.
+	MaxDelay time.Duration
+
+	// MaxBodySize is the largest request body buffered for resending.
+	// Requests with larger bodies are sent once.
+	MaxBodySize int64
+}
+
+// DefaultRetryPolicy retries rate limits (429), unavailability (503) and
+// Anthropic overload (529) up to three attempts.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		Statuses:    []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, 529},
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    20 * time.Second,
+		MaxBodySize: 4 << 20,
+	}
+}
+
+// Validate checks that the policy is usable.
+func (rp RetryPolicy) Validate() error {
+	if rp.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1")
+	}
+	if rp.BaseDelay <= 0 || rp.MaxDelay < rp.BaseDelay {
+		return fmt.Errorf("base delay must be positive and no more than max delay")
+	}
+	if rp.MaxBodySize < 0 {
+		return fmt.Errorf("max body size must not be negative")
+	}
+	return nil
+}
+
+func (rp *RetryPolicy) retryableStatus(status int) bool {
+	for _, s := range rp.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait after a failed attempt (counting from 1).
+// A positive hint from the upstream is used as is; otherwise the backoff
+// is exponential with jitter over its upper half. ok is false if the wait
+// would exceed MaxDelay.
+func (rp *RetryPolicy) delay(attempt int, hint time.Duration) (time.Duration, bool) {
+	if hint > 0 {
+		return hint, hint <= rp.MaxDelay
+	}
+	d := rp.BaseDelay << (attempt - 1)
+	if d <= 0 || d > rp.MaxDelay {
+		d = rp.MaxDelay
+	}
+	half := d / 2
+	if half <= 0 {
+		return d, true
+	}
+	return half + rand.N(half), true
+}
+
+// requestBody supplies the upstream request body for each attempt. Bodies
+// up to the resend limit are buffered so they can be sent again; anything
+// else is streamed through once.
+type requestBody struct {
+	buffered []byte
+	stream   io.Reader
+	empty    bool
+}
+
+// newRequestBody reads body up front if it is no larger than limit, so it
+// can be sent more than once. A limit of zero streams it through once.
+func newRequestBody(body io.ReadCloser, limit int64) (*requestBody, error) {
+	if body == nil || body == http.NoBody {
+		return &requestBody{empty: true}, nil
+	}
+	if limit <= 0 {
+		return &requestBody{stream: body}, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > limit {
+		return &requestBody{stream: io.MultiReader(bytes.NewReader(buf), body)}, nil
+	}
+	return &requestBody{buffered: buf}, nil
+}
+
+// replayable reports whether the body can be sent more than once.
+func (b *requestBody) replayable() bool {
+	return b.stream == nil
+}
+
+// reader returns the body for the next attempt. A buffered body is given
+// as a bytes.Reader so it is sent with a Content-Length.
+func (b *requestBody) reader() io.Reader {
+	switch {
+	case b.empty:
+		return http.NoBody
+	case b.stream != nil:
+		return b.stream
+	default:
+		return bytes.NewReader(b.buffered)
+	}
+}
+
+// traceConnection returns req with a trace that records whether a
+// connection to the upstream was ever established. Until one is, the
+// request cannot have reached the upstream: the dial failed or was
+// refused, or the TLS handshake did not complete. Any later error may
+// come after the upstream acted on the request, and resending it could
+// run a completion twice.
+func traceConnection(req *http.Request) (*http.Request, *atomic.Bool) {
+	connected := new(atomic.Bool)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) { connected.Store(true) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), connected
+}
+
+// sleepCtx waits for d or until ctx is done, reporting whether the full
+// wait elapsed.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
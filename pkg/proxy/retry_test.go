@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"llm-proxy/pkg/metrics"
+	"llm-proxy/pkg/session"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	rp := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		name     string
+		attempt  int
+		hint     time.Duration
+		min, max time.Duration
+		wantOK   bool
+	}{
+		{"first backoff", 1, 0, 50 * time.Millisecond, 100 * time.Millisecond, true},
+		{"doubles", 3, 0, 200 * time.Millisecond, 400 * time.Millisecond, true},
+		{"capped", 10, 0, 500 * time.Millisecond, time.Second, true},
+		{"hint honoured", 1, 700 * time.Millisecond, 700 * time.Millisecond, 700 * time.Millisecond, true},
+		{"hint over max", 1, 5 * time.Second, 5 * time.Second, 5 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 50 {
+				d, ok := rp.delay(tt.attempt, tt.hint)
+				if ok != tt.wantOK || d < tt.min || d > tt.max {
+					t.Fatalf("delay(%d, %v) = %v, %v; want [%v, %v], %v", tt.attempt, tt.hint, d, ok, tt.min, tt.max, tt.wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	if err := DefaultRetryPolicy().Validate(); err != nil {
+		t.Fatalf("default policy: %v", err)
+	}
+	bad := DefaultRetryPolicy()
+	bad.MaxDelay = bad.BaseDelay / 2
+	if err := bad.Validate(); err == nil {
+		t.Fatal("expected error for max delay below base delay")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"seconds", http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{"milliseconds win", http.Header{"Retry-After": {"3"}, "Retry-After-Ms": {"250"}}, 250 * time.Millisecond},
+		{"garbage", http.Header{"Retry-After": {"soon"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.header); got != tt.want {
+				t.Fatalf("retryAfter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRequestBody(t *testing.T) {
+	const limit = 8
+
+	small, err := newRequestBody(io.NopCloser(strings.NewReader("12345678")), limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !small.replayable() {
+		t.Fatal("body at the limit should be replayable")
+	}
+	for range 2 {
+		if data, _ := io.ReadAll(small.reader()); string(data) != "12345678" {
+			t.Fatalf("body = %q", data)
+		}
+	}
+
+	large, err := newRequestBody(io.NopCloser(strings.NewReader("123456789")), limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if large.replayable() {
+		t.Fatal("body over the limit should not be replayable")
+	}
+	if data, _ := io.ReadAll(large.reader()); string(data) != "123456789" {
+		t.Fatalf("body = %q", data)
+	}
+
+	if b, _ := newRequestBody(http.NoBody, limit); b.reader() != http.NoBody {
+		t.Fatal("empty body should stay empty")
+	}
+}
+
+// retryProxy returns a proxy retrying fast against upstreamURL.
+func retryProxy(t *testing.T, upstreamURL string, maxBody int64) (*Proxy, *metrics.Registry) {
+	t.Helper()
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{
+		Token:       "session-tok",
+		Provider:    ProviderAnthropic,
+		APIKey:      "sk-real",
+		UpstreamURL: upstreamURL,
+		SandboxID:   "sb",
+	})
+	reg := metrics.NewRegistry()
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = 50 * time.Millisecond
+	policy.MaxBodySize = maxBody
+	return New(store, slog.New(slog.DiscardHandler), WithRetries(policy), WithMetrics(reg)), reg
+}
+
+func TestServeHTTP_RetriesRetryableStatus(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"n":1}` {
+			t.Errorf("attempt %d body = %q", calls.Load()+1, body)
+		}
+		if calls.Add(1) < 3 {
+			w.Header().Set("retry-after-ms", "1")
+			http.Error(w, `{"error":"overloaded"}`, 529)
+			return
+		}
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer upstream.Close()
+
+	p, reg := retryProxy(t, upstream.URL, 1<<20)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"n":1}`))
+	req.Header.Set("x-api-key", "session-tok")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"ok":true}` {
+		t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("upstream calls = %d, want 3", calls.Load())
+	}
+	var buf bytes.Buffer
+	reg.WriteText(&buf)
+	if want := `llmproxy_upstream_retries_total{provider="anthropic",sandbox="sb",reason="529"} 2`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("metrics missing %s:\n%s", want, buf.String())
+	}
+}
+
+func TestServeHTTP_RetryLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		maxBody    int64
+		retryAfter string
+		wantCalls  int32
+	}{
+		{"gives up after max attempts", `{}`, 1 << 20, "", 3},
+		{"body too large to resend", `{"long":"body"}`, 4, "", 1},
+		{"retry-after beyond max delay", `{}`, 1 << 20, "60", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				io.Copy(io.Discard, r.Body)
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			}))
+			defer upstream.Close()
+
+			p, _ := retryProxy(t, upstream.URL, tt.maxBody)
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(tt.body))
+			req.Header.Set("x-api-key", "session-tok")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want 503 relayed", rec.Code)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Fatalf("upstream calls = %d, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_RetriesConnectionError(t *testing.T) {
+	// Nothing listens on a closed server's address, so every dial is
+	// refused and the request never reaches an upstream.
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+
+	p, reg := retryProxy(t, refused.URL, 1<<20)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`))
+	req.Header.Set("x-api-key", "session-tok")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	var buf bytes.Buffer
+	reg.WriteText(&buf)
+	if want := `llmproxy_upstream_retries_total{provider="anthropic",sandbox="sb",reason="error"} 2`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("metrics missing %s:\n%s", want, buf.String())
+	}
+}
+
+func TestServeHTTP_NoRetryAfterRequestSent(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		// Drop the connection without answering: the upstream may have
+		// acted on the request, so it must not be sent again.
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer upstream.Close()
+
+	p, _ := retryProxy(t, upstream.URL, 1<<20)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`))
+	req.Header.Set("x-api-key", "session-tok")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway || calls.Load() != 1 {
+		t.Fatalf("status = %d after %d calls, want 502 after 1", rec.Code, calls.Load())
+	}
+}
+
+func TestServeHTTP_RetryAttemptsCountFailovers(t *testing.T) {
+	var calls atomic.Int32
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	primary := httptest.NewServer(failing)
+	defer primary.Close()
+	secondary := httptest.NewServer(failing)
+	defer secondary.Close()
+
+	store := session.NewMemoryStore()
+	store.Register(&session.Session{
+		Token:        "session-tok",
+		Provider:     ProviderAnthropic,
+		APIKey:       "sk-real",
+		UpstreamURLs: []string{primary.URL, secondary.URL},
+	})
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = 50 * time.Millisecond
+	p := New(store, slog.New(slog.DiscardHandler), WithRetries(policy))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`))
+	req.Header.Set("x-api-key", "session-tok")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	// Both upstreams on the first pass, then one more to reach three.
+	if rec.Code != http.StatusServiceUnavailable || calls.Load() != int32(policy.MaxAttempts) {
+		t.Fatalf("status = %d after %d calls, want 503 after %d", rec.Code, calls.Load(), policy.MaxAttempts)
+	}
+}
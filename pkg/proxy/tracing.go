@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"llm-proxy/pkg/trace"
+)
+
+// spanName names request spans. Paths carry Azure deployments and Bedrock
+// model IDs, so they go in the url.path attribute rather than the name,
+// which is completed with the provider once the session is known.
+const spanName = "proxy"
+
+// startSpan opens the request's server span, joining the sandbox's trace if
+// it sent a traceparent header.
+func (p *Proxy) startSpan(r *http.Request, ex *exchange) {
+	if p.tracer == nil {
+		return
+	}
+	parent, _ := trace.ParseTraceparent(r.Header.Get("traceparent"))
+	ex.span = p.tracer.Start(spanName, trace.KindServer, parent)
+	ex.span.SetAttribute("http.request.method", r.Method)
+	ex.span.SetAttribute("url.path", r.URL.Path)
+}
+
+// finishSpan annotates the span with what was learned about the request and
+// ends it.
+func (p *Proxy) finishSpan(ex *exchange, w *responseWriter) {
+	span := ex.span
+	if span == nil {
+		return
+	}
+	if ex.provider != "" {
+		span.SetName(spanName + " " + ex.provider)
+		span.SetAttribute("gen_ai.system", ex.provider)
+	}
+	if ex.sandboxID != "" {
+		span.SetAttribute("llmproxy.sandbox.id", ex.sandboxID)
+	}
+	span.SetAttribute("llmproxy.request_id", ex.requestID)
+	if ex.upstreamRequestID != "" {
+		span.SetAttribute("llmproxy.upstream_request_id", ex.upstreamRequestID)
+	}
+	if ex.attempts > 1 {
+		span.SetAttribute("llmproxy.attempts", ex.attempts)
+	}
+	if ex.model != "" {
+		span.SetAttribute("gen_ai.response.model", ex.model)
+		span.SetAttribute("gen_ai.usage.input_tokens", ex.usage.InputTokens)
+		span.SetAttribute("gen_ai.usage.output_tokens", ex.usage.OutputTokens)
+	}
+	span.SetAttribute("llmproxy.streaming", ex.streaming)
+	if ex.streaming {
+		span.SetAttribute("llmproxy.stream.duration_ms", milliseconds(ex.streamDuration))
+	}
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttribute("http.response.status_code", status)
+	switch {
+	case ex.upstreamErr != nil:
+		span.SetStatus(trace.StatusError, fmt.Sprintf("upstream request failed: %v", ex.upstreamErr))
+	case status >= 500:
+		span.SetStatus(trace.StatusError, http.StatusText(status))
+	}
+	span.Finish()
+}
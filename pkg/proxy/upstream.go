@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"llm-proxy/pkg/session"
+)
+
+// CheckUpstreams reports whether a failover list keeps every key with the
+// vendor it belongs to. The session's own key is only sent to the first
+// upstream's host, so each upstream on another host must have its own
+// credential in credentials, keyed by upstream URL. Credentials for URLs
+// that are not in the list are rejected as a likely typo.
+func CheckUpstreams(upstreams []string, credentials map[string]string) error {
+	for u := range credentials {
+		if !slices.Contains(upstreams, u) {
+			return fmt.Errorf("upstream_credentials names %s, which is not one of the upstreams", u)
+		}
+	}
+	if len(upstreams) < 2 {
+		return nil
+	}
+	primary, err := upstreamHost(upstreams[0])
+	if err != nil {
+		return err
+	}
+	for _, u := range upstreams[1:] {
+		host, err := upstreamHost(u)
+		if err != nil {
+			return err
+		}
+		if host != primary && credentials[u] == "" {
+			return fmt.Errorf("upstream %s is not on the same host as %s and needs a credential of its own", u, upstreams[0])
+		}
+	}
+	return nil
+}
+
+// upstreamHost returns the lower-cased host name of an upstream base URL.
+func upstreamHost(upstream string) (string, error) {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("upstream %s is not an absolute URL", upstream)
+	}
+	return strings.ToLower(u.Hostname()), nil
+}
+
+// upstreamSession returns sess as it applies to one upstream of its
+// failover list, whose first entry is primary: carrying the upstream's own
+// credential if it has one. The session's key is never sent off the
+// primary's host; CheckUpstreams rejects such lists up front, and this
+// keeps sessions stored before then from doing so.
+func upstreamSession(sess *session.Session, primary, upstream string) (*session.Session, error) {
+	if id := sess.UpstreamCredentials[upstream]; id != "" {
+		s := *sess
+		s.CredentialID, s.APIKey, s.SealedAPIKey = id, "", nil
+		return &s, nil
+	}
+	if upstream == primary {
+		return sess, nil
+	}
+	host, err := upstreamHost(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if primaryHost, err := upstreamHost(primary); err != nil || host != primaryHost {
+		return nil, fmt.Errorf("upstream %s has no credential of its own", upstream)
+	}
+	return sess, nil
+}
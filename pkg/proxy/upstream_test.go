@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"llm-proxy/pkg/credential"
+	"llm-proxy/pkg/session"
+)
+
+func TestCheckUpstreams(t *testing.T) {
+	tests := []struct {
+		name        string
+		upstreams   []string
+		credentials map[string]string
+		wantErr     bool
+	}{
+		{"single upstream", []string{"https://api.openai.com"}, nil, false},
+		{"same host", []string{"https://gw.example.com/a", "https://GW.example.com:8443/b"}, nil, false},
+		{"other host without credential", []string{"https://api.openai.com", "https://openrouter.ai/api"}, nil, true},
+		{"other host with credential", []string{"https://api.openai.com", "https://openrouter.ai/api"},
+			map[string]string{"https://openrouter.ai/api": "openrouter"}, false},
+		{"credential for unknown upstream", []string{"https://api.openai.com"},
+			map[string]string{"https://openrouter.ai/api": "openrouter"}, true},
+		{"relative upstream", []string{"https://api.openai.com", "/v1"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckUpstreams(tt.upstreams, tt.credentials)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckUpstreams() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_FailoverUsesUpstreamCredential(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	var gotKey atomic.Value
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey.Store(r.Header.Get("Authorization"))
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer secondary.Close()
+	// Same listener, different host name: another vendor as far as keys
+	// are concerned.
+	secondaryURL := strings.Replace(secondary.URL, "127.0.0.1", "localhost", 1)
+
+	catalog := credential.NewMemoryCatalog()
+	_ = catalog.Put(&credential.Credential{ID: "secondary", Provider: ProviderOpenAI, APIKey: "sk-secondary"})
+
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		wantStatus  int
+		wantKey     string
+	}{
+		{"upstream credential", map[string]string{secondaryURL: "secondary"}, http.StatusOK, "Bearer sk-secondary"},
+		{"no credential for other host", nil, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotKey.Store("")
+			store := session.NewMemoryStore()
+			store.Register(&session.Session{
+				Token:               "session-tok",
+				Provider:            ProviderOpenAI,
+				APIKey:              "sk-primary",
+				UpstreamURLs:        []string{primary.URL, secondaryURL},
+				UpstreamCredentials: tt.credentials,
+			})
+			p := New(store, slog.New(slog.DiscardHandler), WithCatalog(catalog))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+			req.Header.Set("Authorization", "Bearer session-tok")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := gotKey.Load(); got != tt.wantKey {
+				t.Fatalf("secondary got Authorization %q, want %q", got, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"llm-proxy/pkg/trace"
+	"llm-proxy/pkg/usage"
+)
+
+// responseWriter records the status and body size sent to the sandbox.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses flowing through the wrapper.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// firstByteReader calls onFirst the first time data is read.
+type firstByteReader struct {
+	r       io.Reader
+	onFirst func()
+	seen    bool
+}
+
+func (f *firstByteReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 && !f.seen {
+		f.seen = true
+		f.onFirst()
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read through it into n. The transport
+// reads request bodies on its own goroutine, hence the atomic.
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// teeReadCloser pairs a wrapped reader with the original body's Close.
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// exchange is what is known about one proxied request as it is handled.
+// It is filled in along the way and reported once the response is done.
+type exchange struct {
+	requestID string
+	start     time.Time
+	provider  string
+	sandboxID string
+
+	// bytesIn counts the request body forwarded upstream.
+	bytesIn atomic.Int64
+
+	// upstreamStatus is the upstream response status, zero if none was
+	// received. ttfb is the time from start to the first body byte.
+	upstreamStatus int
+	ttfb           time.Duration
+
+	// attempts is how many times the request was sent upstream.
+	attempts int
+
+	// upstreamRequestID is the provider's own ID for the request.
+	upstreamRequestID string
+
+	// model and usage are set once a metered response has been relayed.
+	model string
+	usage usage.Usage
+
+	// streaming is set for SSE/NDJSON responses; streamDuration is how
+	// long the body took to relay.
+	streaming      bool
+	streamDuration time.Duration
+
+	// upstreamErr is the transport error if the upstream call failed.
+	upstreamErr error
+
+	// logger carries the request ID and, once known, the sandbox and
+	// provider.
+	logger *slog.Logger
+
+	// span is the request's trace span, nil when tracing is off.
+	span *trace.Span
+}
@@ -0,0 +1,193 @@
+// Package ratelimit enforces per-key request rates and concurrency caps
+// with token buckets and counting semaphores.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// concurrencyRetry is the retry hint given when a request is rejected for
+// exceeding a concurrency cap, since there is no way to know when an
+// in-flight request will finish.
+const concurrencyRetry = time.Second
+
+// pruneInterval is how often idle limiter entries are dropped.
+const pruneInterval = time.Minute
+
+// Limits caps the traffic allowed under one key. Zero fields are unlimited.
+type Limits struct {
+	// RequestsPerMinute is the sustained request rate.
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+
+	// Burst is how many requests may be made back to back before the rate
+	// applies. Defaults to RequestsPerMinute.
+	Burst int `json:"burst,omitempty"`
+
+	// MaxConcurrent caps requests in flight at once, including streaming
+	// responses still being relayed.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+// IsZero reports whether l imposes no limits at all.
+func (l Limits) IsZero() bool {
+	return l.RequestsPerMinute == 0 && l.MaxConcurrent == 0
+}
+
+// Validate checks that l is well formed.
+func (l Limits) Validate() error {
+	if l.RequestsPerMinute < 0 || l.Burst < 0 || l.MaxConcurrent < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if l.Burst > 0 && l.RequestsPerMinute == 0 {
+		return fmt.Errorf("burst requires requests_per_minute")
+	}
+	return nil
+}
+
+// capacity is the token bucket size.
+func (l Limits) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.RequestsPerMinute)
+}
+
+// perSecond is the token bucket refill rate.
+func (l Limits) perSecond() float64 {
+	return float64(l.RequestsPerMinute) / 60
+}
+
+// Scope is one key a request counts against, with the limits for that key.
+type Scope struct {
+	Key    string
+	Limits Limits
+}
+
+// entry is the live state for one key.
+type entry struct {
+	limits   Limits
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// refill tops up the bucket for the time elapsed since the last refill.
+func (e *entry) refill(now time.Time, limits Limits) {
+	if e.limits != limits {
+		// Limits changed, e.g. the session was re-registered. Keep the
+		// spent tokens but clamp to the new capacity.
+		e.limits = limits
+		e.tokens = math.Min(e.tokens, limits.capacity())
+	}
+	if elapsed := now.Sub(e.last).Seconds(); elapsed > 0 {
+		e.tokens = math.Min(limits.capacity(), e.tokens+elapsed*limits.perSecond())
+	}
+	e.last = now
+}
+
+// idle reports whether the entry holds no state worth keeping.
+func (e *entry) idle() bool {
+	return e.inFlight == 0 && e.tokens >= e.limits.capacity()
+}
+
+// Limiter tracks request rates and concurrency for any number of keys.
+type Limiter struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastPrune time.Time
+
+	// now is the clock used for refills. Overridden in tests.
+	now func() time.Time
+}
+
+// New creates an empty Limiter.
+func New() *Limiter {
+	return &Limiter{
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Acquire admits one request against every scope at once, or none of
+// them. On success the returned release func must be called when the
+// request finishes; it is safe to call more than once. On rejection ok is
+// false and retryAfter says how long to wait before trying again.
+func (l *Limiter) Acquire(scopes ...Scope) (release func(), retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastPrune) >= pruneInterval {
+		l.prune(now)
+	}
+
+	denied := false
+	admitted := make([]*entry, 0, len(scopes))
+	for _, sc := range scopes {
+		if sc.Key == "" || sc.Limits.IsZero() {
+			continue
+		}
+		e, found := l.entries[sc.Key]
+		if !found {
+			e = &entry{limits: sc.Limits, tokens: sc.Limits.capacity(), last: now}
+			l.entries[sc.Key] = e
+		}
+		e.refill(now, sc.Limits)
+
+		if limit := sc.Limits.MaxConcurrent; limit > 0 && e.inFlight >= limit {
+			retryAfter = max(retryAfter, concurrencyRetry)
+			denied = true
+			continue
+		}
+		if sc.Limits.RequestsPerMinute > 0 && e.tokens < 1 {
+			wait := time.Duration((1 - e.tokens) / sc.Limits.perSecond() * float64(time.Second))
+			retryAfter = max(retryAfter, wait)
+			denied = true
+			continue
+		}
+		admitted = append(admitted, e)
+	}
+	if denied {
+		return nil, retryAfter, false
+	}
+
+	for _, e := range admitted {
+		e.inFlight++
+		if e.limits.RequestsPerMinute > 0 {
+			e.tokens--
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, e := range admitted {
+				e.inFlight--
+			}
+		})
+	}, 0, true
+}
+
+// Len returns the number of keys with live state.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// prune drops entries that are idle with a full bucket; they would be
+// recreated identically. Callers must hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		e.refill(now, e.limits)
+		if e.idle() {
+			delete(l.entries, key)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLimiter() (*Limiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimits_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr bool
+	}{
+		{name: "empty", limits: Limits{}},
+		{name: "rate and burst", limits: Limits{RequestsPerMinute: 60, Burst: 5}},
+		{name: "concurrency only", limits: Limits{MaxConcurrent: 2}},
+		{name: "negative", limits: Limits{RequestsPerMinute: -1}, wantErr: true},
+		{name: "burst without rate", limits: Limits{Burst: 5}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limits.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimiter_TokenBucket(t *testing.T) {
+	l, now := newTestLimiter()
+	scope := Scope{Key: "s", Limits: Limits{RequestsPerMinute: 60, Burst: 2}}
+
+	for i := range 2 {
+		release, _, ok := l.Acquire(scope)
+		if !ok {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+		release()
+	}
+
+	_, retryAfter, ok := l.Acquire(scope)
+	if ok {
+		t.Fatal("request beyond burst admitted")
+	}
+	if retryAfter != time.Second {
+		t.Fatalf("retryAfter = %v, want 1s at 60 rpm", retryAfter)
+	}
+
+	*now = now.Add(time.Second)
+	if _, _, ok := l.Acquire(scope); !ok {
+		t.Fatal("request rejected after refill")
+	}
+}
+
+func TestLimiter_Concurrency(t *testing.T) {
+	l, _ := newTestLimiter()
+	scope := Scope{Key: "s", Limits: Limits{MaxConcurrent: 1}}
+
+	release, _, ok := l.Acquire(scope)
+	if !ok {
+		t.Fatal("first request rejected")
+	}
+	if _, retryAfter, ok := l.Acquire(scope); ok || retryAfter != concurrencyRetry {
+		t.Fatalf("second concurrent request: ok=%v retryAfter=%v", ok, retryAfter)
+	}
+
+	release()
+	release() // idempotent
+	if _, _, ok := l.Acquire(scope); !ok {
+		t.Fatal("request rejected after release")
+	}
+	if _, _, ok := l.Acquire(scope); ok {
+		t.Fatal("double release freed more than one slot")
+	}
+}
+
+func TestLimiter_AllOrNothing(t *testing.T) {
+	l, _ := newTestLimiter()
+	session := Scope{Key: "session:a", Limits: Limits{RequestsPerMinute: 60, Burst: 5}}
+	sandbox := Scope{Key: "sandbox:x", Limits: Limits{MaxConcurrent: 1}}
+
+	release, _, ok := l.Acquire(session, sandbox)
+	if !ok {
+		t.Fatal("first request rejected")
+	}
+	// Rejected by the sandbox cap: the session bucket must not be charged.
+	for range 3 {
+		if _, _, ok := l.Acquire(session, sandbox); ok {
+			t.Fatal("request over sandbox cap admitted")
+		}
+	}
+	release()
+
+	for i := range 4 {
+		release, _, ok := l.Acquire(session, sandbox)
+		if !ok {
+			t.Fatalf("request %d rejected; session bucket was charged for rejected requests", i)
+		}
+		release()
+	}
+}
+
+func TestLimiter_UnlimitedScopesIgnored(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	for range 100 {
+		release, _, ok := l.Acquire(Scope{Key: "s"}, Scope{Limits: Limits{MaxConcurrent: 1}})
+		if !ok {
+			t.Fatal("unlimited scope rejected a request")
+		}
+		release()
+	}
+	if l.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0 for unlimited scopes", l.Len())
+	}
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	l, now := newTestLimiter()
+
+	release, _, _ := l.Acquire(Scope{Key: "busy", Limits: Limits{MaxConcurrent: 1}})
+	r, _, _ := l.Acquire(Scope{Key: "idle", Limits: Limits{RequestsPerMinute: 60}})
+	r()
+
+	*now = now.Add(2 * pruneInterval)
+	l.Acquire()
+	if l.Len() != 1 {
+		t.Fatalf("Len() after prune = %d, want 1", l.Len())
+	}
+	release()
+}
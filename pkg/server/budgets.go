@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"llm-proxy/pkg/logging"
+)
+
+// topUpRequest is the JSON body for POST /v1/sandboxes/{id}/budget.
+type topUpRequest struct {
+	// AddTokens and AddCostUSD raise the allowance of the sandbox budget
+	// and of every session budget in the sandbox.
+	AddTokens  int64   `json:"add_tokens,omitempty"`
+	AddCostUSD float64 `json:"add_cost_usd,omitempty"`
+
+	// Reset clears spend and earlier top-ups before adding.
+	Reset bool `json:"reset,omitempty"`
+}
+
+func (s *Server) handleGetSandboxBudget(w http.ResponseWriter, r *http.Request) {
+	sandboxID := r.PathValue("id")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"sandbox_id": sandboxID,
+		"accounts":   s.budgets.SandboxStatus(sandboxID),
+	})
+}
+
+// handleTopUpSandboxBudget tops up or resets the budgets of a running
+// sandbox. Takes effect on the sandbox's next request.
+func (s *Server) handleTopUpSandboxBudget(w http.ResponseWriter, r *http.Request) {
+	sandboxID := r.PathValue("id")
+
+	var req topUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"invalid request: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if req.AddTokens < 0 || req.AddCostUSD < 0 {
+		http.Error(w, `{"error":"top-up amounts must not be negative"}`, http.StatusBadRequest)
+		return
+	}
+	if req.AddTokens == 0 && req.AddCostUSD == 0 && !req.Reset {
+		http.Error(w, `{"error":"add_tokens, add_cost_usd or reset is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	accounts := s.budgets.TopUpSandbox(sandboxID, req.AddTokens, req.AddCostUSD, req.Reset)
+
+	s.logger.Info("budget top-up", logging.KeySandboxID, sandboxID,
+		"add_tokens", req.AddTokens, "add_cost_usd", req.AddCostUSD, "reset", req.Reset)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     "updated",
+		"sandbox_id": sandboxID,
+		"accounts":   accounts,
+	})
+}
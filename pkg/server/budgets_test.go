@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm-proxy/pkg/budget"
+	"llm-proxy/pkg/session"
+)
+
+func TestSandboxBudgetExhaustionAndTopUp(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"type":"message","model":"claude-haiku-4-5","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, "secret-admin-token")
+	rec := adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]any{
+		"token": "session-a", "provider": "anthropic", "api_key": "sk-ant-real",
+		"upstream_url": upstream.URL, "sandbox_id": "sandbox-x",
+		"sandbox_budget": map[string]int{"max_tokens": 20},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	proxyCall := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("x-api-key", "session-a")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 15 tokens each: the second request starts under budget and overshoots.
+	for i := range 2 {
+		if rec := proxyCall(); rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+	rec = proxyCall()
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"invalid_request_error"`) {
+		t.Fatalf("exhausted response = %d %s, want Anthropic-shaped 400", rec.Code, rec.Body.String())
+	}
+
+	rec = adminRequest(t, srv, http.MethodGet, "/v1/sandboxes/sandbox-x/budget", nil)
+	var status struct {
+		Accounts []budget.Status `json:"accounts"`
+	}
+	json.NewDecoder(rec.Body).Decode(&status)
+	if len(status.Accounts) != 1 || status.Accounts[0].SpentTokens != 30 || *status.Accounts[0].RemainingTokens != 0 {
+		t.Fatalf("budget status = %+v", status.Accounts)
+	}
+
+	rec = adminRequest(t, srv, http.MethodPost, "/v1/sandboxes/sandbox-x/budget", map[string]any{"add_tokens": 100})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("top-up status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := proxyCall(); rec.Code != http.StatusOK {
+		t.Fatalf("status after top-up = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = adminRequest(t, srv, http.MethodPost, "/v1/sandboxes/sandbox-x/budget", map[string]any{})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("empty top-up status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDollarBudgetRequiresPriceTable(t *testing.T) {
+	body := map[string]any{
+		"token": "session-a", "provider": "openai", "api_key": "sk-real",
+		"budget": map[string]float64{"max_cost_usd": 5},
+	}
+
+	srv := newTestServer(t, "secret-admin-token")
+	if rec := adminRequest(t, srv, http.MethodPost, "/v1/sessions", body); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status without price table = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	srv = New(session.NewMemoryStore(), slog.New(slog.DiscardHandler), "secret-admin-token",
+		WithPriceTable(budget.PriceTable{"gpt-4o": {Input: 2.5, Output: 10}}))
+	if rec := adminRequest(t, srv, http.MethodPost, "/v1/sessions", body); rec.Code != http.StatusCreated {
+		t.Fatalf("status with price table = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+}
+
+func TestOpenAIDollarBudgetExhausted(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"gpt-4o","usage":{"prompt_tokens":1000000,"completion_tokens":0}}`)
+	}))
+	defer upstream.Close()
+
+	srv := New(session.NewMemoryStore(), slog.New(slog.DiscardHandler), "secret-admin-token",
+		WithPriceTable(budget.PriceTable{"gpt-4o": {Input: 2.5, Output: 10}}))
+	adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]any{
+		"token": "session-a", "provider": "openai", "api_key": "sk-real", "upstream_url": upstream.URL,
+		"budget": map[string]float64{"max_cost_usd": 2},
+	})
+
+	call := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Header.Set("Authorization", "Bearer session-a")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := call(); rec.Code != http.StatusOK {
+		t.Fatalf("first status = %d", rec.Code)
+	}
+	rec := call()
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), `"insufficient_quota"`) {
+		t.Fatalf("exhausted response = %d %s, want OpenAI insufficient_quota", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") != "" {
+		t.Fatal("exhausted budget should not invite a retry")
+	}
+}
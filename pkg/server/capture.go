@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"llm-proxy/pkg/logging"
+)
+
+// captureRequest is the JSON body for PUT /v1/sandboxes/{id}/capture.
+type captureRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+func (s *Server) handleGetSandboxCapture(w http.ResponseWriter, r *http.Request) {
+	sandboxID := r.PathValue("id")
+	if s.capture == nil {
+		http.Error(w, `{"error":"capture is not enabled on this proxy"}`, http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"sandbox_id": sandboxID,
+		"enabled":    s.capture.SandboxEnabled(sandboxID),
+	})
+}
+
+// handleSetSandboxCapture switches capture on or off for every session of a
+// sandbox, including ones registered later. Takes effect on the next
+// request.
+func (s *Server) handleSetSandboxCapture(w http.ResponseWriter, r *http.Request) {
+	sandboxID := r.PathValue("id")
+	if s.capture == nil {
+		http.Error(w, `{"error":"capture is not enabled on this proxy"}`, http.StatusNotImplemented)
+		return
+	}
+
+	var req captureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"invalid request: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, `{"error":"enabled is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	s.capture.SetSandbox(sandboxID, *req.Enabled)
+
+	s.logger.Info("set sandbox capture", logging.KeySandboxID, sandboxID, "enabled", *req.Enabled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     "updated",
+		"sandbox_id": sandboxID,
+		"enabled":    *req.Enabled,
+	})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm-proxy/pkg/capture"
+	"llm-proxy/pkg/session"
+)
+
+func TestSandboxCaptureToggle(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	rec, err := capture.NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(session.NewMemoryStore(), slog.New(slog.DiscardHandler), "secret-admin-token", WithCapture(rec))
+	if resp := adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]any{
+		"token": "session-a", "provider": "openai", "api_key": "sk-real",
+		"upstream_url": upstream.URL, "sandbox_id": "sandbox-x",
+	}); resp.Code != http.StatusCreated {
+		t.Fatalf("register status = %d: %s", resp.Code, resp.Body.String())
+	}
+
+	proxyCall := func() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer session-a")
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	captured := func() int {
+		exchanges, err := capture.Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(exchanges)
+	}
+
+	proxyCall()
+	if n := captured(); n != 0 {
+		t.Fatalf("captured %d exchanges before enabling", n)
+	}
+
+	resp := adminRequest(t, srv, http.MethodPut, "/v1/sandboxes/sandbox-x/capture", map[string]bool{"enabled": true})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("enable status = %d: %s", resp.Code, resp.Body.String())
+	}
+	proxyCall()
+	if n := captured(); n != 1 {
+		t.Fatalf("captured %d exchanges after enabling, want 1", n)
+	}
+
+	adminRequest(t, srv, http.MethodPut, "/v1/sandboxes/sandbox-x/capture", map[string]bool{"enabled": false})
+	proxyCall()
+	if n := captured(); n != 1 {
+		t.Fatalf("captured %d exchanges after disabling, want 1", n)
+	}
+
+	if resp := adminRequest(t, srv, http.MethodPut, "/v1/sandboxes/sandbox-x/capture", map[string]any{}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("missing enabled status = %d, want %d", resp.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCaptureRequiresRecorder(t *testing.T) {
+	srv := newTestServer(t, "secret-admin-token")
+
+	resp := adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]any{
+		"token": "session-a", "provider": "openai", "api_key": "sk-real", "capture": true,
+	})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("register with capture status = %d, want %d", resp.Code, http.StatusBadRequest)
+	}
+	resp = adminRequest(t, srv, http.MethodPut, "/v1/sandboxes/sandbox-x/capture", map[string]bool{"enabled": true})
+	if resp.Code != http.StatusNotImplemented {
+		t.Fatalf("toggle status = %d, want %d", resp.Code, http.StatusNotImplemented)
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"llm-proxy/pkg/credential"
+	"llm-proxy/pkg/logging"
+)
+
+// credentialRequest is the JSON body for POST /v1/credentials and
+// PUT /v1/credentials/{id}.
+type credentialRequest struct {
+	ID       string           `json:"id,omitempty"`
+	Provider string           `json:"provider,omitempty"`
+	APIKey   string           `json:"api_key,omitempty"`
+	Keys     []credential.Key `json:"keys,omitempty"`
+	Strategy string           `json:"strategy,omitempty"`
+}
+
+// credentialInfo is the JSON representation of a credential in responses.
+// API keys are never included.
+type credentialInfo struct {
+	ID        string    `json:"id"`
+	Provider  string    `json:"provider"`
+	Strategy  string    `json:"strategy,omitempty"`
+	KeyCount  int       `json:"key_count"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Sessions  int       `json:"sessions"`
+
+	// Keys holds per-key health and is only filled in for single
+	// credential lookups.
+	Keys []credential.KeyStats `json:"keys,omitempty"`
+}
+
+func newCredentialInfo(cred *credential.Credential, sessions int) credentialInfo {
+	keyCount := len(cred.Keys)
+	if keyCount == 0 {
+		keyCount = 1
+	}
+	return credentialInfo{
+		ID:        cred.ID,
+		Provider:  cred.Provider,
+		Strategy:  cred.Strategy,
+		KeyCount:  keyCount,
+		UpdatedAt: cred.UpdatedAt,
+		Sessions:  sessions,
+	}
+}
+
+func (s *Server) handleCreateCredential(w http.ResponseWriter, r *http.Request) {
+	var req credentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"invalid request: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" || req.Provider == "" || (req.APIKey == "" && len(req.Keys) == 0) {
+		http.Error(w, `{"error":"id, provider, and api_key or keys are required"}`, http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.providers.Lookup(req.Provider); !ok {
+		http.Error(w, fmt.Sprintf(`{"error":"unknown provider %q"}`, req.Provider), http.StatusBadRequest)
+		return
+	}
+	cred := &credential.Credential{
+		ID:       req.ID,
+		Provider: req.Provider,
+		APIKey:   req.APIKey,
+		Keys:     req.Keys,
+		Strategy: req.Strategy,
+	}
+	err := s.catalog.Create(cred)
+	if errors.Is(err, credential.ErrExists) {
+		http.Error(w, `{"error":"credential already exists"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"create failed: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Info("created credential", "credential_id", req.ID, logging.KeyProvider, req.Provider)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"status": "created", "id": req.ID})
+}
+
+// handleReplaceCredential swaps the key or key pool behind an existing
+// credential. Every session referencing it picks up the new keys on its
+// next request; keys present before and after keep their health state.
+func (s *Server) handleReplaceCredential(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var req credentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"invalid request: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if req.APIKey == "" && len(req.Keys) == 0 {
+		http.Error(w, `{"error":"api_key or keys is required"}`, http.StatusBadRequest)
+		return
+	}
+	if req.ID != "" && req.ID != id {
+		http.Error(w, `{"error":"id in body does not match path"}`, http.StatusBadRequest)
+		return
+	}
+
+	existing, err := s.catalog.Get(id)
+	if errors.Is(err, credential.ErrNotFound) {
+		http.Error(w, `{"error":"credential not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"lookup failed: %s"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if req.Provider != "" && req.Provider != existing.Provider {
+		http.Error(w, `{"error":"provider of an existing credential cannot be changed"}`, http.StatusBadRequest)
+		return
+	}
+	// A credential loaded from a file or the store may predate a change
+	// to the registered providers.
+	if _, ok := s.providers.Lookup(existing.Provider); !ok {
+		http.Error(w, fmt.Sprintf(`{"error":"unknown provider %q"}`, existing.Provider), http.StatusBadRequest)
+		return
+	}
+
+	existing.APIKey = req.APIKey
+	existing.Keys = req.Keys
+	if req.Strategy != "" {
+		existing.Strategy = req.Strategy
+	}
+	if err := s.catalog.Put(existing); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"replace failed: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Info("rotated credential", "credential_id", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated", "id": id})
+}
+
+// handleDeleteCredential removes a credential unless live sessions still
+// reference it.
+func (s *Server) handleDeleteCredential(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if inUse := s.credentialUsage()[id]; inUse > 0 {
+		http.Error(w, fmt.Sprintf(`{"error":"credential is referenced by %d sessions"}`, inUse), http.StatusConflict)
+		return
+	}
+	if err := s.catalog.Delete(id); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"delete failed: %s"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("deleted credential", "credential_id", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+func (s *Server) handleListCredentials(w http.ResponseWriter, _ *http.Request) {
+	creds := s.catalog.List()
+	usage := s.credentialUsage()
+
+	infos := make([]credentialInfo, len(creds))
+	for i, cred := range creds {
+		infos[i] = newCredentialInfo(cred, usage[cred.ID])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// handleGetCredential returns one credential with per-key in-flight,
+// request and error counts and any active ejection.
+func (s *Server) handleGetCredential(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	cred, err := s.catalog.Get(id)
+	if errors.Is(err, credential.ErrNotFound) {
+		http.Error(w, `{"error":"credential not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"lookup failed: %s"}`, err), http.StatusInternalServerError)
+		return
+	}
+	stats, err := s.catalog.Stats(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"lookup failed: %s"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	info := newCredentialInfo(cred, s.credentialUsage()[id])
+	info.Keys = stats
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// credentialUsage counts live registered sessions per credential ID.
+func (s *Server) credentialUsage() map[string]int {
+	usage := make(map[string]int)
+	for _, sess := range s.store.List() {
+		if sess.CredentialID != "" {
+			usage[sess.CredentialID]++
+		}
+		for _, id := range sess.UpstreamCredentials {
+			if id != sess.CredentialID {
+				usage[id]++
+			}
+		}
+	}
+	return usage
+}
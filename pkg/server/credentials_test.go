@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm-proxy/pkg/credential"
+)
+
+func TestCredentialRotationAffectsSessions(t *testing.T) {
+	var gotKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, "secret-admin-token")
+
+	rec := adminRequest(t, srv, http.MethodPost, "/v1/credentials", map[string]string{
+		"id": "anthropic-main", "provider": "anthropic", "api_key": "sk-ant-v1",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+
+	rec = adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]string{
+		"token": "session-a", "provider": "anthropic", "credential_id": "anthropic-main", "upstream_url": upstream.URL,
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+
+	proxyCall := func() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("x-api-key", "session-a")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("proxy status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+
+	proxyCall()
+	if gotKey != "sk-ant-v1" {
+		t.Fatalf("upstream key = %q, want sk-ant-v1", gotKey)
+	}
+
+	rec = adminRequest(t, srv, http.MethodPut, "/v1/credentials/anthropic-main", map[string]string{"api_key": "sk-ant-v2"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replace status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	proxyCall()
+	if gotKey != "sk-ant-v2" {
+		t.Fatalf("upstream key after rotation = %q, want sk-ant-v2", gotKey)
+	}
+
+	rec = adminRequest(t, srv, http.MethodGet, "/v1/credentials", nil)
+	if strings.Contains(rec.Body.String(), "sk-ant") {
+		t.Fatalf("credential list leaked a key: %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"sessions":1`) {
+		t.Fatalf("credential list should count referencing sessions: %s", rec.Body.String())
+	}
+
+	rec = adminRequest(t, srv, http.MethodDelete, "/v1/credentials/anthropic-main", nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("delete in-use status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestCredentialValidation(t *testing.T) {
+	srv := newTestServer(t, "secret-admin-token")
+	adminRequest(t, srv, http.MethodPost, "/v1/credentials", map[string]string{
+		"id": "openai-main", "provider": "openai", "api_key": "sk-openai",
+	})
+	// As if loaded from a credentials file for a provider since removed.
+	if err := srv.catalog.Put(&credential.Credential{ID: "stale", Provider: "retired", APIKey: "k"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]string
+		want   int
+	}{
+		{"duplicate create", http.MethodPost, "/v1/credentials", map[string]string{"id": "openai-main", "provider": "openai", "api_key": "k"}, http.StatusConflict},
+		{"create unknown provider", http.MethodPost, "/v1/credentials", map[string]string{"id": "x", "provider": "opneai", "api_key": "k"}, http.StatusBadRequest},
+		{"replace for unknown provider", http.MethodPut, "/v1/credentials/stale", map[string]string{"api_key": "k2"}, http.StatusBadRequest},
+		{"create missing key", http.MethodPost, "/v1/credentials", map[string]string{"id": "x", "provider": "openai"}, http.StatusBadRequest},
+		{"replace unknown", http.MethodPut, "/v1/credentials/missing", map[string]string{"api_key": "k"}, http.StatusNotFound},
+		{"replace changes provider", http.MethodPut, "/v1/credentials/openai-main", map[string]string{"provider": "anthropic", "api_key": "k"}, http.StatusBadRequest},
+		{"session with unknown credential", http.MethodPost, "/v1/sessions", map[string]string{"provider": "openai", "credential_id": "missing"}, http.StatusBadRequest},
+		{"session with mismatched provider", http.MethodPost, "/v1/sessions", map[string]string{"provider": "anthropic", "credential_id": "openai-main"}, http.StatusBadRequest},
+		{"session with key and credential", http.MethodPost, "/v1/sessions", map[string]string{"provider": "openai", "api_key": "k", "credential_id": "openai-main"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := adminRequest(t, srv, tt.method, tt.path, tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCredentialPoolEjectsRateLimitedKey(t *testing.T) {
+	var gotKeys []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-api-key")
+		gotKeys = append(gotKeys, key)
+		if key == "sk-ant-1" {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, "secret-admin-token")
+
+	rec := adminRequest(t, srv, http.MethodPost, "/v1/credentials", map[string]any{
+		"id": "anthropic-pool", "provider": "anthropic",
+		"keys": []map[string]string{{"value": "sk-ant-1"}, {"value": "sk-ant-2"}},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	rec = adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]string{
+		"token": "session-a", "provider": "anthropic", "credential_id": "anthropic-pool", "upstream_url": upstream.URL,
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+
+	for range 3 {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("x-api-key", "session-a")
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	want := []string{"sk-ant-1", "sk-ant-2", "sk-ant-2"}
+	if strings.Join(gotKeys, ",") != strings.Join(want, ",") {
+		t.Fatalf("upstream keys = %v, want %v", gotKeys, want)
+	}
+
+	rec = adminRequest(t, srv, http.MethodGet, "/v1/credentials/anthropic-pool", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "sk-ant") {
+		t.Fatalf("credential stats leaked a key: %s", body)
+	}
+	if !strings.Contains(body, `"ejected_until"`) || !strings.Contains(body, `"last_status":429`) {
+		t.Fatalf("stats missing ejection of rate-limited key: %s", body)
+	}
+
+	rec = adminRequest(t, srv, http.MethodGet, "/v1/credentials/missing", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing credential status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -5,44 +5,219 @@ package server
 import (
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
+	"llm-proxy/pkg/accesslog"
+	"llm-proxy/pkg/budget"
+	"llm-proxy/pkg/capture"
+	"llm-proxy/pkg/credential"
+	"llm-proxy/pkg/keyring"
+	"llm-proxy/pkg/logging"
+	"llm-proxy/pkg/metrics"
 	"llm-proxy/pkg/proxy"
+	"llm-proxy/pkg/ratelimit"
 	"llm-proxy/pkg/session"
+	"llm-proxy/pkg/signedtoken"
+	"llm-proxy/pkg/trace"
+	"llm-proxy/pkg/usage"
 )
 
 // Server is the HTTP server for the LLM proxy.
 type Server struct {
 	store      session.Store
+	hasher     *session.TokenHasher
+	verifier   *signedtoken.Verifier
+	catalog    credential.Catalog
+	providers  *proxy.ProviderRegistry
+	budgets    *budget.Tracker
+	prices     budget.PriceTable
+	usage      *usage.Ledger
+	capture    *capture.Recorder
+	metrics    *metrics.Registry
 	proxy      *proxy.Proxy
 	mux        *http.ServeMux
-	logger     *log.Logger
+	logger     *slog.Logger
 	adminToken string
+
+	// proxyOpts are passed through to proxy.New.
+	proxyOpts []proxy.Option
+}
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithTokenHasher stores session tokens as keyed hashes. The same hasher is
+// used by the registry endpoints and the proxy.
+func WithTokenHasher(h *session.TokenHasher) Option {
+	return func(s *Server) {
+		s.hasher = h
+		s.proxyOpts = append(s.proxyOpts, proxy.WithTokenHasher(h))
+	}
+}
+
+// WithSignedTokens lets sandboxes authenticate with stateless signed tokens
+// verified by v, and enables the token revocation endpoint.
+func WithSignedTokens(v *signedtoken.Verifier) Option {
+	return func(s *Server) {
+		s.verifier = v
+		s.proxyOpts = append(s.proxyOpts, proxy.WithSignedTokens(v))
+	}
+}
+
+// WithCatalog uses c as the credential catalog instead of an empty
+// in-memory one.
+func WithCatalog(c credential.Catalog) Option {
+	return func(s *Server) {
+		s.catalog = c
+	}
+}
+
+// WithUsage enables token usage accounting into l.
+func WithUsage(l *usage.Ledger) Option {
+	return func(s *Server) {
+		s.usage = l
+		s.proxyOpts = append(s.proxyOpts, proxy.WithUsage(l))
+	}
+}
+
+// WithPriceTable prices metered usage, enabling dollar budgets.
+func WithPriceTable(t budget.PriceTable) Option {
+	return func(s *Server) {
+		s.prices = t
+		s.proxyOpts = append(s.proxyOpts, proxy.WithPriceTable(t))
+	}
+}
+
+// WithAccessLog writes one entry per proxied request to l.
+func WithAccessLog(l *accesslog.Log) Option {
+	return func(s *Server) {
+		s.proxyOpts = append(s.proxyOpts, proxy.WithAccessLog(l))
+	}
+}
+
+// WithCapture enables request/response capture into rec for sessions
+// registered with capture and for sandboxes it is switched on for.
+func WithCapture(rec *capture.Recorder) Option {
+	return func(s *Server) {
+		s.capture = rec
+		s.proxyOpts = append(s.proxyOpts, proxy.WithCapture(rec))
+	}
+}
+
+// WithRetries retries failed upstream requests under rp.
+func WithRetries(rp proxy.RetryPolicy) Option {
+	return func(s *Server) {
+		s.proxyOpts = append(s.proxyOpts, proxy.WithRetries(rp))
+	}
+}
+
+// WithProviders accepts sessions for the providers in reg instead of
+// proxy.DefaultProviders.
+func WithProviders(reg *proxy.ProviderRegistry) Option {
+	return func(s *Server) {
+		s.providers = reg
+	}
+}
+
+// WithUpstreams sends provider's sessions that have no upstream of their
+// own to urls, failing over in order.
+func WithUpstreams(provider string, urls ...string) Option {
+	return func(s *Server) {
+		s.proxyOpts = append(s.proxyOpts, proxy.WithUpstreams(provider, urls...))
+	}
+}
+
+// WithCircuitBreaker sets when failing upstreams are skipped and for how
+// long.
+func WithCircuitBreaker(failures int, coolDown time.Duration) Option {
+	return func(s *Server) {
+		s.proxyOpts = append(s.proxyOpts, proxy.WithCircuitBreaker(failures, coolDown))
+	}
+}
+
+// WithTracer records a span for every proxied request.
+func WithTracer(t *trace.Tracer) Option {
+	return func(s *Server) {
+		s.proxyOpts = append(s.proxyOpts, proxy.WithTracer(t))
+	}
+}
+
+// WithMetrics registers the server's metrics in reg instead of a private
+// registry, e.g. to expose them alongside an embedding program's own.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(s *Server) {
+		s.metrics = reg
+	}
 }
 
 // New creates a new Server with the given session store and admin token.
-func New(store session.Store, logger *log.Logger, adminToken string) *Server {
+func New(store session.Store, logger *slog.Logger, adminToken string, opts ...Option) *Server {
 	s := &Server{
 		store:      store,
-		proxy:      proxy.New(store, logger),
+		budgets:    budget.NewTracker(),
 		mux:        http.NewServeMux(),
 		logger:     logger,
 		adminToken: adminToken,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.catalog == nil {
+		s.catalog = credential.NewMemoryCatalog()
+	}
+	if s.providers == nil {
+		s.providers = proxy.DefaultProviders
+	}
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+	s.metrics.NewGaugeFunc("llmproxy_active_sessions", "Registered sessions that have not expired.", func() float64 {
+		return float64(len(s.store.List()))
+	})
+	s.proxyOpts = append(s.proxyOpts,
+		proxy.WithCatalog(s.catalog),
+		proxy.WithProviders(s.providers),
+		proxy.WithBudgets(s.budgets),
+		proxy.WithMetrics(s.metrics),
+	)
+	s.proxy = proxy.New(store, logger, s.proxyOpts...)
 
 	// Session registry API (called by the control plane).
 	s.mux.HandleFunc("POST /v1/sessions", s.requireAdminAuth(s.handleRegisterSession))
 	s.mux.HandleFunc("DELETE /v1/sessions/{token}", s.requireAdminAuth(s.handleRevokeSession))
 	s.mux.HandleFunc("DELETE /v1/sandboxes/{id}/sessions", s.requireAdminAuth(s.handleRevokeSandboxSessions))
 	s.mux.HandleFunc("GET /v1/sessions", s.requireAdminAuth(s.handleListSessions))
+	s.mux.HandleFunc("GET /v1/sandboxes/{id}/budget", s.requireAdminAuth(s.handleGetSandboxBudget))
+	s.mux.HandleFunc("POST /v1/sandboxes/{id}/budget", s.requireAdminAuth(s.handleTopUpSandboxBudget))
+	s.mux.HandleFunc("GET /v1/sandboxes/{id}/usage", s.requireAdminAuth(s.handleSandboxUsage))
+	s.mux.HandleFunc("GET /v1/usage", s.requireAdminAuth(s.handleUsage))
+	s.mux.HandleFunc("GET /v1/sandboxes/{id}/capture", s.requireAdminAuth(s.handleGetSandboxCapture))
+	s.mux.HandleFunc("PUT /v1/sandboxes/{id}/capture", s.requireAdminAuth(s.handleSetSandboxCapture))
+	s.mux.HandleFunc("POST /v1/admin/master-key/rotate", s.requireAdminAuth(s.handleRotateMasterKey))
+	s.mux.HandleFunc("POST /v1/tokens/revoke", s.requireAdminAuth(s.handleRevokeSignedToken))
+
+	// Credential catalog API.
+	s.mux.HandleFunc("POST /v1/credentials", s.requireAdminAuth(s.handleCreateCredential))
+	s.mux.HandleFunc("PUT /v1/credentials/{id}", s.requireAdminAuth(s.handleReplaceCredential))
+	s.mux.HandleFunc("DELETE /v1/credentials/{id}", s.requireAdminAuth(s.handleDeleteCredential))
+	s.mux.HandleFunc("GET /v1/credentials", s.requireAdminAuth(s.handleListCredentials))
+	s.mux.HandleFunc("GET /v1/credentials/{id}", s.requireAdminAuth(s.handleGetCredential))
 
 	// Health endpoint.
 	s.mux.HandleFunc("GET /v1/health", s.handleHealth)
 
+	// Prometheus metrics. Labels include sandbox IDs, so scraping needs
+	// the admin token like the rest of the control plane API.
+	s.mux.Handle("GET /metrics", s.requireAdminAuth(s.metrics.Handler().ServeHTTP))
+
 	// Everything else goes to the LLM proxy.
 	s.mux.Handle("/", s.proxy)
 
@@ -51,13 +226,13 @@ func New(store session.Store, logger *log.Logger, adminToken string) *Server {
 
 // Run starts the server on the given address.
 func (s *Server) Run(addr string) error {
-	s.logger.Printf("llm-proxy listening on %s", addr)
+	s.logger.Info("llm-proxy listening", "addr", addr)
 	return http.ListenAndServe(addr, s.mux)
 }
 
 // RunWithListener starts the server using the provided listener.
 func (s *Server) RunWithListener(l net.Listener) error {
-	s.logger.Printf("llm-proxy listening on %s", l.Addr())
+	s.logger.Info("llm-proxy listening", "addr", l.Addr().String())
 	return http.Serve(l, s.mux)
 }
 
@@ -66,6 +241,13 @@ func (s *Server) Handler() http.Handler {
 	return s.mux
 }
 
+// ForgetEndedSandboxes deletes the metrics of sandboxes that no live session
+// or unexpired signed token can act for, and returns how many were
+// forgotten. Run it after each reaper pass.
+func (s *Server) ForgetEndedSandboxes() int {
+	return s.proxy.ForgetEndedSandboxes()
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
@@ -93,11 +275,136 @@ func (s *Server) requireAdminAuth(next http.HandlerFunc) http.HandlerFunc {
 
 // registerRequest is the JSON body for POST /v1/sessions.
 type registerRequest struct {
-	Token       string `json:"token"`
+	// Token is optional; if omitted the proxy mints one and returns it in
+	// the response.
+	Token       string `json:"token,omitempty"`
 	Provider    string `json:"provider"`
-	APIKey      string `json:"api_key"`
+	APIKey      string `json:"api_key,omitempty"`
 	UpstreamURL string `json:"upstream_url,omitempty"`
 	SandboxID   string `json:"sandbox_id,omitempty"`
+
+	// UpstreamURLs lists upstreams to fail over between, in order, in
+	// place of upstream_url.
+	UpstreamURLs []string `json:"upstream_urls,omitempty"`
+
+	// UpstreamCredentials names, per upstream URL, the catalog credential
+	// sent to that upstream instead of api_key or credential_id. Required
+	// for every upstream on a different host from the first.
+	UpstreamCredentials map[string]string `json:"upstream_credentials,omitempty"`
+
+	// CredentialID references a catalog credential instead of carrying
+	// api_key. Exactly one of the two must be set.
+	CredentialID string `json:"credential_id,omitempty"`
+
+	// Azure carries the resource, deployments and api-version of
+	// azure-openai sessions.
+	Azure *session.AzureOpenAI `json:"azure,omitempty"`
+
+	// Bedrock carries the region and model IDs of bedrock sessions. The
+	// AWS credentials go in api_key or a catalog credential.
+	Bedrock *session.Bedrock `json:"bedrock,omitempty"`
+
+	// TTLSeconds and ExpiresAt bound the session lifetime. When both are
+	// set the earlier deadline wins.
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+
+	// IdleTimeoutSeconds expires the session after this long without use.
+	IdleTimeoutSeconds int64 `json:"idle_timeout_seconds,omitempty"`
+
+	// Limits caps this session's request rate and concurrency.
+	Limits *ratelimit.Limits `json:"limits,omitempty"`
+
+	// SandboxLimits caps the combined traffic of all sessions with the
+	// same sandbox_id.
+	SandboxLimits *ratelimit.Limits `json:"sandbox_limits,omitempty"`
+
+	// Budget caps this session's total spend in tokens and/or dollars.
+	Budget *budget.Limits `json:"budget,omitempty"`
+
+	// SandboxBudget caps the combined spend of all sessions with the same
+	// sandbox_id.
+	SandboxBudget *budget.Limits `json:"sandbox_budget,omitempty"`
+
+	// Capture archives the session's exchanges. Requires a capture
+	// directory.
+	Capture bool `json:"capture,omitempty"`
+}
+
+// validateLimits checks the optional rate limits.
+func (req *registerRequest) validateLimits() error {
+	if req.Limits != nil {
+		if err := req.Limits.Validate(); err != nil {
+			return fmt.Errorf("limits: %w", err)
+		}
+	}
+	if req.SandboxLimits != nil {
+		if req.SandboxID == "" {
+			return fmt.Errorf("sandbox_limits requires sandbox_id")
+		}
+		if err := req.SandboxLimits.Validate(); err != nil {
+			return fmt.Errorf("sandbox_limits: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateBudgets checks the optional budgets. Dollar budgets need prices.
+func (req *registerRequest) validateBudgets(prices budget.PriceTable) error {
+	check := func(name string, b *budget.Limits) error {
+		if b == nil {
+			return nil
+		}
+		if err := b.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if b.MaxCostUSD > 0 && prices == nil {
+			return fmt.Errorf("%s: max_cost_usd requires a price table", name)
+		}
+		return nil
+	}
+	if req.SandboxBudget != nil && req.SandboxID == "" {
+		return fmt.Errorf("sandbox_budget requires sandbox_id")
+	}
+	if err := check("budget", req.Budget); err != nil {
+		return err
+	}
+	return check("sandbox_budget", req.SandboxBudget)
+}
+
+// maxSeconds is the longest ttl_seconds or idle_timeout_seconds that fits
+// in a time.Duration.
+const maxSeconds = math.MaxInt64 / int64(time.Second)
+
+// deadline resolves the absolute expiry requested by ttl_seconds and
+// expires_at. The zero time means no deadline.
+func (req *registerRequest) deadline(now time.Time) (time.Time, error) {
+	if req.TTLSeconds < 0 {
+		return time.Time{}, fmt.Errorf("ttl_seconds must not be negative")
+	}
+	if req.TTLSeconds > maxSeconds {
+		return time.Time{}, fmt.Errorf("ttl_seconds must be at most %d", maxSeconds)
+	}
+	if req.IdleTimeoutSeconds < 0 {
+		return time.Time{}, fmt.Errorf("idle_timeout_seconds must not be negative")
+	}
+	if req.IdleTimeoutSeconds > maxSeconds {
+		return time.Time{}, fmt.Errorf("idle_timeout_seconds must be at most %d", maxSeconds)
+	}
+
+	var deadline time.Time
+	if req.TTLSeconds > 0 {
+		deadline = now.Add(time.Duration(req.TTLSeconds) * time.Second)
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			return time.Time{}, fmt.Errorf("expires_at must be in the future")
+		}
+		if deadline.IsZero() || req.ExpiresAt.Before(deadline) {
+			deadline = *req.ExpiresAt
+		}
+	}
+	return deadline, nil
 }
 
 func (s *Server) handleRegisterSession(w http.ResponseWriter, r *http.Request) {
@@ -107,17 +414,111 @@ func (s *Server) handleRegisterSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Token == "" || req.Provider == "" || req.APIKey == "" {
-		http.Error(w, `{"error":"token, provider, and api_key are required"}`, http.StatusBadRequest)
+	if req.Provider == "" || (req.APIKey == "") == (req.CredentialID == "") {
+		http.Error(w, `{"error":"provider and exactly one of api_key or credential_id are required"}`, http.StatusBadRequest)
+		return
+	}
+	prov, ok := s.providers.Lookup(req.Provider)
+	if !ok {
+		http.Error(w, fmt.Sprintf(`{"error":"unknown provider %q"}`, req.Provider), http.StatusBadRequest)
+		return
+	}
+	if req.CredentialID != "" {
+		cred, err := s.catalog.Get(req.CredentialID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"unknown credential_id %q"}`, req.CredentialID), http.StatusBadRequest)
+			return
+		}
+		if cred.Provider != req.Provider {
+			http.Error(w, fmt.Sprintf(`{"error":"credential %q is for provider %q"}`, cred.ID, cred.Provider), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.UpstreamURL != "" && len(req.UpstreamURLs) > 0 {
+		http.Error(w, `{"error":"upstream_url and upstream_urls are mutually exclusive"}`, http.StatusBadRequest)
+		return
+	}
+	if slices.Contains(req.UpstreamURLs, "") {
+		http.Error(w, `{"error":"upstream_urls must not contain empty entries"}`, http.StatusBadRequest)
+		return
+	}
+	upstreams := req.UpstreamURLs
+	if req.UpstreamURL != "" {
+		upstreams = []string{req.UpstreamURL}
+	}
+	if err := proxy.CheckUpstreams(upstreams, req.UpstreamCredentials); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err), http.StatusBadRequest)
+		return
+	}
+	for _, id := range req.UpstreamCredentials {
+		cred, err := s.catalog.Get(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"unknown upstream credential %q"}`, id), http.StatusBadRequest)
+			return
+		}
+		if cred.Provider != req.Provider {
+			http.Error(w, fmt.Sprintf(`{"error":"credential %q is for provider %q"}`, cred.ID, cred.Provider), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := req.validateLimits(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if err := req.validateBudgets(s.prices); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if req.Capture && s.capture == nil {
+		http.Error(w, `{"error":"capture is not enabled on this proxy"}`, http.StatusBadRequest)
+		return
+	}
+
+	minted := req.Token == ""
+	if minted {
+		token, err := session.NewToken()
+		if err != nil {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		req.Token = token
+	}
+
+	now := time.Now()
+	expiresAt, err := req.deadline(now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err), http.StatusBadRequest)
 		return
 	}
 
 	sess := &session.Session{
-		Token:       req.Token,
-		Provider:    req.Provider,
-		APIKey:      req.APIKey,
-		UpstreamURL: req.UpstreamURL,
-		SandboxID:   req.SandboxID,
+		Token:        s.hasher.Hash(req.Token),
+		Provider:     req.Provider,
+		APIKey:       req.APIKey,
+		CredentialID: req.CredentialID,
+		UpstreamURL:  req.UpstreamURL,
+		UpstreamURLs: req.UpstreamURLs,
+		SandboxID:    req.SandboxID,
+
+		UpstreamCredentials: req.UpstreamCredentials,
+		Azure:               req.Azure,
+		Bedrock:             req.Bedrock,
+		CreatedAt:           now,
+		ExpiresAt:           expiresAt,
+		IdleTimeout:         time.Duration(req.IdleTimeoutSeconds) * time.Second,
+
+		Limits:        req.Limits,
+		SandboxLimits: req.SandboxLimits,
+		Budget:        req.Budget,
+		SandboxBudget: req.SandboxBudget,
+		Capture:       req.Capture,
+	}
+	if sp, ok := prov.(proxy.SessionProvider); ok {
+		if err := sp.ValidateSession(sess); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err), http.StatusBadRequest)
+			return
+		}
 	}
 
 	if err := s.store.Register(sess); err != nil {
@@ -125,11 +526,18 @@ func (s *Server) handleRegisterSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.logger.Printf("registered session for sandbox=%s provider=%s", req.SandboxID, req.Provider)
+	s.logger.Info("registered session", logging.KeySandboxID, req.SandboxID, logging.KeyProvider, req.Provider)
+
+	resp := map[string]string{"status": "registered"}
+	if minted {
+		// The only time a minted token is ever disclosed.
+		resp["token"] = req.Token
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "registered"})
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
@@ -139,12 +547,15 @@ func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.store.Revoke(token); err != nil {
+	hashed := s.hasher.Hash(token)
+	if err := s.store.Revoke(hashed); err != nil {
 		http.Error(w, fmt.Sprintf(`{"error":"revoke failed: %s"}`, err), http.StatusInternalServerError)
 		return
 	}
+	s.budgets.Remove(budget.SessionKey(usage.SessionID(hashed)))
+	s.proxy.ForgetEndedSandboxes()
 
-	s.logger.Printf("revoked session")
+	s.logger.Info("revoked session")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
@@ -157,7 +568,20 @@ func (s *Server) handleRevokeSandboxSessions(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	revoked := s.store.RevokeBySandboxID(sandboxID)
-	s.logger.Printf("revoked %d sessions for sandbox=%s", revoked, sandboxID)
+	s.budgets.Forget(sandboxID)
+	s.proxy.ForgetSandbox(sandboxID)
+	if s.capture != nil {
+		s.capture.SetSandbox(sandboxID, false)
+	}
+	if s.verifier != nil {
+		// Also kill any signed tokens already issued to the sandbox.
+		if err := s.verifier.Revocations().RevokeSandbox(sandboxID); err != nil {
+			s.logger.Error("revoke sandbox signed tokens", logging.KeySandboxID, sandboxID, logging.Err(err))
+			http.Error(w, fmt.Sprintf(`{"error":"revoke signed tokens: %s"}`, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.logger.Info("revoked sandbox sessions", logging.KeySandboxID, sandboxID, "revoked", revoked)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
@@ -167,25 +591,158 @@ func (s *Server) handleRevokeSandboxSessions(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// revokeTokenRequest is the JSON body for POST /v1/tokens/revoke.
+type revokeTokenRequest struct {
+	// ID is the jti of a single token to revoke.
+	ID string `json:"jti,omitempty"`
+
+	// ExpiresAt is the token's own expiry, after which the revocation entry
+	// can be forgotten. Optional.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// SandboxID revokes every token issued to the sandbox so far.
+	SandboxID string `json:"sandbox_id,omitempty"`
+}
+
+func (s *Server) handleRevokeSignedToken(w http.ResponseWriter, r *http.Request) {
+	if s.verifier == nil {
+		http.Error(w, `{"error":"signed tokens are not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	var req revokeTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"invalid request: %s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" && req.SandboxID == "" {
+		http.Error(w, `{"error":"jti or sandbox_id is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	revocations := s.verifier.Revocations()
+	if req.ID != "" {
+		var until time.Time
+		if req.ExpiresAt != nil {
+			until = *req.ExpiresAt
+		}
+		if err := revocations.RevokeID(req.ID, until); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"revoke failed: %s"}`, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.SandboxID != "" {
+		if err := revocations.RevokeSandbox(req.SandboxID); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"revoke failed: %s"}`, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.logger.Info("revoked signed tokens", "jti", req.ID, logging.KeySandboxID, req.SandboxID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
+
 // sessionInfo is the JSON representation of a session in list responses.
 type sessionInfo struct {
-	Provider    string `json:"provider"`
-	SandboxID   string `json:"sandbox_id"`
-	UpstreamURL string `json:"upstream_url,omitempty"`
+	Provider     string   `json:"provider"`
+	SandboxID    string   `json:"sandbox_id"`
+	UpstreamURL  string   `json:"upstream_url,omitempty"`
+	UpstreamURLs []string `json:"upstream_urls,omitempty"`
+	CredentialID string   `json:"credential_id,omitempty"`
+
+	UpstreamCredentials map[string]string `json:"upstream_credentials,omitempty"`
+
+	Azure   *session.AzureOpenAI `json:"azure,omitempty"`
+	Bedrock *session.Bedrock     `json:"bedrock,omitempty"`
+
+	CreatedAt          time.Time  `json:"created_at"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	ExpiresInSeconds   *int64     `json:"expires_in_seconds,omitempty"`
+	IdleTimeoutSeconds int64      `json:"idle_timeout_seconds,omitempty"`
+
+	Limits        *ratelimit.Limits `json:"limits,omitempty"`
+	SandboxLimits *ratelimit.Limits `json:"sandbox_limits,omitempty"`
+	Budget        *budget.Limits    `json:"budget,omitempty"`
+	SandboxBudget *budget.Limits    `json:"sandbox_budget,omitempty"`
+	Capture       bool              `json:"capture,omitempty"`
 }
 
 func (s *Server) handleListSessions(w http.ResponseWriter, _ *http.Request) {
 	sessions := s.store.List()
+	now := time.Now()
 
 	infos := make([]sessionInfo, len(sessions))
 	for i, sess := range sessions {
 		infos[i] = sessionInfo{
-			Provider:    sess.Provider,
-			SandboxID:   sess.SandboxID,
-			UpstreamURL: sess.UpstreamURL,
+			Provider:            sess.Provider,
+			SandboxID:           sess.SandboxID,
+			UpstreamURL:         sess.UpstreamURL,
+			UpstreamURLs:        sess.UpstreamURLs,
+			CredentialID:        sess.CredentialID,
+			UpstreamCredentials: sess.UpstreamCredentials,
+			Azure:               sess.Azure,
+			Bedrock:             sess.Bedrock,
+			CreatedAt:           sess.CreatedAt,
+			IdleTimeoutSeconds:  int64(sess.IdleTimeout / time.Second),
+			Limits:              sess.Limits,
+			SandboxLimits:       sess.SandboxLimits,
+			Budget:              sess.Budget,
+			SandboxBudget:       sess.SandboxBudget,
+			Capture:             sess.Capture,
+		}
+		// Remaining lifetime accounts for both the absolute deadline and
+		// the idle timeout, whichever comes first.
+		if deadline := sess.Deadline(); !deadline.IsZero() {
+			remaining := int64(deadline.Sub(now) / time.Second)
+			if remaining < 0 {
+				remaining = 0
+			}
+			infos[i].ExpiresAt = &deadline
+			infos[i].ExpiresInSeconds = &remaining
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(infos)
 }
+
+// handleRotateMasterKey reloads the master key from the configured key file
+// and re-wraps every session under it. The key is never taken from the
+// request, so the key in use is always the one the next restart will load.
+// If the reloaded key is the one already active, it answers 409 rather
+// than report a rotation that changed nothing. A key from
+// GHOSTPROXY_MASTER_KEY always gets 409, as a running process's
+// environment cannot change.
+func (s *Server) handleRotateMasterKey(w http.ResponseWriter, r *http.Request) {
+	rotator, ok := s.store.(session.KeyRotator)
+	if !ok {
+		http.Error(w, `{"error":"encryption at rest is not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+	if r.ContentLength != 0 {
+		http.Error(w, `{"error":"the request takes no body; write the new key to -master-key-file"}`, http.StatusBadRequest)
+		return
+	}
+
+	keyID, rewrapped, err := rotator.RotateMasterKey()
+	if errors.Is(err, keyring.ErrUnchanged) {
+		http.Error(w, `{"error":"the configured master key is already active; write the new key to -master-key-file first (a key from GHOSTPROXY_MASTER_KEY cannot be rotated while running)"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.logger.Error("master key rotation failed", "rewrapped", rewrapped, logging.Err(err))
+		http.Error(w, fmt.Sprintf(`{"error":"rotation failed: %s"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("rotated master key", "key_id", keyID, "rewrapped", rewrapped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":    "rotated",
+		"key_id":    keyID,
+		"rewrapped": rewrapped,
+	})
+}
@@ -2,23 +2,49 @@ package server
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"llm-proxy/pkg/keyring"
 	"llm-proxy/pkg/session"
+	"llm-proxy/pkg/signedtoken"
 )
 
 func newTestServer(t *testing.T, adminToken string) *Server {
 	t.Helper()
-	logger := log.New(io.Discard, "", 0)
+	logger := slog.New(slog.DiscardHandler)
 	store := session.NewMemoryStore()
 	return New(store, logger, adminToken)
 }
 
+// adminRequest sends an admin-authenticated request with an optional JSON
+// body and returns the recorded response.
+func adminRequest(t *testing.T, srv *Server, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer secret-admin-token")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
 func TestSessionEndpointsRequireAdminAuth(t *testing.T) {
 	srv := newTestServer(t, "secret-admin-token")
 	req := httptest.NewRequest(http.MethodGet, "/v1/sessions", nil)
@@ -104,3 +130,358 @@ func TestRevokeSandboxSessionsEndpoint(t *testing.T) {
 		t.Fatalf("expected session-c to remain, got err: %v", err)
 	}
 }
+
+func TestRegisterSessionWithTTL(t *testing.T) {
+	srv := newTestServer(t, "secret-admin-token")
+
+	registerBody := map[string]any{
+		"token":                "session-ttl",
+		"provider":             "anthropic",
+		"api_key":              "sk-ant-real",
+		"sandbox_id":           "sandbox-a",
+		"ttl_seconds":          3600,
+		"idle_timeout_seconds": 60,
+	}
+	data, _ := json.Marshal(registerBody)
+	registerReq := httptest.NewRequest(http.MethodPost, "/v1/sessions", bytes.NewReader(data))
+	registerReq.Header.Set("Authorization", "Bearer secret-admin-token")
+	registerRec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(registerRec, registerReq)
+	if registerRec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d", registerRec.Code, http.StatusCreated)
+	}
+
+	listReq := httptest.NewRequest(http.MethodGet, "/v1/sessions", nil)
+	listReq.Header.Set("Authorization", "Bearer secret-admin-token")
+	listRec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(listRec, listReq)
+
+	var infos []sessionInfo
+	if err := json.NewDecoder(listRec.Body).Decode(&infos); err != nil {
+		t.Fatalf("decode list response: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("list returned %d sessions, want 1", len(infos))
+	}
+	// The idle timeout is the nearer deadline.
+	got := infos[0].ExpiresInSeconds
+	if got == nil || *got > 60 || *got < 55 {
+		t.Fatalf("expires_in_seconds = %v, want ~60", got)
+	}
+	if infos[0].IdleTimeoutSeconds != 60 {
+		t.Fatalf("idle_timeout_seconds = %d, want 60", infos[0].IdleTimeoutSeconds)
+	}
+}
+
+func TestRegisterSessionRejectsInvalidExpiry(t *testing.T) {
+	srv := newTestServer(t, "secret-admin-token")
+
+	tests := []struct {
+		name string
+		body map[string]any
+	}{
+		{"negative ttl", map[string]any{"ttl_seconds": -1}},
+		{"negative idle timeout", map[string]any{"idle_timeout_seconds": -5}},
+		{"ttl overflowing a duration", map[string]any{"ttl_seconds": int64(math.MaxInt64)}},
+		{"idle timeout overflowing a duration", map[string]any{"idle_timeout_seconds": int64(1) << 40}},
+		{"expires_at in the past", map[string]any{"expires_at": "2000-01-01T00:00:00Z"}},
+		{"negative limits", map[string]any{"limits": map[string]int{"max_concurrent": -1}}},
+		{"burst without rate", map[string]any{"limits": map[string]int{"burst": 5}}},
+		{"sandbox limits without sandbox", map[string]any{"sandbox_limits": map[string]int{"max_concurrent": 1}}},
+		{"upstream_url with upstream_urls", map[string]any{"upstream_url": "http://a", "upstream_urls": []string{"http://b"}}},
+		{"empty upstream_urls entry", map[string]any{"upstream_urls": []string{"http://a", ""}}},
+		{"upstream on another host without credential", map[string]any{"upstream_urls": []string{"https://api.anthropic.com", "https://gw.example.com"}}},
+		{"unknown upstream credential", map[string]any{
+			"upstream_urls":        []string{"https://api.anthropic.com", "https://gw.example.com"},
+			"upstream_credentials": map[string]string{"https://gw.example.com": "missing"},
+		}},
+		{"unregistered provider", map[string]any{"provider": "not-a-provider"}},
+		{"azure-openai without resource", map[string]any{"provider": "azure-openai", "azure": map[string]any{"api_version": "2024-10-21"}}},
+		{"azure-openai with bad resource", map[string]any{"provider": "azure-openai", "azure": map[string]any{"resource": "a.b"}}},
+		{"bedrock without region", map[string]any{"provider": "bedrock", "api_key": "AKID:secret", "bedrock": map[string]any{}}},
+		{"bedrock key without secret", map[string]any{"provider": "bedrock", "api_key": "AKIDONLY", "bedrock": map[string]any{"region": "us-east-1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.body["token"] = "session-x"
+			if tt.body["provider"] == nil {
+				tt.body["provider"] = "anthropic"
+			}
+			if tt.body["api_key"] == nil {
+				tt.body["api_key"] = "sk-ant-real"
+			}
+			data, _ := json.Marshal(tt.body)
+			req := httptest.NewRequest(http.MethodPost, "/v1/sessions", bytes.NewReader(data))
+			req.Header.Set("Authorization", "Bearer secret-admin-token")
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestRotateMasterKeyEndpoint(t *testing.T) {
+	t.Run("encryption disabled", func(t *testing.T) {
+		srv := newTestServer(t, "secret-admin-token")
+		req := httptest.NewRequest(http.MethodPost, "/v1/admin/master-key/rotate", nil)
+		req.Header.Set("Authorization", "Bearer secret-admin-token")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotImplemented {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotImplemented)
+		}
+	})
+
+	master := bytes.Repeat([]byte{1}, keyring.KeySize)
+	keys, err := keyring.New(func() ([]byte, error) { return master, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, _ := session.NewEncryptedStore(session.NewMemoryStore(), keys)
+	_ = store.Register(&session.Session{Token: "session-a", Provider: "anthropic", APIKey: "key-a"})
+	srv := New(store, slog.New(slog.DiscardHandler), "secret-admin-token")
+
+	t.Run("key in body rejected", func(t *testing.T) {
+		body := `{"key":"` + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, keyring.KeySize)) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/admin/master-key/rotate", bytes.NewReader([]byte(body)))
+		req.Header.Set("Authorization", "Bearer secret-admin-token")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		if keys.ActiveKeyID() == "" || store.List()[0].SealedAPIKey.KeyID != keys.ActiveKeyID() {
+			t.Fatal("a rejected request changed the master key")
+		}
+	})
+
+	t.Run("unchanged key", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/admin/master-key/rotate", nil)
+		req.Header.Set("Authorization", "Bearer secret-admin-token")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("rewraps sessions", func(t *testing.T) {
+		before := keys.ActiveKeyID()
+		master = bytes.Repeat([]byte{2}, keyring.KeySize)
+		req := httptest.NewRequest(http.MethodPost, "/v1/admin/master-key/rotate", nil)
+		req.Header.Set("Authorization", "Bearer secret-admin-token")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+
+		var resp struct {
+			KeyID     string `json:"key_id"`
+			Rewrapped int    `json:"rewrapped"`
+		}
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		if resp.Rewrapped != 1 || resp.KeyID != keys.ActiveKeyID() || resp.KeyID == before {
+			t.Fatalf("response = %+v, want 1 session rewrapped to %s", resp, keys.ActiveKeyID())
+		}
+
+		sess, _ := store.Lookup("session-a")
+		if got, err := store.OpenAPIKey(sess); err != nil || got != "key-a" {
+			t.Fatalf("OpenAPIKey() = %q, %v after rotation", got, err)
+		}
+	})
+}
+
+func TestRegisterStoresHashedToken(t *testing.T) {
+	hasher := session.NewTokenHasher([]byte("test-secret"))
+	store := session.NewMemoryStore()
+	srv := New(store, slog.New(slog.DiscardHandler), "secret-admin-token", WithTokenHasher(hasher))
+
+	data, _ := json.Marshal(map[string]string{
+		"token":    "session-raw",
+		"provider": "anthropic",
+		"api_key":  "sk-ant-real",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer secret-admin-token")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	if _, err := store.Lookup("session-raw"); err == nil {
+		t.Fatal("store is keyed by the raw token")
+	}
+	if _, err := store.Lookup(hasher.Hash("session-raw")); err != nil {
+		t.Fatalf("store is not keyed by the hashed token: %v", err)
+	}
+
+	revokeReq := httptest.NewRequest(http.MethodDelete, "/v1/sessions/session-raw", nil)
+	revokeReq.Header.Set("Authorization", "Bearer secret-admin-token")
+	revokeRec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(revokeRec, revokeReq)
+	if _, err := store.Lookup(hasher.Hash("session-raw")); err == nil {
+		t.Fatal("revoke by raw token did not remove the hashed session")
+	}
+}
+
+func TestRegisterMintsToken(t *testing.T) {
+	hasher := session.NewTokenHasher([]byte("test-secret"))
+	store := session.NewMemoryStore()
+	srv := New(store, slog.New(slog.DiscardHandler), "secret-admin-token", WithTokenHasher(hasher))
+
+	data, _ := json.Marshal(map[string]string{
+		"provider":   "anthropic",
+		"api_key":    "sk-ant-real",
+		"sandbox_id": "sandbox-a",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer secret-admin-token")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	var resp map[string]string
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	token := resp["token"]
+	if !strings.HasPrefix(token, "session-") {
+		t.Fatalf("minted token = %q, want session- prefix", token)
+	}
+	if _, err := store.Lookup(hasher.Hash(token)); err != nil {
+		t.Fatalf("minted token not registered: %v", err)
+	}
+
+	listReq := httptest.NewRequest(http.MethodGet, "/v1/sessions", nil)
+	listReq.Header.Set("Authorization", "Bearer secret-admin-token")
+	listRec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(listRec, listReq)
+	if strings.Contains(listRec.Body.String(), token) {
+		t.Fatal("list response leaked the minted token")
+	}
+}
+
+func TestRegisterDoesNotEchoSuppliedToken(t *testing.T) {
+	srv := newTestServer(t, "secret-admin-token")
+
+	data, _ := json.Marshal(map[string]string{
+		"token":    "session-supplied",
+		"provider": "anthropic",
+		"api_key":  "sk-ant-real",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer secret-admin-token")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if strings.Contains(rec.Body.String(), "session-supplied") {
+		t.Fatalf("register response echoed the caller's token: %s", rec.Body.String())
+	}
+}
+
+func TestRevokeSignedTokenEndpoint(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	verifier := signedtoken.NewVerifier(map[string]ed25519.PublicKey{"cp": pub}, time.Hour)
+	srv := New(session.NewMemoryStore(), slog.New(slog.DiscardHandler), "secret-admin-token", WithSignedTokens(verifier))
+
+	body := []byte(`{"jti":"tok-1"}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/tokens/revoke", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret-admin-token")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !verifier.Revocations().IsRevoked(&signedtoken.Claims{ID: "tok-1"}) {
+		t.Fatal("token was not added to the revocation list")
+	}
+
+	// Revoking a sandbox's sessions also revokes its signed tokens.
+	req = httptest.NewRequest(http.MethodDelete, "/v1/sandboxes/sandbox-a/sessions", nil)
+	req.Header.Set("Authorization", "Bearer secret-admin-token")
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	claims := &signedtoken.Claims{ID: "tok-2", SandboxID: "sandbox-a", IssuedAt: time.Now().Add(-time.Minute).Unix()}
+	if !verifier.Revocations().IsRevoked(claims) {
+		t.Fatal("sandbox revocation did not cover signed tokens")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {}\n\n")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, "secret-admin-token")
+	adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]string{
+		"token": "session-a", "provider": "anthropic", "api_key": "sk-ant-real",
+		"upstream_url": upstream.URL, "sandbox_id": "sandbox-x",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("x-api-key", "session-a")
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("x-api-key", "session-unknown")
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := adminRequest(t, srv, http.MethodGet, "/metrics", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`llmproxy_requests_total{provider="anthropic",sandbox="sandbox-x",code="200"} 1`,
+		`llmproxy_requests_total{provider="",sandbox="",code="401"} 1`,
+		`llmproxy_upstream_responses_total{provider="anthropic",sandbox="sandbox-x",code="200"} 1`,
+		`llmproxy_upstream_latency_seconds_count{provider="anthropic",sandbox="sandbox-x"} 1`,
+		`llmproxy_stream_first_byte_seconds_count{provider="anthropic",sandbox="sandbox-x"} 1`,
+		`llmproxy_response_bytes_total{provider="anthropic",sandbox="sandbox-x"} 10`,
+		`llmproxy_in_flight_requests{provider="anthropic",sandbox="sandbox-x"} 0`,
+		"llmproxy_active_sessions 1",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokeSandboxForgetsMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, "secret-admin-token")
+	for _, sandbox := range []string{"sandbox-x", "sandbox-y"} {
+		adminRequest(t, srv, http.MethodPost, "/v1/sessions", map[string]string{
+			"token": "session-" + sandbox, "provider": "anthropic", "api_key": "sk-ant-real",
+			"upstream_url": upstream.URL, "sandbox_id": sandbox,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("x-api-key", "session-"+sandbox)
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	adminRequest(t, srv, http.MethodDelete, "/v1/sandboxes/sandbox-x/sessions", nil)
+	adminRequest(t, srv, http.MethodDelete, "/v1/sessions/session-sandbox-y", nil)
+
+	body := adminRequest(t, srv, http.MethodGet, "/metrics", nil).Body.String()
+	for _, sandbox := range []string{"sandbox-x", "sandbox-y"} {
+		if strings.Contains(body, `sandbox="`+sandbox+`"`) {
+			t.Errorf("metrics still export revoked %s:\n%s", sandbox, body)
+		}
+	}
+}
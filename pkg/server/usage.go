@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"llm-proxy/pkg/logging"
+	"llm-proxy/pkg/usage"
+)
+
+// defaultUsageGroupBy is the grouping of GET /v1/usage when the caller
+// doesn't pass group_by.
+const defaultUsageGroupBy = "sandbox,provider,model"
+
+// defaultSandboxUsageGroupBy is the grouping of GET
+// /v1/sandboxes/{id}/usage when the caller doesn't pass group_by.
+const defaultSandboxUsageGroupBy = "session,provider,model"
+
+// usageQuery is a parsed usage report request.
+type usageQuery struct {
+	filter  usage.Filter
+	groupBy []string
+	format  string
+}
+
+// parseUsageQuery reads filters, grouping and output format from the query
+// string.
+func parseUsageQuery(q url.Values, defaultGroupBy string) (*usageQuery, error) {
+	uq := &usageQuery{
+		filter: usage.Filter{
+			SandboxID: q.Get("sandbox_id"),
+			Provider:  q.Get("provider"),
+			Model:     q.Get("model"),
+		},
+		format: q.Get("format"),
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &uq.filter.From, "to": &uq.filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*dst = t
+		}
+	}
+
+	groupBy := defaultGroupBy
+	if q.Has("group_by") {
+		groupBy = q.Get("group_by")
+	}
+	var err error
+	if uq.groupBy, err = usage.ParseGroupBy(groupBy); err != nil {
+		return nil, err
+	}
+
+	switch uq.format {
+	case "", "json", "csv", "jsonl":
+	default:
+		return nil, fmt.Errorf("format must be json, csv or jsonl")
+	}
+	return uq, nil
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	s.serveUsage(w, r, "", defaultUsageGroupBy)
+}
+
+func (s *Server) handleSandboxUsage(w http.ResponseWriter, r *http.Request) {
+	s.serveUsage(w, r, r.PathValue("id"), defaultSandboxUsageGroupBy)
+}
+
+// serveUsage writes a usage report, restricted to sandboxID if set.
+func (s *Server) serveUsage(w http.ResponseWriter, r *http.Request, sandboxID, defaultGroupBy string) {
+	if s.usage == nil {
+		http.Error(w, `{"error":"usage accounting is not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	uq, err := parseUsageQuery(r.URL.Query(), defaultGroupBy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err), http.StatusBadRequest)
+		return
+	}
+	if sandboxID != "" {
+		uq.filter.SandboxID = sandboxID
+	}
+
+	rows := s.usage.Report(uq.filter, uq.groupBy)
+
+	switch uq.format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		if err := usage.WriteCSV(w, rows); err != nil {
+			s.logger.Error("error writing usage csv", logging.Err(err))
+		}
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if err := usage.WriteJSONL(w, rows); err != nil {
+			s.logger.Error("error writing usage jsonl", logging.Err(err))
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"group_by": uq.groupBy,
+			"rows":     rows,
+			"total":    usage.Sum(rows),
+		})
+	}
+}
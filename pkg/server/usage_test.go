@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm-proxy/pkg/session"
+	"llm-proxy/pkg/usage"
+)
+
+func TestUsageEndpoints(t *testing.T) {
+	ledger := usage.NewLedger(0)
+	srv := New(session.NewMemoryStore(), slog.New(slog.DiscardHandler), "secret-admin-token", WithUsage(ledger))
+
+	ledger.Add(usage.Record{SessionID: "s1", SandboxID: "a", Provider: "anthropic", Model: "claude-sonnet-4-5", Usage: usage.Usage{InputTokens: 10, OutputTokens: 2}, CostUSD: 0.5})
+	ledger.Add(usage.Record{SessionID: "s2", SandboxID: "a", Provider: "openai", Model: "gpt-4o", Usage: usage.Usage{InputTokens: 5}, CostUSD: 0.25})
+	ledger.Add(usage.Record{SessionID: "s3", SandboxID: "b", Provider: "openai", Model: "gpt-4o", Usage: usage.Usage{InputTokens: 1}})
+
+	var report struct {
+		Rows  []usage.Row `json:"rows"`
+		Total usage.Row   `json:"total"`
+	}
+
+	rec := adminRequest(t, srv, http.MethodGet, "/v1/usage?provider=openai", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	json.NewDecoder(rec.Body).Decode(&report)
+	if len(report.Rows) != 2 || report.Total.InputTokens != 6 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	rec = adminRequest(t, srv, http.MethodGet, "/v1/sandboxes/a/usage", nil)
+	report.Rows = nil
+	json.NewDecoder(rec.Body).Decode(&report)
+	if len(report.Rows) != 2 || report.Rows[0].SessionID != "s1" || report.Total.CostUSD != 0.75 {
+		t.Fatalf("sandbox report = %+v", report)
+	}
+
+	rec = adminRequest(t, srv, http.MethodGet, "/v1/usage?group_by=sandbox&format=csv", nil)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("Content-Type = %q, want text/csv", ct)
+	}
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 3 {
+		t.Fatalf("csv has %d lines, want header + 2 rows:\n%s", len(lines), rec.Body.String())
+	}
+
+	rec = adminRequest(t, srv, http.MethodGet, "/v1/usage?group_by=model&format=jsonl", nil)
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 2 {
+		t.Fatalf("jsonl has %d lines, want 2:\n%s", len(lines), rec.Body.String())
+	}
+
+	for _, bad := range []string{"?from=yesterday", "?group_by=color", "?format=xml"} {
+		if rec := adminRequest(t, srv, http.MethodGet, "/v1/usage"+bad, nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("GET /v1/usage%s status = %d, want %d", bad, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUsageEndpointsDisabled(t *testing.T) {
+	srv := newTestServer(t, "secret-admin-token")
+	if rec := adminRequest(t, srv, http.MethodGet, "/v1/usage", nil); rec.Code != http.StatusNotImplemented {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/v1/usage", nil)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
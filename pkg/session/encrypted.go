@@ -0,0 +1,232 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	"llm-proxy/pkg/keyring"
+)
+
+// EncryptedStore wraps another Store and seals every session's API key
+// with envelope encryption before it reaches the inner store. Sessions
+// returned by Lookup and List carry only SealedAPIKey; callers recover the
+// plaintext with OpenAPIKey at the moment they need it. Secrets stored
+// through SecretStore are sealed the same way.
+type EncryptedStore struct {
+	inner Store
+	keys  *keyring.Keyring
+
+	// rotation is held exclusively while the master key is rotated so no
+	// session is written or revoked between listing and re-wrapping.
+	rotation sync.RWMutex
+}
+
+// NewEncryptedStore wraps inner with API key encryption. Any session or
+// secret already in inner in plaintext (for example from a file store
+// written before encryption was enabled) is sealed in place, and a
+// compactable inner store is compacted so no plaintext record survives.
+func NewEncryptedStore(inner Store, keys *keyring.Keyring) (*EncryptedStore, error) {
+	e := &EncryptedStore{inner: inner, keys: keys}
+
+	migrated := 0
+	for _, s := range inner.List() {
+		if s.APIKey == "" {
+			continue
+		}
+		if err := e.Register(s); err != nil {
+			return nil, fmt.Errorf("seal existing session: %w", err)
+		}
+		migrated++
+	}
+	if records, ok := inner.(secretRecords); ok {
+		for _, s := range records.secretList() {
+			if s.Sealed != nil {
+				continue
+			}
+			if err := e.PutSecret(s.ID, s.Value); err != nil {
+				return nil, fmt.Errorf("seal existing secret: %w", err)
+			}
+			migrated++
+		}
+	}
+
+	if c, ok := inner.(interface{ Compact() error }); ok && migrated > 0 {
+		if err := c.Compact(); err != nil {
+			return nil, fmt.Errorf("compact after sealing: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// Register seals the session's API key and stores it in the inner store.
+// The caller's session is not modified.
+func (e *EncryptedStore) Register(s *Session) error {
+	// Seal and store under one read lock, so a rotation cannot retire the
+	// master key between the two and leave the session unreadable.
+	e.rotation.RLock()
+	defer e.rotation.RUnlock()
+
+	sealed := *s
+	if sealed.APIKey != "" {
+		key, err := e.keys.Seal([]byte(sealed.APIKey), []byte(sealed.Token))
+		if err != nil {
+			return fmt.Errorf("seal api key: %w", err)
+		}
+		sealed.SealedAPIKey = key
+		sealed.APIKey = ""
+	}
+	return e.inner.Register(&sealed)
+}
+
+// Lookup retrieves a session with its API key still sealed.
+func (e *EncryptedStore) Lookup(token string) (*Session, error) {
+	return e.inner.Lookup(token)
+}
+
+// Revoke removes a session from the inner store.
+func (e *EncryptedStore) Revoke(token string) error {
+	e.rotation.RLock()
+	defer e.rotation.RUnlock()
+	return e.inner.Revoke(token)
+}
+
+// RevokeBySandboxID removes all sessions for a sandbox from the inner store.
+func (e *EncryptedStore) RevokeBySandboxID(sandboxID string) int {
+	e.rotation.RLock()
+	defer e.rotation.RUnlock()
+	return e.inner.RevokeBySandboxID(sandboxID)
+}
+
+// List returns all sessions with their API keys sealed.
+func (e *EncryptedStore) List() []*Session {
+	return e.inner.List()
+}
+
+// OpenAPIKey decrypts the API key of a session returned by Lookup.
+func (e *EncryptedStore) OpenAPIKey(s *Session) (string, error) {
+	if s.SealedAPIKey == nil {
+		return s.APIKey, nil
+	}
+	key, err := e.keys.Open(s.SealedAPIKey, []byte(s.Token))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// PutSecret seals a secret and stores it in the inner store.
+func (e *EncryptedStore) PutSecret(id string, value []byte) error {
+	e.rotation.RLock()
+	defer e.rotation.RUnlock()
+
+	records, err := e.secretRecords()
+	if err != nil {
+		return err
+	}
+	sealed, err := e.keys.Seal(value, secretAAD(id))
+	if err != nil {
+		return fmt.Errorf("seal secret: %w", err)
+	}
+	return records.putSecret(&secret{ID: id, Sealed: sealed})
+}
+
+// DeleteSecret removes a secret from the inner store.
+func (e *EncryptedStore) DeleteSecret(id string) error {
+	e.rotation.RLock()
+	defer e.rotation.RUnlock()
+
+	records, err := e.secretRecords()
+	if err != nil {
+		return err
+	}
+	return records.DeleteSecret(id)
+}
+
+// Secrets opens and returns every stored secret by ID.
+func (e *EncryptedStore) Secrets() (map[string][]byte, error) {
+	records, err := e.secretRecords()
+	if err != nil {
+		return nil, err
+	}
+	list := records.secretList()
+	values := make(map[string][]byte, len(list))
+	for _, s := range list {
+		if s.Sealed == nil {
+			values[s.ID] = s.Value
+			continue
+		}
+		value, err := e.keys.Open(s.Sealed, secretAAD(s.ID))
+		if err != nil {
+			return nil, fmt.Errorf("open secret %s: %w", s.ID, err)
+		}
+		values[s.ID] = value
+	}
+	return values, nil
+}
+
+func (e *EncryptedStore) secretRecords() (secretRecords, error) {
+	records, ok := e.inner.(secretRecords)
+	if !ok {
+		return nil, fmt.Errorf("session store does not hold secrets")
+	}
+	return records, nil
+}
+
+// secretAAD binds a sealed secret to its ID, and keeps it distinct from
+// session API keys, which are bound to their token.
+func secretAAD(id string) []byte {
+	return []byte("secret:" + id)
+}
+
+// RotateMasterKey reloads the master key from the keyring's source,
+// re-wraps the data key of every stored session and secret, and retires
+// the previous master keys once all of them have been re-wrapped. If re-wrapping fails
+// part way, old keys are kept so no session becomes unreadable, and a
+// retry resumes re-wrapping. The key is only ever taken from the source,
+// so a restart loads the same key the sessions were re-wrapped under. If
+// the source still holds the active key, it fails with
+// keyring.ErrUnchanged.
+func (e *EncryptedStore) RotateMasterKey() (string, int, error) {
+	e.rotation.Lock()
+	defer e.rotation.Unlock()
+
+	keyID, err := e.keys.Rotate(nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("rotate master key: %w", err)
+	}
+
+	rewrapped := 0
+	for _, s := range e.inner.List() {
+		if s.SealedAPIKey == nil || s.SealedAPIKey.KeyID == keyID {
+			continue
+		}
+		wrapped, err := e.keys.Rewrap(s.SealedAPIKey)
+		if err != nil {
+			return keyID, rewrapped, fmt.Errorf("rewrap session key: %w", err)
+		}
+		s.SealedAPIKey = wrapped
+		if err := e.inner.Register(s); err != nil {
+			return keyID, rewrapped, fmt.Errorf("store rewrapped session: %w", err)
+		}
+		rewrapped++
+	}
+	if records, ok := e.inner.(secretRecords); ok {
+		for _, s := range records.secretList() {
+			if s.Sealed == nil || s.Sealed.KeyID == keyID {
+				continue
+			}
+			wrapped, err := e.keys.Rewrap(s.Sealed)
+			if err != nil {
+				return keyID, rewrapped, fmt.Errorf("rewrap secret key: %w", err)
+			}
+			s.Sealed = wrapped
+			if err := records.putSecret(s); err != nil {
+				return keyID, rewrapped, fmt.Errorf("store rewrapped secret: %w", err)
+			}
+			rewrapped++
+		}
+	}
+
+	e.keys.Retire()
+	return keyID, rewrapped, nil
+}
@@ -0,0 +1,177 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"llm-proxy/pkg/keyring"
+)
+
+func newTestKeyring(t *testing.T) *keyring.Keyring {
+	t.Helper()
+	k, err := keyring.New(func() ([]byte, error) { return bytes.Repeat([]byte{7}, keyring.KeySize), nil })
+	if err != nil {
+		t.Fatalf("keyring.New() error = %v", err)
+	}
+	return k
+}
+
+func TestEncryptedStore_SealsAPIKey(t *testing.T) {
+	inner := NewMemoryStore()
+	store, err := NewEncryptedStore(inner, newTestKeyring(t))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore() error = %v", err)
+	}
+
+	sess := &Session{Token: "t1", Provider: "anthropic", APIKey: "sk-ant-real"}
+	if err := store.Register(sess); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if sess.APIKey != "sk-ant-real" {
+		t.Fatal("Register() modified the caller's session")
+	}
+
+	raw, _ := inner.Lookup("t1")
+	if raw.APIKey != "" || raw.SealedAPIKey == nil {
+		t.Fatalf("inner store holds APIKey=%q SealedAPIKey=%v, want sealed only", raw.APIKey, raw.SealedAPIKey)
+	}
+
+	got, err := store.Lookup("t1")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	key, err := store.OpenAPIKey(got)
+	if err != nil {
+		t.Fatalf("OpenAPIKey() error = %v", err)
+	}
+	if key != "sk-ant-real" {
+		t.Fatalf("OpenAPIKey() = %q, want %q", key, "sk-ant-real")
+	}
+}
+
+func TestEncryptedStore_KeyBoundToToken(t *testing.T) {
+	store, _ := NewEncryptedStore(NewMemoryStore(), newTestKeyring(t))
+	_ = store.Register(&Session{Token: "t1", APIKey: "k1"})
+
+	got, _ := store.Lookup("t1")
+	got.Token = "t2"
+	if _, err := store.OpenAPIKey(got); err == nil {
+		t.Fatal("OpenAPIKey() should fail when the sealed key is moved to another token")
+	}
+}
+
+func TestEncryptedStore_RotateMasterKey(t *testing.T) {
+	master := bytes.Repeat([]byte{7}, keyring.KeySize)
+	keys, err := keyring.New(func() ([]byte, error) { return master, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, _ := NewEncryptedStore(NewMemoryStore(), keys)
+	_ = store.Register(&Session{Token: "a", APIKey: "key-a"})
+	_ = store.Register(&Session{Token: "b", APIKey: "key-b"})
+
+	master = bytes.Repeat([]byte{9}, keyring.KeySize)
+	keyID, rewrapped, err := store.RotateMasterKey()
+	if err != nil {
+		t.Fatalf("RotateMasterKey() error = %v", err)
+	}
+	if rewrapped != 2 {
+		t.Fatalf("RotateMasterKey() rewrapped %d, want 2", rewrapped)
+	}
+
+	for token, want := range map[string]string{"a": "key-a", "b": "key-b"} {
+		sess, _ := store.Lookup(token)
+		if sess.SealedAPIKey.KeyID != keyID {
+			t.Fatalf("session %s KeyID = %q, want %q", token, sess.SealedAPIKey.KeyID, keyID)
+		}
+		got, err := store.OpenAPIKey(sess)
+		if err != nil {
+			t.Fatalf("OpenAPIKey(%s) error = %v", token, err)
+		}
+		if got != want {
+			t.Fatalf("OpenAPIKey(%s) = %q, want %q", token, got, want)
+		}
+	}
+}
+
+func TestEncryptedStore_SealsExistingPlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	fileStore := openTestFileStore(t, path)
+	_ = fileStore.Register(&Session{Token: "legacy", APIKey: "plain-key"})
+
+	store, err := NewEncryptedStore(fileStore, newTestKeyring(t))
+	if err != nil {
+		t.Fatalf("NewEncryptedStore() error = %v", err)
+	}
+	fileStore.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("plain-key")) {
+		t.Fatal("session log still contains the plaintext key")
+	}
+
+	reopened := openTestFileStore(t, path)
+	raw, err := reopened.Lookup("legacy")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if raw.APIKey != "" || raw.SealedAPIKey == nil {
+		t.Fatal("plaintext key was not sealed on disk")
+	}
+	if got, _ := store.OpenAPIKey(raw); got != "plain-key" {
+		t.Fatalf("OpenAPIKey() = %q, want %q", got, "plain-key")
+	}
+}
+
+func TestEncryptedStore_Secrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	fileStore := openTestFileStore(t, path)
+	_ = fileStore.PutSecret("legacy", []byte("plain-legacy"))
+
+	master := bytes.Repeat([]byte{7}, keyring.KeySize)
+	keys, err := keyring.New(func() ([]byte, error) { return master, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewEncryptedStore(fileStore, keys)
+	if err != nil {
+		t.Fatalf("NewEncryptedStore() error = %v", err)
+	}
+	if err := store.PutSecret("new", []byte("plain-new")); err != nil {
+		t.Fatalf("PutSecret() error = %v", err)
+	}
+
+	master = bytes.Repeat([]byte{9}, keyring.KeySize)
+	if _, rewrapped, err := store.RotateMasterKey(); err != nil || rewrapped != 2 {
+		t.Fatalf("RotateMasterKey() = %d, %v, want 2 secrets rewrapped", rewrapped, err)
+	}
+	fileStore.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"plain-legacy", "plain-new"} {
+		if bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString([]byte(plain)))) {
+			t.Fatalf("session log contains %s unsealed", plain)
+		}
+	}
+
+	reopened, err := NewEncryptedStore(openTestFileStore(t, path), keys)
+	if err != nil {
+		t.Fatalf("NewEncryptedStore() error = %v", err)
+	}
+	secrets, err := reopened.Secrets()
+	if err != nil {
+		t.Fatalf("Secrets() error = %v", err)
+	}
+	if string(secrets["legacy"]) != "plain-legacy" || string(secrets["new"]) != "plain-new" {
+		t.Fatalf("Secrets() = %q", secrets)
+	}
+}
@@ -0,0 +1,377 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// compactMinRecords is the log length below which compaction is never
+// attempted; rewriting a tiny file buys nothing.
+const compactMinRecords = 1024
+
+// Log record operations.
+const (
+	opPut           = "put"
+	opDelete        = "del"
+	opDeleteSandbox = "del_sandbox"
+	opPutSecret     = "put_secret"
+	opDeleteSecret  = "del_secret"
+)
+
+// logRecord is one line of the append-only session log.
+type logRecord struct {
+	Op        string   `json:"op"`
+	Session   *Session `json:"session,omitempty"`
+	Token     string   `json:"token,omitempty"`
+	SandboxID string   `json:"sandbox_id,omitempty"`
+	Secret    *secret  `json:"secret,omitempty"`
+	SecretID  string   `json:"secret_id,omitempty"`
+}
+
+// FileStore is a durable session store backed by an append-only JSON-lines
+// log. Every mutation is appended and fsynced before it takes effect in
+// memory; the log is rewritten from the live set once it grows to more than
+// twice the number of live sessions and secrets.
+//
+// Reads are served from an embedded MemoryStore, so Lookup never touches
+// disk. Idle timers are not persisted: they restart when the log is loaded.
+type FileStore struct {
+	mem  *MemoryStore
+	path string
+
+	// mu serialises log appends and compaction.
+	mu      sync.Mutex
+	file    *os.File
+	records int
+
+	// dirty is set when an append failed after the in-memory state had
+	// already changed (revocations must take effect regardless). The next
+	// write rewrites the log from memory to bring it back in sync.
+	dirty bool
+}
+
+// NewFileStore opens (or creates) the session log at path, replays it, and
+// compacts it. A torn final line left by a crash mid-append is discarded.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{
+		mem:  NewMemoryStore(),
+		path: path,
+	}
+
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close flushes and closes the underlying log file.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Register durably adds or updates a session.
+func (f *FileStore) Register(s *Session) error {
+	if s.Token == "" {
+		return fmt.Errorf("session token cannot be empty")
+	}
+
+	stored := *s
+	f.mem.stamp(&stored)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.append(logRecord{Op: opPut, Session: &stored}); err != nil {
+		return err
+	}
+	f.mem.mu.Lock()
+	f.mem.put(&stored)
+	f.mem.mu.Unlock()
+	return nil
+}
+
+// Compact rewrites the log so it holds exactly one record per live session
+// and secret.
+func (f *FileStore) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.compact()
+}
+
+// Lookup retrieves a session by token from memory.
+func (f *FileStore) Lookup(token string) (*Session, error) {
+	return f.mem.Lookup(token)
+}
+
+// Revoke removes a session. The in-memory revocation always takes effect,
+// even if persisting it fails.
+func (f *FileStore) Revoke(token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_ = f.mem.Revoke(token)
+	if err := f.append(logRecord{Op: opDelete, Token: token}); err != nil {
+		f.dirty = true
+		return err
+	}
+	return nil
+}
+
+// RevokeBySandboxID removes all sessions for a sandbox. The in-memory
+// revocation always takes effect; if persisting it fails the log is
+// rewritten on the next successful write.
+func (f *FileStore) RevokeBySandboxID(sandboxID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	revoked := f.mem.RevokeBySandboxID(sandboxID)
+	if revoked == 0 {
+		return 0
+	}
+	if err := f.append(logRecord{Op: opDeleteSandbox, SandboxID: sandboxID}); err != nil {
+		f.dirty = true
+	}
+	return revoked
+}
+
+// List returns all live sessions.
+func (f *FileStore) List() []*Session {
+	return f.mem.List()
+}
+
+// PutSecret durably adds or replaces a secret.
+func (f *FileStore) PutSecret(id string, value []byte) error {
+	return f.putSecret(&secret{ID: id, Value: value})
+}
+
+func (f *FileStore) putSecret(s *secret) error {
+	if s.ID == "" {
+		return fmt.Errorf("secret id cannot be empty")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.append(logRecord{Op: opPutSecret, Secret: s}); err != nil {
+		return err
+	}
+	return f.mem.putSecret(s)
+}
+
+// DeleteSecret durably removes a secret. Unlike a revocation, a delete
+// that cannot be persisted is not applied.
+func (f *FileStore) DeleteSecret(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.append(logRecord{Op: opDeleteSecret, SecretID: id}); err != nil {
+		return err
+	}
+	return f.mem.DeleteSecret(id)
+}
+
+// Secrets returns every stored secret by ID.
+func (f *FileStore) Secrets() (map[string][]byte, error) {
+	return f.mem.Secrets()
+}
+
+func (f *FileStore) secretList() []*secret {
+	return f.mem.secretList()
+}
+
+// Reap evicts expired sessions from memory. Their log records are dropped
+// at the next compaction, and replaying them is harmless because expiry is
+// re-checked on every Lookup.
+func (f *FileStore) Reap() int {
+	return f.mem.Reap()
+}
+
+// load replays the log into memory and opens it for appending.
+func (f *FileStore) load() error {
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open session log: %w", err)
+	}
+
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// Torn write from a crash: drop the partial record.
+				if err := file.Truncate(offset); err != nil {
+					file.Close()
+					return fmt.Errorf("truncate torn session log record: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("read session log: %w", err)
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec logRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				file.Close()
+				return fmt.Errorf("corrupt session log at offset %d: %w", offset, err)
+			}
+			f.apply(rec)
+			f.records++
+		}
+		offset += int64(len(line))
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("seek session log: %w", err)
+	}
+	f.file = file
+	return nil
+}
+
+// apply replays a single log record into memory.
+func (f *FileStore) apply(rec logRecord) {
+	switch rec.Op {
+	case opPut:
+		if rec.Session == nil || rec.Session.Token == "" {
+			return
+		}
+		s := *rec.Session
+		s.LastUsed = f.mem.now()
+		f.mem.mu.Lock()
+		f.mem.put(&s)
+		f.mem.mu.Unlock()
+	case opDelete:
+		_ = f.mem.Revoke(rec.Token)
+	case opDeleteSandbox:
+		f.mem.RevokeBySandboxID(rec.SandboxID)
+	case opPutSecret:
+		if rec.Secret != nil {
+			_ = f.mem.putSecret(rec.Secret)
+		}
+	case opDeleteSecret:
+		_ = f.mem.DeleteSecret(rec.SecretID)
+	}
+}
+
+// append writes and fsyncs a record, compacting first if the log has grown
+// too large or fallen out of sync. Callers must hold f.mu.
+func (f *FileStore) append(rec logRecord) error {
+	if f.file == nil {
+		return fmt.Errorf("session log is closed")
+	}
+
+	if f.dirty || (f.records >= compactMinRecords && f.records > 2*f.mem.len()) {
+		if err := f.compact(); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode session log record: %w", err)
+	}
+	line = append(line, '\n')
+
+	if _, err := f.file.Write(line); err != nil {
+		return fmt.Errorf("write session log: %w", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("sync session log: %w", err)
+	}
+	f.records++
+	return nil
+}
+
+// compact atomically replaces the log with one put record per live
+// session and secret. Callers must hold f.mu (or have exclusive access during open).
+func (f *FileStore) compact() error {
+	tmpPath := f.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create compacted session log: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	live := f.mem.List()
+	secrets := f.mem.secretList()
+	records := make([]logRecord, 0, len(live)+len(secrets))
+	for _, s := range live {
+		records = append(records, logRecord{Op: opPut, Session: s})
+	}
+	for _, s := range secrets {
+		records = append(records, logRecord{Op: opPutSecret, Secret: s})
+	}
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("encode compacted session log: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("write compacted session log: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("sync compacted session log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("close compacted session log: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("replace session log: %w", err)
+	}
+	syncDir(filepath.Dir(f.path))
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("reopen session log: %w", err)
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	f.records = len(records)
+	f.dirty = false
+	return nil
+}
+
+// syncDir fsyncs a directory so a rename within it is durable. Errors are
+// ignored: not every platform supports syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	_ = d.Sync()
+}
@@ -0,0 +1,192 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestFileStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestFileStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+
+	store := openTestFileStore(t, path)
+	_ = store.Register(&Session{Token: "keep", Provider: "anthropic", APIKey: "k1", SandboxID: "sb-1"})
+	_ = store.Register(&Session{Token: "drop", Provider: "openai", APIKey: "k2", SandboxID: "sb-2"})
+	_ = store.Revoke("drop")
+	store.Close()
+
+	reopened := openTestFileStore(t, path)
+	got, err := reopened.Lookup("keep")
+	if err != nil {
+		t.Fatalf("Lookup() after restart error = %v", err)
+	}
+	if got.APIKey != "k1" || got.SandboxID != "sb-1" {
+		t.Fatalf("Lookup() after restart = %+v", got)
+	}
+	if _, err := reopened.Lookup("drop"); err == nil {
+		t.Fatal("revoked session came back after restart")
+	}
+}
+
+func TestFileStore_RevokeBySandboxIDPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+
+	store := openTestFileStore(t, path)
+	_ = store.Register(&Session{Token: "a", SandboxID: "sb-1"})
+	_ = store.Register(&Session{Token: "b", SandboxID: "sb-1"})
+	_ = store.Register(&Session{Token: "c", SandboxID: "sb-2"})
+
+	if n := store.RevokeBySandboxID("sb-1"); n != 2 {
+		t.Fatalf("RevokeBySandboxID() = %d, want 2", n)
+	}
+	store.Close()
+
+	reopened := openTestFileStore(t, path)
+	if got := len(reopened.List()); got != 1 {
+		t.Fatalf("List() after restart returned %d sessions, want 1", got)
+	}
+	if _, err := reopened.Lookup("c"); err != nil {
+		t.Fatalf("Lookup(c) error = %v", err)
+	}
+}
+
+func TestFileStore_DiscardsTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+
+	store := openTestFileStore(t, path)
+	_ = store.Register(&Session{Token: "a", Provider: "anthropic"})
+	store.Close()
+
+	// Simulate a crash halfway through appending a record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"op":"put","session":{"token":"b"`)
+	f.Close()
+
+	reopened := openTestFileStore(t, path)
+	if _, err := reopened.Lookup("a"); err != nil {
+		t.Fatalf("Lookup(a) error = %v", err)
+	}
+	if _, err := reopened.Lookup("b"); err == nil {
+		t.Fatal("torn record should have been discarded")
+	}
+	if err := reopened.Register(&Session{Token: "c"}); err != nil {
+		t.Fatalf("Register() after recovery error = %v", err)
+	}
+}
+
+func TestFileStore_RejectsCorruptLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	if err := os.WriteFile(path, []byte("not json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileStore(path); err == nil {
+		t.Fatal("NewFileStore() expected error for corrupt log")
+	}
+}
+
+func TestFileStore_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	store := openTestFileStore(t, path)
+
+	for i := 0; i < 3*compactMinRecords; i++ {
+		_ = store.Register(&Session{Token: "churn", APIKey: fmt.Sprintf("k%d", i)})
+	}
+
+	store.mu.Lock()
+	records := store.records
+	store.mu.Unlock()
+	if records > 2*compactMinRecords {
+		t.Fatalf("log holds %d records, expected compaction to bound it", records)
+	}
+
+	got, err := store.Lookup("churn")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if want := fmt.Sprintf("k%d", 3*compactMinRecords-1); got.APIKey != want {
+		t.Fatalf("APIKey = %q, want %q", got.APIKey, want)
+	}
+}
+
+func TestFileStore_ConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	store := openTestFileStore(t, path)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				token := fmt.Sprintf("w%d-%d", w, i)
+				_ = store.Register(&Session{Token: token, SandboxID: fmt.Sprintf("sb-%d", w)})
+				_, _ = store.Lookup(token)
+			}
+		}(w)
+	}
+	wg.Wait()
+	store.RevokeBySandboxID("sb-0")
+	store.Close()
+
+	reopened := openTestFileStore(t, path)
+	if got := len(reopened.List()); got != 7*50 {
+		t.Fatalf("List() after restart returned %d sessions, want %d", got, 7*50)
+	}
+}
+
+func TestFileStore_ExpiredSessionsDroppedOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+
+	store := openTestFileStore(t, path)
+	_ = store.Register(&Session{Token: "old", ExpiresAt: time.Now().Add(-time.Minute)})
+	_ = store.Register(&Session{Token: "new", ExpiresAt: time.Now().Add(time.Hour)})
+	store.Close()
+
+	reopened := openTestFileStore(t, path)
+	if got := len(reopened.List()); got != 1 {
+		t.Fatalf("List() returned %d sessions, want 1", got)
+	}
+}
+
+func TestFileStore_SecretsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+
+	store := openTestFileStore(t, path)
+	_ = store.PutSecret("keep", []byte("v1"))
+	_ = store.PutSecret("keep", []byte("v2"))
+	_ = store.PutSecret("drop", []byte("gone"))
+	_ = store.DeleteSecret("drop")
+	store.Close()
+
+	for _, compact := range []bool{false, true} {
+		reopened := openTestFileStore(t, path)
+		if compact {
+			_ = reopened.Compact()
+		}
+		secrets, err := reopened.Secrets()
+		if err != nil {
+			t.Fatalf("Secrets() error = %v", err)
+		}
+		if len(secrets) != 1 || string(secrets["keep"]) != "v2" {
+			t.Fatalf("Secrets() after restart = %q, want keep=v2 only", secrets)
+		}
+		reopened.Close()
+	}
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenHasher(t *testing.T) {
+	a := NewTokenHasher([]byte("secret-a"))
+	b := NewTokenHasher([]byte("secret-b"))
+
+	if a.Hash("session-abc") != a.Hash("session-abc") {
+		t.Fatal("Hash() is not deterministic")
+	}
+	if a.Hash("session-abc") == "session-abc" {
+		t.Fatal("Hash() returned the raw token")
+	}
+	if a.Hash("session-abc") == b.Hash("session-abc") {
+		t.Fatal("Hash() should depend on the secret")
+	}
+	if a.Hash("session-abc") == a.Hash("abc") {
+		t.Fatal("Hash() collided for different tokens")
+	}
+}
+
+func TestTokenHasher_NilIsIdentity(t *testing.T) {
+	var h *TokenHasher
+	if got := h.Hash("session-abc"); got != "session-abc" {
+		t.Fatalf("nil Hash() = %q, want raw token", got)
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	a, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+	b, _ := NewToken()
+
+	if !strings.HasPrefix(a, "session-") || len(a) != len("session-")+64 {
+		t.Fatalf("NewToken() = %q, want session-<64 hex>", a)
+	}
+	if a == b {
+		t.Fatal("NewToken() returned the same token twice")
+	}
+}
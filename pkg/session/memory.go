@@ -3,50 +3,78 @@ package session
 import (
 	"fmt"
 	"sync"
+	"time"
 )
 
 // MemoryStore is a thread-safe in-memory session store.
 type MemoryStore struct {
 	mu       sync.RWMutex
 	sessions map[string]*Session
+
+	// bySandbox indexes session tokens by sandbox ID so sandbox-wide
+	// revocation doesn't have to scan every session.
+	bySandbox map[string]map[string]struct{}
+
+	// secrets holds named secrets kept alongside sessions; see SecretStore.
+	secrets map[string]*secret
+
+	// now is the clock used for expiry checks. Overridden in tests.
+	now func() time.Time
 }
 
 // NewMemoryStore creates a new empty in-memory session store.
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		sessions: make(map[string]*Session),
+		sessions:  make(map[string]*Session),
+		bySandbox: make(map[string]map[string]struct{}),
+		secrets:   make(map[string]*secret),
+		now:       time.Now,
 	}
 }
 
-// Register adds or updates a session in the store.
+// Register adds or updates a session in the store. The store keeps its own
+// copy, so later changes to s are not observed.
 func (m *MemoryStore) Register(s *Session) error {
 	if s.Token == "" {
 		return fmt.Errorf("session token cannot be empty")
 	}
 
+	stored := *s
+	m.stamp(&stored)
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.sessions[s.Token] = s
+	m.put(&stored)
 	return nil
 }
 
-// Lookup retrieves a session by token.
+// Lookup retrieves a session by token. Expired sessions are evicted and
+// reported as ErrExpired; successful lookups refresh the idle timer.
 func (m *MemoryStore) Lookup(token string) (*Session, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	s, ok := m.sessions[token]
 	if !ok {
-		return nil, fmt.Errorf("session not found: %s", token)
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, token)
 	}
-	return s, nil
+
+	now := m.now()
+	if s.Expired(now) {
+		m.remove(token)
+		return nil, fmt.Errorf("%w: %s", ErrExpired, token)
+	}
+	s.LastUsed = now
+
+	found := *s
+	return &found, nil
 }
 
 // Revoke removes a session from the store.
 func (m *MemoryStore) Revoke(token string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.sessions, token)
+	m.remove(token)
 	return nil
 }
 
@@ -57,23 +85,99 @@ func (m *MemoryStore) RevokeBySandboxID(sandboxID string) int {
 	defer m.mu.Unlock()
 
 	revoked := 0
-	for token, sess := range m.sessions {
-		if sess.SandboxID == sandboxID {
-			delete(m.sessions, token)
-			revoked++
-		}
+	for token := range m.bySandbox[sandboxID] {
+		m.remove(token)
+		revoked++
 	}
 	return revoked
 }
 
-// List returns all registered sessions.
+// List returns all registered sessions that have not yet expired.
 func (m *MemoryStore) List() []*Session {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	now := m.now()
 	result := make([]*Session, 0, len(m.sessions))
 	for _, s := range m.sessions {
-		result = append(result, s)
+		if s.Expired(now) {
+			continue
+		}
+		found := *s
+		result = append(result, &found)
 	}
 	return result
 }
+
+// Reap evicts every expired session and returns how many were removed.
+func (m *MemoryStore) Reap() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	reaped := 0
+	for token, s := range m.sessions {
+		if s.Expired(now) {
+			m.remove(token)
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// len returns the number of sessions currently held, expired or not, plus
+// the number of secrets.
+func (m *MemoryStore) len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sessions) + len(m.secrets)
+}
+
+// stamp fills in the bookkeeping timestamps of a newly registered session.
+func (m *MemoryStore) stamp(s *Session) {
+	now := m.now()
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = now
+	}
+	if s.LastUsed.IsZero() {
+		s.LastUsed = now
+	}
+}
+
+// put stores s and updates the sandbox index. Callers must hold m.mu.
+func (m *MemoryStore) put(s *Session) {
+	if old, ok := m.sessions[s.Token]; ok && old.SandboxID != s.SandboxID {
+		m.unindex(old)
+	}
+	m.sessions[s.Token] = s
+	if s.SandboxID != "" {
+		tokens, ok := m.bySandbox[s.SandboxID]
+		if !ok {
+			tokens = make(map[string]struct{})
+			m.bySandbox[s.SandboxID] = tokens
+		}
+		tokens[s.Token] = struct{}{}
+	}
+}
+
+// remove deletes a session and its index entry. Callers must hold m.mu.
+func (m *MemoryStore) remove(token string) {
+	s, ok := m.sessions[token]
+	if !ok {
+		return
+	}
+	delete(m.sessions, token)
+	m.unindex(s)
+}
+
+// unindex drops s from the sandbox index. Callers must hold m.mu.
+func (m *MemoryStore) unindex(s *Session) {
+	tokens, ok := m.bySandbox[s.SandboxID]
+	if !ok {
+		return
+	}
+	delete(tokens, s.Token)
+	if len(tokens) == 0 {
+		delete(m.bySandbox, s.SandboxID)
+	}
+}
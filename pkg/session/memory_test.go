@@ -1,7 +1,9 @@
 package session
 
 import (
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestMemoryStore_RegisterAndLookup(t *testing.T) {
@@ -93,3 +95,109 @@ func TestMemoryStore_RegisterOverwrite(t *testing.T) {
 		t.Errorf("APIKey = %q, want %q after overwrite", got.APIKey, "new-key")
 	}
 }
+
+// fakeClock is a manually advanced clock for expiry tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newClockedStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestMemoryStore_LookupRejectsExpired(t *testing.T) {
+	store, clock := newClockedStore()
+
+	_ = store.Register(&Session{Token: "t1", Provider: "anthropic", APIKey: "k", ExpiresAt: clock.t.Add(time.Minute)})
+
+	if _, err := store.Lookup("t1"); err != nil {
+		t.Fatalf("Lookup() before deadline error = %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	_, err := store.Lookup("t1")
+	if !errors.Is(err, ErrExpired) {
+		t.Fatalf("Lookup() after deadline error = %v, want ErrExpired", err)
+	}
+
+	// The expired session is evicted on first rejection.
+	_, err = store.Lookup("t1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second Lookup() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStore_IdleTimeout(t *testing.T) {
+	store, clock := newClockedStore()
+
+	_ = store.Register(&Session{Token: "t1", Provider: "anthropic", APIKey: "k", IdleTimeout: 10 * time.Second})
+
+	// Each lookup within the idle window pushes the deadline out.
+	for i := 0; i < 3; i++ {
+		clock.Advance(9 * time.Second)
+		if _, err := store.Lookup("t1"); err != nil {
+			t.Fatalf("Lookup() #%d error = %v", i, err)
+		}
+	}
+
+	clock.Advance(10 * time.Second)
+	if _, err := store.Lookup("t1"); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Lookup() after idle timeout error = %v, want ErrExpired", err)
+	}
+}
+
+func TestMemoryStore_Reap(t *testing.T) {
+	store, clock := newClockedStore()
+
+	_ = store.Register(&Session{Token: "short", ExpiresAt: clock.t.Add(time.Second)})
+	_ = store.Register(&Session{Token: "idle", IdleTimeout: 2 * time.Second})
+	_ = store.Register(&Session{Token: "forever"})
+
+	if n := store.Reap(); n != 0 {
+		t.Fatalf("Reap() = %d before any deadline, want 0", n)
+	}
+
+	clock.Advance(5 * time.Second)
+	if n := store.Reap(); n != 2 {
+		t.Fatalf("Reap() = %d, want 2", n)
+	}
+	if got := len(store.List()); got != 1 {
+		t.Fatalf("List() returned %d sessions after reap, want 1", got)
+	}
+}
+
+func TestMemoryStore_ListSkipsExpired(t *testing.T) {
+	store, clock := newClockedStore()
+
+	_ = store.Register(&Session{Token: "a", ExpiresAt: clock.t.Add(time.Second)})
+	_ = store.Register(&Session{Token: "b"})
+
+	clock.Advance(time.Second)
+	if got := len(store.List()); got != 1 {
+		t.Fatalf("List() returned %d sessions, want 1", got)
+	}
+}
+
+func TestStartReaper(t *testing.T) {
+	store, clock := newClockedStore()
+	_ = store.Register(&Session{Token: "a", ExpiresAt: clock.t.Add(time.Second)})
+	clock.Advance(time.Second)
+
+	reaped := make(chan int, 1)
+	stop := StartReaper(store, time.Millisecond, func(n int) { reaped <- n })
+	defer stop()
+
+	select {
+	case n := <-reaped:
+		if n != 1 {
+			t.Fatalf("reaper reported %d, want 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not run")
+	}
+}
@@ -0,0 +1,32 @@
+package session
+
+// AzureOpenAI holds the settings of a session using the azure-openai
+// provider. Azure serves each resource from its own hostname and each
+// model from a named deployment.
+type AzureOpenAI struct {
+	// Resource is the Azure OpenAI resource name, the first label of
+	// https://{resource}.openai.azure.com.
+	Resource string `json:"resource,omitempty"`
+
+	// Deployments maps model names sent by the sandbox to deployment
+	// names. Models not listed are assumed to be deployed under their own
+	// name.
+	Deployments map[string]string `json:"deployments,omitempty"`
+
+	// APIVersion is the api-version query parameter sent when the sandbox
+	// sends none. Empty uses the provider default.
+	APIVersion string `json:"api_version,omitempty"`
+}
+
+// Bedrock holds the settings of a session using the bedrock provider. The
+// AWS credentials themselves are the session's API key (or catalog
+// credential), "<access key id>:<secret access key>[:<session token>]",
+// so they are sealed and pooled like any other key.
+type Bedrock struct {
+	// Region is the AWS region requests are sent to and signed for.
+	Region string `json:"region"`
+
+	// Models maps Anthropic model names sent by the sandbox to Bedrock
+	// model or inference profile IDs. Models not listed are sent as is.
+	Models map[string]string `json:"models,omitempty"`
+}
@@ -0,0 +1,37 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Reaper is implemented by stores that can evict expired sessions.
+type Reaper interface {
+	// Reap removes every expired session and returns how many were removed.
+	Reap() int
+}
+
+// StartReaper runs r.Reap every interval in a background goroutine until
+// the returned stop function is called. onReap, if non-nil, is called with
+// the eviction count of every pass that removed at least one session.
+func StartReaper(r Reaper, interval time.Duration, onReap func(n int)) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if n := r.Reap(); n > 0 && onReap != nil {
+					onReap(n)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,97 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+
+	"llm-proxy/pkg/keyring"
+)
+
+// SecretStore is implemented by stores that also keep named secrets, such
+// as the credential catalog's API keys, alongside sessions. Secrets share
+// the store's durability and, through EncryptedStore, its encryption at
+// rest.
+type SecretStore interface {
+	// PutSecret adds or replaces a secret.
+	PutSecret(id string, value []byte) error
+
+	// DeleteSecret removes a secret. Deleting an unknown ID is not an
+	// error.
+	DeleteSecret(id string) error
+
+	// Secrets returns every stored secret by ID.
+	Secrets() (map[string][]byte, error)
+}
+
+// secret is a stored named secret. Exactly one of Value and Sealed is
+// set, mirroring Session.APIKey and Session.SealedAPIKey.
+type secret struct {
+	ID     string          `json:"id"`
+	Value  []byte          `json:"value,omitempty"`
+	Sealed *keyring.Sealed `json:"sealed,omitempty"`
+}
+
+// secretRecords gives EncryptedStore access to the stored form of secrets
+// so it can keep them sealed in the inner store.
+type secretRecords interface {
+	putSecret(s *secret) error
+	DeleteSecret(id string) error
+	secretList() []*secret
+}
+
+// plainSecrets returns the values of unsealed secrets. A sealed secret
+// means the store was written with encryption enabled and must be opened
+// through EncryptedStore.
+func plainSecrets(list []*secret) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(list))
+	for _, s := range list {
+		if s.Sealed != nil {
+			return nil, fmt.Errorf("secret %s is sealed and no master key is configured", s.ID)
+		}
+		values[s.ID] = s.Value
+	}
+	return values, nil
+}
+
+// PutSecret stores a secret in memory.
+func (m *MemoryStore) PutSecret(id string, value []byte) error {
+	return m.putSecret(&secret{ID: id, Value: bytes.Clone(value)})
+}
+
+func (m *MemoryStore) putSecret(s *secret) error {
+	if s.ID == "" {
+		return fmt.Errorf("secret id cannot be empty")
+	}
+	stored := *s
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secrets[s.ID] = &stored
+	return nil
+}
+
+// DeleteSecret removes a secret.
+func (m *MemoryStore) DeleteSecret(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.secrets, id)
+	return nil
+}
+
+// Secrets returns every stored secret by ID.
+func (m *MemoryStore) Secrets() (map[string][]byte, error) {
+	return plainSecrets(m.secretList())
+}
+
+// secretList returns copies of every stored secret.
+func (m *MemoryStore) secretList() []*secret {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]*secret, 0, len(m.secrets))
+	for _, s := range m.secrets {
+		found := *s
+		result = append(result, &found)
+	}
+	return result
+}
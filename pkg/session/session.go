@@ -3,23 +3,132 @@
 // the proxy validates tokens on every LLM request.
 package session
 
+import (
+	"errors"
+	"time"
+
+	"llm-proxy/pkg/budget"
+	"llm-proxy/pkg/keyring"
+	"llm-proxy/pkg/ratelimit"
+)
+
+var (
+	// ErrNotFound is returned by Lookup when no session matches the token.
+	ErrNotFound = errors.New("session not found")
+
+	// ErrExpired is returned by Lookup when the session exists but has
+	// passed its deadline or idle timeout.
+	ErrExpired = errors.New("session expired")
+)
+
 // Session represents a registered sandbox session with its credentials.
+// The JSON form is used by persistent stores and is never sent to clients.
 type Session struct {
 	// Token is the session-scoped token the sandbox uses to authenticate.
-	Token string
+	Token string `json:"token"`
 
-	// Provider is the LLM provider name ("anthropic", "openai", "ollama").
-	Provider string
+	// Provider is the LLM provider name ("anthropic", "openai", "ollama",
+	// "azure-openai", "bedrock", ...).
+	Provider string `json:"provider"`
 
 	// APIKey is the real API key for the provider. Never sent to the sandbox.
-	APIKey string
+	APIKey string `json:"api_key,omitempty"`
+
+	// SealedAPIKey is the envelope-encrypted API key, set instead of APIKey
+	// when the store encrypts keys at rest. See EncryptedStore.
+	SealedAPIKey *keyring.Sealed `json:"sealed_api_key,omitempty"`
+
+	// CredentialID references a credential catalog entry holding the real
+	// API key, used instead of APIKey.
+	CredentialID string `json:"credential_id,omitempty"`
 
 	// UpstreamURL is the provider API base URL. If empty, the default for
 	// the provider is used.
-	UpstreamURL string
+	UpstreamURL string `json:"upstream_url,omitempty"`
+
+	// UpstreamURLs lists base URLs tried in order, failing over on
+	// connection errors and 5xx. Used instead of UpstreamURL.
+	UpstreamURLs []string `json:"upstream_urls,omitempty"`
+
+	// UpstreamCredentials maps an upstream base URL to the catalog
+	// credential sent to it in place of the session's own key. The
+	// session's key only goes to the first upstream's host, so every
+	// upstream on another host needs an entry.
+	UpstreamCredentials map[string]string `json:"upstream_credentials,omitempty"`
 
 	// SandboxID is the identifier of the sandbox this session belongs to.
-	SandboxID string
+	SandboxID string `json:"sandbox_id,omitempty"`
+
+	// Azure holds the settings of azure-openai sessions.
+	Azure *AzureOpenAI `json:"azure,omitempty"`
+
+	// Bedrock holds the settings of bedrock sessions.
+	Bedrock *Bedrock `json:"bedrock,omitempty"`
+
+	// CreatedAt is when the session was first registered. Set by the store
+	// if left zero.
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is the absolute deadline after which the session is
+	// rejected. Zero means no deadline.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// IdleTimeout expires the session if it is not used for this long.
+	// Zero disables the idle timeout.
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+
+	// Limits caps the request rate and concurrency of this session.
+	Limits *ratelimit.Limits `json:"limits,omitempty"`
+
+	// SandboxLimits caps the combined traffic of every session belonging
+	// to SandboxID.
+	SandboxLimits *ratelimit.Limits `json:"sandbox_limits,omitempty"`
+
+	// Budget caps the tokens or dollars this session may spend.
+	Budget *budget.Limits `json:"budget,omitempty"`
+
+	// SandboxBudget caps the combined spend of every session belonging to
+	// SandboxID.
+	SandboxBudget *budget.Limits `json:"sandbox_budget,omitempty"`
+
+	// Capture archives this session's requests and responses for replay.
+	Capture bool `json:"capture,omitempty"`
+
+	// LastUsed is when the session last passed a Lookup. Maintained by
+	// the store.
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Upstreams returns the session's upstream base URLs in failover order, or
+// nil if the provider's defaults apply.
+func (s *Session) Upstreams() []string {
+	if len(s.UpstreamURLs) > 0 {
+		return s.UpstreamURLs
+	}
+	if s.UpstreamURL != "" {
+		return []string{s.UpstreamURL}
+	}
+	return nil
+}
+
+// Deadline returns the earliest point at which the session expires, taking
+// both the absolute deadline and the idle timeout into account. The zero
+// time means the session never expires.
+func (s *Session) Deadline() time.Time {
+	deadline := s.ExpiresAt
+	if s.IdleTimeout > 0 {
+		idle := s.LastUsed.Add(s.IdleTimeout)
+		if deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	return deadline
+}
+
+// Expired reports whether the session is past its deadline at now.
+func (s *Session) Expired(now time.Time) bool {
+	deadline := s.Deadline()
+	return !deadline.IsZero() && !now.Before(deadline)
 }
 
 // Store defines the interface for session management.
@@ -27,7 +136,8 @@ type Store interface {
 	// Register adds or updates a session in the store.
 	Register(s *Session) error
 
-	// Lookup retrieves a session by token. Returns an error if not found.
+	// Lookup retrieves a session by token. Returns an error if not found
+	// or expired.
 	Lookup(token string) (*Session, error)
 
 	// Revoke removes a session from the store.
@@ -40,3 +150,19 @@ type Store interface {
 	// List returns all registered sessions.
 	List() []*Session
 }
+
+// KeyOpener is implemented by stores that hold API keys sealed. The proxy
+// uses it to recover the real key immediately before injecting it.
+type KeyOpener interface {
+	// OpenAPIKey decrypts the sealed API key of a session returned by
+	// Lookup.
+	OpenAPIKey(s *Session) (string, error)
+}
+
+// KeyRotator is implemented by stores that can rotate their master key.
+type KeyRotator interface {
+	// RotateMasterKey reloads the master key from the configured source,
+	// re-wraps every stored session and secret, and returns the new key ID
+	// and the number of records re-wrapped.
+	RotateMasterKey() (keyID string, rewrapped int, err error)
+}
@@ -0,0 +1,193 @@
+package signedtoken
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend persists revocations so they survive restarts. The session
+// stores implement it, keeping revocations alongside the credential
+// catalog's secrets.
+type Backend interface {
+	PutSecret(id string, value []byte) error
+	DeleteSecret(id string) error
+	Secrets() (map[string][]byte, error)
+}
+
+// Backend secret ID prefixes for revoked token IDs and sandboxes. The
+// value is the entry's time in RFC 3339 form.
+const (
+	revokedIDPrefix      = "revoked-jti/"
+	revokedSandboxPrefix = "revoked-sandbox/"
+)
+
+// RevocationList records signed tokens killed before their expiry, either
+// individually by jti or wholesale by sandbox ID.
+type RevocationList struct {
+	maxTTL time.Duration
+
+	mu sync.RWMutex
+	// ids maps a revoked jti to the time after which the token would have
+	// expired anyway and the entry can be dropped.
+	ids map[string]time.Time
+	// sandboxes maps a sandbox ID to the revocation time; every token for
+	// that sandbox issued at or before it is revoked.
+	sandboxes map[string]time.Time
+	backend   Backend
+
+	// now is the clock used for pruning. Overridden in tests.
+	now func() time.Time
+}
+
+// NewRevocationList creates an empty revocation list. Sandbox revocations
+// are kept for maxTTL, the longest lifetime a verifier accepts; zero keeps
+// them forever.
+func NewRevocationList(maxTTL time.Duration) *RevocationList {
+	return &RevocationList{
+		maxTTL:    maxTTL,
+		ids:       make(map[string]time.Time),
+		sandboxes: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// RevokeID revokes a single token. until is the token's expiry; if zero,
+// the entry is kept for the maximum token lifetime.
+func (l *RevocationList) RevokeID(jti string, until time.Time) error {
+	now := l.now()
+	if until.IsZero() {
+		until = l.horizon(now)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.store(revokedIDPrefix+jti, until); err != nil {
+		return err
+	}
+	l.ids[jti] = until
+	l.prune(now)
+	return nil
+}
+
+// RevokeSandbox revokes every token issued to sandboxID up to now.
+func (l *RevocationList) RevokeSandbox(sandboxID string) error {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.store(revokedSandboxPrefix+sandboxID, now); err != nil {
+		return err
+	}
+	l.sandboxes[sandboxID] = now
+	l.prune(now)
+	return nil
+}
+
+// Persist loads the revocations stored in backend and from then on writes
+// every revocation through to backend before applying it. Entries past
+// the pruning horizon are dropped from backend as well.
+func (l *RevocationList) Persist(backend Backend) error {
+	secrets, err := backend.Secrets()
+	if err != nil {
+		return fmt.Errorf("load stored revocations: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for id, value := range secrets {
+		var entries map[string]time.Time
+		var key string
+		switch {
+		case strings.HasPrefix(id, revokedIDPrefix):
+			entries, key = l.ids, strings.TrimPrefix(id, revokedIDPrefix)
+		case strings.HasPrefix(id, revokedSandboxPrefix):
+			entries, key = l.sandboxes, strings.TrimPrefix(id, revokedSandboxPrefix)
+		default:
+			continue
+		}
+		var at time.Time
+		if err := at.UnmarshalText(value); err != nil {
+			return fmt.Errorf("decode stored revocation %s: %w", id, err)
+		}
+		entries[key] = at
+	}
+	l.backend = backend
+	l.prune(l.now())
+	return nil
+}
+
+// store writes an entry through to the backend, if any. Callers must
+// hold l.mu.
+func (l *RevocationList) store(id string, at time.Time) error {
+	if l.backend == nil {
+		return nil
+	}
+	value, err := at.MarshalText()
+	if err != nil {
+		return fmt.Errorf("encode revocation: %w", err)
+	}
+	if err := l.backend.PutSecret(id, value); err != nil {
+		return fmt.Errorf("store revocation: %w", err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether the token described by c has been revoked.
+func (l *RevocationList) IsRevoked(c *Claims) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if _, ok := l.ids[c.ID]; ok {
+		return true
+	}
+	if at, ok := l.sandboxes[c.SandboxID]; ok && c.SandboxID != "" {
+		return !time.Unix(c.IssuedAt, 0).After(at)
+	}
+	return false
+}
+
+// Len returns the number of individual and sandbox-wide revocations held.
+func (l *RevocationList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.ids) + len(l.sandboxes)
+}
+
+// horizon is how long an entry must be remembered when the token's own
+// expiry isn't known.
+func (l *RevocationList) horizon(now time.Time) time.Time {
+	if l.maxTTL == 0 {
+		return time.Time{}
+	}
+	return now.Add(l.maxTTL + Leeway)
+}
+
+// prune drops entries for tokens that can no longer verify. Callers must
+// hold l.mu.
+func (l *RevocationList) prune(now time.Time) {
+	for jti, until := range l.ids {
+		if !until.IsZero() && now.After(until.Add(Leeway)) {
+			l.forget(l.ids, revokedIDPrefix, jti)
+		}
+	}
+	if l.maxTTL == 0 {
+		return
+	}
+	for id, at := range l.sandboxes {
+		if now.After(at.Add(l.maxTTL + Leeway)) {
+			l.forget(l.sandboxes, revokedSandboxPrefix, id)
+		}
+	}
+}
+
+// forget drops a pruned entry. An entry the backend fails to delete is
+// harmless: it is loaded and pruned again at the next start.
+func (l *RevocationList) forget(entries map[string]time.Time, prefix, key string) {
+	delete(entries, key)
+	if l.backend != nil {
+		_ = l.backend.DeleteSecret(prefix + key)
+	}
+}
@@ -0,0 +1,211 @@
+// Package signedtoken verifies stateless sandbox tokens. A signed token is a
+// compact JWT (alg EdDSA) minted by the control plane that carries the
+// provider, sandbox ID, expiry, and a reference to a catalog credential --
+// never the API key itself -- so sandboxes can be issued credentials
+// without registering a session with the proxy.
+package signedtoken
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrRevoked is returned by Verify for tokens on the revocation list.
+var ErrRevoked = errors.New("token revoked")
+
+// Leeway is the clock skew tolerated when checking exp, nbf and iat.
+const Leeway = 30 * time.Second
+
+// Claims is the payload of a signed sandbox token.
+type Claims struct {
+	// ID uniquely identifies the token for revocation.
+	ID string `json:"jti"`
+
+	// Provider is the LLM provider the token grants access to.
+	Provider string `json:"provider"`
+
+	// SandboxID is the sandbox the token was issued to.
+	SandboxID string `json:"sandbox_id,omitempty"`
+
+	// KeyRef is the credential catalog ID of the real API key.
+	KeyRef string `json:"key_ref"`
+
+	IssuedAt  int64 `json:"iat"`
+	NotBefore int64 `json:"nbf,omitempty"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// header is the JOSE header of a signed token.
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// LooksSigned reports whether token has the shape of a compact JWT, as
+// opposed to an opaque registered session token.
+func LooksSigned(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// Sign produces a compact EdDSA JWT for claims. kid, if non-empty, selects
+// the verifying key on the proxy side.
+func Sign(claims *Claims, kid string, key ed25519.PrivateKey) (string, error) {
+	h, err := json.Marshal(header{Alg: "EdDSA", Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(h) + "." + encode(payload)
+	sig := ed25519.Sign(key, []byte(signingInput))
+	return signingInput + "." + encode(sig), nil
+}
+
+// Verifier checks token signatures against a set of trusted public keys.
+type Verifier struct {
+	keys    map[string]ed25519.PublicKey
+	revoked *RevocationList
+	maxTTL  time.Duration
+
+	// now is the clock used for time-based claims. Overridden in tests.
+	now func() time.Time
+}
+
+// NewVerifier creates a verifier trusting keys (indexed by kid). Tokens
+// whose lifetime (exp - iat) exceeds maxTTL are rejected, which bounds how
+// long sandbox-wide revocations must be remembered.
+func NewVerifier(keys map[string]ed25519.PublicKey, maxTTL time.Duration) *Verifier {
+	return &Verifier{
+		keys:    keys,
+		revoked: NewRevocationList(maxTTL),
+		maxTTL:  maxTTL,
+		now:     time.Now,
+	}
+}
+
+// Revocations returns the verifier's revocation list.
+func (v *Verifier) Revocations() *RevocationList {
+	return v.revoked
+}
+
+// Verify checks the signature, time bounds and revocation status of token
+// and returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("decode token header: %w", err)
+	}
+	if h.Alg != "EdDSA" {
+		return nil, fmt.Errorf("unsupported token algorithm %q", h.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode token signature: %w", err)
+	}
+	if !v.verifySignature(h.Kid, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decode token claims: %w", err)
+	}
+	if err := v.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+	if v.revoked.IsRevoked(&claims) {
+		return nil, ErrRevoked
+	}
+	return &claims, nil
+}
+
+// verifySignature checks sig against the key named by kid, or against
+// every trusted key if the token carries no kid.
+func (v *Verifier) verifySignature(kid string, signingInput, sig []byte) bool {
+	if kid != "" {
+		key, ok := v.keys[kid]
+		return ok && ed25519.Verify(key, signingInput, sig)
+	}
+	for _, key := range v.keys {
+		if ed25519.Verify(key, signingInput, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) checkClaims(c *Claims) error {
+	now := v.now()
+	if c.ExpiresAt == 0 || c.IssuedAt == 0 {
+		return fmt.Errorf("token must carry iat and exp")
+	}
+	if c.ID == "" || c.Provider == "" || c.KeyRef == "" {
+		return fmt.Errorf("token must carry jti, provider and key_ref")
+	}
+	if !now.Before(time.Unix(c.ExpiresAt, 0).Add(Leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	// A token dated ahead would otherwise outlive maxTTL and escape
+	// sandbox revocations, which only cover tokens issued before them.
+	if now.Add(Leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return fmt.Errorf("token issued in the future")
+	}
+	if c.NotBefore != 0 && now.Add(Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("token not yet valid")
+	}
+	if v.maxTTL > 0 && time.Duration(c.ExpiresAt-c.IssuedAt)*time.Second > v.maxTTL {
+		return fmt.Errorf("token lifetime exceeds %s", v.maxTTL)
+	}
+	return nil
+}
+
+// LoadPublicKey reads a PEM-encoded Ed25519 public key ("PUBLIC KEY" block).
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+	return key, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJSON(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,248 @@
+package signedtoken
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func newTestVerifier(keys map[string]ed25519.PublicKey) *Verifier {
+	v := NewVerifier(keys, time.Hour)
+	v.now = func() time.Time { return testNow }
+	v.revoked.now = v.now
+	return v
+}
+
+func validClaims() *Claims {
+	return &Claims{
+		ID:        "tok-1",
+		Provider:  "anthropic",
+		SandboxID: "sandbox-a",
+		KeyRef:    "anthropic-main",
+		IssuedAt:  testNow.Add(-time.Minute).Unix(),
+		ExpiresAt: testNow.Add(10 * time.Minute).Unix(),
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	pub, priv := newTestKey(t)
+	v := newTestVerifier(map[string]ed25519.PublicKey{"k1": pub})
+
+	token, err := Sign(validClaims(), "k1", priv)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if !LooksSigned(token) {
+		t.Fatalf("LooksSigned(%q) = false", token)
+	}
+
+	claims, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if claims.KeyRef != "anthropic-main" || claims.SandboxID != "sandbox-a" {
+		t.Fatalf("Verify() claims = %+v", claims)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	pub, priv := newTestKey(t)
+	_, otherPriv := newTestKey(t)
+	v := newTestVerifier(map[string]ed25519.PublicKey{"k1": pub})
+
+	sign := func(mutate func(*Claims), kid string, key ed25519.PrivateKey) string {
+		c := validClaims()
+		if mutate != nil {
+			mutate(c)
+		}
+		token, err := Sign(c, kid, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	good := sign(nil, "k1", priv)
+	parts := strings.Split(good, ".")
+	forged := sign(func(c *Claims) { c.KeyRef = "someone-elses-key" }, "k1", otherPriv)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong signing key", forged},
+		{"unknown kid", sign(nil, "k2", priv)},
+		{"expired", sign(func(c *Claims) { c.ExpiresAt = testNow.Add(-time.Minute).Unix() }, "k1", priv)},
+		{"not yet valid", sign(func(c *Claims) { c.NotBefore = testNow.Add(time.Minute).Unix() }, "k1", priv)},
+		{"issued in the future", sign(func(c *Claims) { c.IssuedAt = testNow.Add(time.Minute).Unix() }, "k1", priv)},
+		{"lifetime over max", sign(func(c *Claims) { c.ExpiresAt = testNow.Add(2 * time.Hour).Unix() }, "k1", priv)},
+		{"missing key_ref", sign(func(c *Claims) { c.KeyRef = "" }, "k1", priv)},
+		{"swapped payload", parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]},
+		{"alg none", "eyJhbGciOiJub25lIn0." + parts[1] + "."},
+		{"garbage", "eyJ.not.valid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(tt.token); err == nil {
+				t.Fatal("Verify() expected error")
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutKid(t *testing.T) {
+	pubA, _ := newTestKey(t)
+	pubB, privB := newTestKey(t)
+	v := newTestVerifier(map[string]ed25519.PublicKey{"a": pubA, "b": pubB})
+
+	token, _ := Sign(validClaims(), "", privB)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify() without kid error = %v", err)
+	}
+}
+
+func TestRevocation(t *testing.T) {
+	pub, priv := newTestKey(t)
+	v := newTestVerifier(map[string]ed25519.PublicKey{"k1": pub})
+
+	first, _ := Sign(validClaims(), "k1", priv)
+	v.Revocations().RevokeID("tok-1", time.Unix(validClaims().ExpiresAt, 0))
+	if _, err := v.Verify(first); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Verify() revoked jti error = %v, want ErrRevoked", err)
+	}
+
+	other := validClaims()
+	other.ID = "tok-2"
+	second, _ := Sign(other, "k1", priv)
+	if _, err := v.Verify(second); err != nil {
+		t.Fatalf("Verify() unrelated token error = %v", err)
+	}
+
+	v.Revocations().RevokeSandbox("sandbox-a")
+	if _, err := v.Verify(second); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Verify() after sandbox revocation error = %v, want ErrRevoked", err)
+	}
+
+	// Tokens issued after the sandbox revocation are accepted again.
+	later := validClaims()
+	later.ID = "tok-3"
+	later.IssuedAt = testNow.Add(time.Second).Unix()
+	third, _ := Sign(later, "k1", priv)
+	if _, err := v.Verify(third); err != nil {
+		t.Fatalf("Verify() token issued after revocation error = %v", err)
+	}
+
+	// Dating a token ahead of the revocation does not revive the sandbox.
+	ahead := validClaims()
+	ahead.ID = "tok-4"
+	ahead.IssuedAt = testNow.Add(30 * time.Minute).Unix()
+	ahead.ExpiresAt = testNow.Add(80 * time.Minute).Unix()
+	fourth, _ := Sign(ahead, "k1", priv)
+	if _, err := v.Verify(fourth); err == nil {
+		t.Fatal("Verify() accepted a token issued in the future")
+	}
+}
+
+func TestRevocationPrune(t *testing.T) {
+	now := testNow
+	l := NewRevocationList(time.Hour)
+	l.now = func() time.Time { return now }
+
+	l.RevokeID("short", now.Add(time.Minute))
+	l.RevokeSandbox("sandbox-a")
+	if l.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", l.Len())
+	}
+
+	now = now.Add(2 * time.Hour)
+	l.RevokeID("fresh", now.Add(time.Minute))
+	if l.Len() != 1 {
+		t.Fatalf("Len() after prune = %d, want 1", l.Len())
+	}
+}
+
+// mapBackend is an in-memory Backend.
+type mapBackend map[string][]byte
+
+func (b mapBackend) PutSecret(id string, value []byte) error { b[id] = value; return nil }
+func (b mapBackend) DeleteSecret(id string) error            { delete(b, id); return nil }
+func (b mapBackend) Secrets() (map[string][]byte, error)     { return b, nil }
+
+func TestRevocationPersist(t *testing.T) {
+	now := testNow
+	backend := mapBackend{"credential/other": []byte("{}")}
+	l := NewRevocationList(time.Hour)
+	l.now = func() time.Time { return now }
+	if err := l.Persist(backend); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	if err := l.RevokeID("tok-1", now.Add(10*time.Minute)); err != nil {
+		t.Fatalf("RevokeID() error = %v", err)
+	}
+	if err := l.RevokeSandbox("sandbox-a"); err != nil {
+		t.Fatalf("RevokeSandbox() error = %v", err)
+	}
+
+	// A restarted process reloads both revocations.
+	reloaded := NewRevocationList(time.Hour)
+	reloaded.now = l.now
+	if err := reloaded.Persist(backend); err != nil {
+		t.Fatalf("Persist() reload error = %v", err)
+	}
+	if !reloaded.IsRevoked(&Claims{ID: "tok-1"}) {
+		t.Fatal("revoked jti not reloaded")
+	}
+	if !reloaded.IsRevoked(&Claims{ID: "tok-2", SandboxID: "sandbox-a", IssuedAt: now.Add(-time.Minute).Unix()}) {
+		t.Fatal("revoked sandbox not reloaded")
+	}
+
+	// Entries past the horizon are dropped from the backend on load.
+	now = now.Add(2 * time.Hour)
+	reloaded = NewRevocationList(time.Hour)
+	reloaded.now = l.now
+	if err := reloaded.Persist(backend); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Len() != 0 || len(backend) != 1 {
+		t.Fatalf("after horizon: Len() = %d, backend = %v", reloaded.Len(), backend)
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	pub, _ := newTestKey(t)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signer.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadPublicKey(path)
+	if err != nil {
+		t.Fatalf("LoadPublicKey() error = %v", err)
+	}
+	if !got.Equal(pub) {
+		t.Fatal("LoadPublicKey() returned a different key")
+	}
+}
@@ -0,0 +1,186 @@
+// Package sigv4 signs HTTP requests with AWS Signature Version 4, for
+// providers such as Bedrock that authenticate every request with a
+// signature over its method, path, query, headers and body instead of a
+// static key.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the layout of the X-Amz-Date header.
+const TimeFormat = "20060102T150405Z"
+
+const algorithm = "AWS4-HMAC-SHA256"
+
+// Credentials are AWS credentials. SessionToken is only set for temporary
+// credentials.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// ParseCredentials parses "<access key id>:<secret access key>" with an
+// optional ":<session token>", the form in which AWS credentials are kept
+// in a session's API key. Neither part can contain a colon.
+func ParseCredentials(s string) (Credentials, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Credentials{}, fmt.Errorf("want <access key id>:<secret access key>[:<session token>]")
+	}
+	c := Credentials{AccessKeyID: parts[0], SecretAccessKey: parts[1]}
+	if len(parts) == 3 {
+		c.SessionToken = parts[2]
+	}
+	return c, nil
+}
+
+// Sign adds X-Amz-Date, X-Amz-Security-Token (for temporary credentials)
+// and Authorization to req. body must be the exact bytes req will send.
+// The signature covers the host, Content-Type, every X-Amz-* header and
+// the headers named in headers; others may still change after signing.
+func Sign(req *http.Request, body []byte, creds Credentials, region, service string, now time.Time, headers ...string) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(TimeFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	signedHeaders, canonicalHeaders := canonicalHeaders(req, headers)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := algorithm + "\n" + now.Format(TimeFormat) + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalURI encodes each segment of the path as sent on the wire once
+// more, as every service but S3 expects.
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if u.Opaque != "" {
+		// An opaque URL is sent as is, either as "/path" or "//host/path".
+		path = u.Opaque
+		if rest, ok := strings.CutPrefix(path, "//"); ok {
+			path = ""
+			if i := strings.IndexByte(rest, '/'); i >= 0 {
+				path = rest[i:]
+			}
+		}
+	}
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = uriEncode(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery sorts the query parameters by name, then value.
+func canonicalQuery(u *url.URL) string {
+	values, err := url.ParseQuery(u.RawQuery)
+	if err != nil || len(values) == 0 {
+		return ""
+	}
+	var params []string
+	for name, vv := range values {
+		for _, v := range vv {
+			params = append(params, uriEncode(name)+"="+uriEncode(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalHeaders returns the signed header names and the canonical
+// header block, both sorted by lowercase name. extra names headers to sign
+// besides the host, Content-Type and X-Amz-* headers.
+func canonicalHeaders(req *http.Request, extra []string) (signed, canonical string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, vv := range req.Header {
+		name = strings.ToLower(name)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") &&
+			!slices.ContainsFunc(extra, func(e string) bool { return strings.EqualFold(e, name) }) {
+			continue
+		}
+		// Each value, and each line of a folded one, is trimmed and its
+		// runs of spaces collapsed; the results are joined with commas.
+		var trimmed []string
+		for _, v := range vv {
+			for _, line := range strings.Split(v, "\n") {
+				trimmed = append(trimmed, strings.Join(strings.Fields(line), " "))
+			}
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// uriEncode percent-encodes every byte except the unreserved characters
+// A-Z, a-z, 0-9, '-', '.', '_' and '~'.
+func uriEncode(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
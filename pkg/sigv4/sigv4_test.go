@@ -0,0 +1,216 @@
+package sigv4
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example credentials and request time of AWS's published SigV4 test
+// suite.
+var (
+	exampleCreds = Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	exampleTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+func TestSign_AWSTestVectors(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		opaque        string // the path as sent, if Go would escape it
+		contentType   string
+		header        map[string]string // further headers to sign
+		body          string
+		sessionToken  string
+		service       string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "post-vanilla",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "iam ListUsers",
+			method:        http.MethodGet,
+			url:           "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			contentType:   "application/x-www-form-urlencoded; charset=utf-8",
+			service:       "iam",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "get-vanilla-query-unreserved",
+			method: http.MethodGet,
+			url: "https://example.amazonaws.com/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz" +
+				"=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		},
+		{
+			name:          "get-utf8",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			opaque:        "/\u1234",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+		},
+		{
+			name:          "get-space",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			opaque:        "//example.amazonaws.com/example space/",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741",
+		},
+		{
+			name:          "get-header-value-trim",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			header:        map[string]string{"My-Header1": " value1", "My-Header2": ` "a   b   c"`},
+			service:       "service",
+			signedHeaders: "host;my-header1;my-header2;x-amz-date",
+			signature:     "acc3ed3afb60bb290fc8d2dd0098b9911fcaa05412b367055dee359757a9c736",
+		},
+		{
+			name:          "get-header-value-multiline",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			header:        map[string]string{"My-Header1": "value1\n  value2\n     value3"},
+			service:       "service",
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "ba17b383a53190154eb5fa66a1b836cc297cc0a3d70a5d00705980573d8ff790",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			contentType:   "application/x-www-form-urlencoded",
+			body:          "Param1=value1",
+			service:       "service",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:   "post-sts-token",
+			method: http.MethodPost,
+			url:    "https://example.amazonaws.com/",
+			sessionToken: "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/" +
+				"qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+" +
+				"scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA==",
+			service:       "service",
+			signedHeaders: "host;x-amz-date;x-amz-security-token",
+			signature:     "85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.url, nil)
+			req.URL.Opaque = tt.opaque
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			var signed []string
+			for name, v := range tt.header {
+				req.Header.Set(name, v)
+				signed = append(signed, name)
+			}
+			// Headers outside the signed set do not affect the signature.
+			req.Header.Set("User-Agent", "test")
+			creds := exampleCreds
+			creds.SessionToken = tt.sessionToken
+
+			Sign(req, []byte(tt.body), creds, "us-east-1", tt.service, exampleTime, signed...)
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/" + tt.service + "/aws4_request, " +
+				"SignedHeaders=" + tt.signedHeaders + ", Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Fatalf("Authorization =\n  %s\nwant\n  %s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Fatalf("X-Amz-Date = %q", got)
+			}
+		})
+	}
+}
+
+func TestSign_SessionToken(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/m/invoke", nil)
+	creds := exampleCreds
+	creds.SessionToken = "temporary-token"
+
+	Sign(req, []byte(`{}`), creds, "us-east-1", "bedrock", exampleTime)
+
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "temporary-token" {
+		t.Fatalf("X-Amz-Security-Token = %q", got)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Fatalf("session token not signed: %s", auth)
+	}
+}
+
+func TestCanonicalURI(t *testing.T) {
+	tests := []struct {
+		path, rawPath string
+		want          string
+	}{
+		{"", "", "/"},
+		{"/", "", "/"},
+		{"/model/anthropic.claude-v2:1/invoke", "/model/anthropic.claude-v2%3A1/invoke", "/model/anthropic.claude-v2%253A1/invoke"},
+		{"/a b/c", "", "/a%2520b/c"},
+	}
+	for _, tt := range tests {
+		u := &url.URL{Path: tt.path, RawPath: tt.rawPath}
+		if got := canonicalURI(u); got != tt.want {
+			t.Errorf("canonicalURI(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Credentials
+		wantErr bool
+	}{
+		{"AKID:secret/+key", Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret/+key"}, false},
+		{"ASIA:secret:tok=en", Credentials{AccessKeyID: "ASIA", SecretAccessKey: "secret", SessionToken: "tok=en"}, false},
+		{"AKID", Credentials{}, true},
+		{"AKID:", Credentials{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCredentials(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseCredentials(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"llm-proxy/pkg/logging"
+)
+
+const (
+	// exportBatchSize is how many spans are sent per request at most.
+	exportBatchSize = 256
+
+	// exportInterval is how long a partial batch waits before it is sent.
+	exportInterval = 5 * time.Second
+
+	// exportQueueSize bounds spans waiting to be sent. Further spans are
+	// dropped rather than slowing down proxied requests.
+	exportQueueSize = 4096
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding. Spans are batched and sent in the background.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	logger   *slog.Logger
+
+	queue chan *Span
+	flush chan chan struct{}
+	done  chan struct{}
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// NewOTLPExporter starts an exporter posting to endpoint, the collector's
+// full traces URL (usually http://localhost:4318/v1/traces). service is
+// reported as the service.name resource attribute.
+func NewOTLPExporter(endpoint, service string, logger *slog.Logger) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+		queue:    make(chan *Span, exportQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues a finished span. It never blocks.
+func (e *OTLPExporter) Export(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+// Shutdown sends any queued spans and stops the exporter.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case e.flush <- ack:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	defer close(e.done)
+
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= exportBatchSize {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.send(batch)
+				batch = nil
+			}
+		case ack := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			for len(batch) > 0 {
+				n := min(len(batch), exportBatchSize)
+				e.send(batch[:n])
+				batch = batch[n:]
+			}
+			close(ack)
+			return
+		}
+	}
+}
+
+// send posts one batch. Failures are logged and the batch is dropped;
+// traces are best effort.
+func (e *OTLPExporter) send(batch []*Span) {
+	e.mu.Lock()
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		e.logger.Warn("trace export queue full; spans dropped", "dropped", dropped)
+	}
+
+	body, err := json.Marshal(encodeOTLP(e.service, batch))
+	if err != nil {
+		e.logger.Error("error encoding spans", logging.Err(err))
+		return
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		e.logger.Warn("error exporting spans", "spans", len(batch), logging.Err(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e.logger.Warn("collector rejected spans", "spans", len(batch), "status", resp.StatusCode)
+	}
+}
+
+// OTLP/JSON request shapes. IDs are hex strings and timestamps are decimal
+// strings, as the OTLP JSON mapping requires.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func encodeOTLP(service string, spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		os := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.attributes),
+			Status:            otlpStatus{Code: s.StatusCode, Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			os.ParentSpanID = hex.EncodeToString(s.Parent.SpanID[:])
+		}
+		s.mu.Unlock()
+		encoded[i] = os
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes(map[string]any{"service.name": service})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "llm-proxy"},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+func encodeAttributes(attrs map[string]any) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		var v otlpAnyValue
+		switch x := attrs[k].(type) {
+		case string:
+			v.StringValue = &x
+		case bool:
+			v.BoolValue = &x
+		case int:
+			s := strconv.Itoa(x)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: v})
+	}
+	return kvs
+}
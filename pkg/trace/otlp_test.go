@@ -0,0 +1,85 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporterSendsSpans(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []otlpRequest
+		ctype    string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode export request: %v", err)
+		}
+		mu.Lock()
+		received = append(received, req)
+		ctype = r.Header.Get("Content-Type")
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	exp := NewOTLPExporter(collector.URL, "test-proxy", slog.New(slog.DiscardHandler))
+	tracer := NewTracer(exp)
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	span := tracer.Start("POST /v1/messages", KindServer, parent)
+	span.SetAttribute("gen_ai.system", "anthropic")
+	span.SetAttribute("http.response.status_code", 200)
+	span.SetAttribute("llmproxy.streaming", true)
+	span.SetAttribute("llmproxy.stream.duration_ms", 12.5)
+	span.SetStatus(StatusError, "boom")
+	span.Finish()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exp.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("collector received %d requests, want 1", len(received))
+	}
+	if ctype != "application/json" {
+		t.Fatalf("Content-Type = %q", ctype)
+	}
+	rs := received[0].ResourceSpans[0]
+	if v := rs.Resource.Attributes[0]; v.Key != "service.name" || *v.Value.StringValue != "test-proxy" {
+		t.Fatalf("resource attribute = %+v", v)
+	}
+	got := rs.ScopeSpans[0].Spans[0]
+	if got.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || got.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("span IDs = trace %s parent %s", got.TraceID, got.ParentSpanID)
+	}
+	if got.Kind != KindServer || got.Status.Code != StatusError || got.Status.Message != "boom" {
+		t.Fatalf("span = %+v", got)
+	}
+
+	attrs := make(map[string]otlpAnyValue)
+	for _, kv := range got.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["gen_ai.system"].StringValue; v == nil || *v != "anthropic" {
+		t.Errorf("gen_ai.system = %+v", attrs["gen_ai.system"])
+	}
+	if v := attrs["http.response.status_code"].IntValue; v == nil || *v != "200" {
+		t.Errorf("http.response.status_code = %+v", attrs["http.response.status_code"])
+	}
+	if v := attrs["llmproxy.streaming"].BoolValue; v == nil || !*v {
+		t.Errorf("llmproxy.streaming = %+v", attrs["llmproxy.streaming"])
+	}
+	if v := attrs["llmproxy.stream.duration_ms"].DoubleValue; v == nil || *v != 12.5 {
+		t.Errorf("llmproxy.stream.duration_ms = %+v", attrs["llmproxy.stream.duration_ms"])
+	}
+}
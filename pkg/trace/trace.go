@@ -0,0 +1,183 @@
+// Package trace records request spans with W3C trace context propagation
+// and exports them to an OpenTelemetry collector.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span within a trace, as carried in the W3C
+// traceparent header.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc has non-zero trace and span IDs.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a traceparent header value. ok is false if the
+// value is malformed or carries all-zero IDs. Versions above 00 are
+// accepted as long as they start with the version 00 fields.
+func ParseTraceparent(s string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	if parts[1] != strings.ToLower(parts[1]) || parts[2] != strings.ToLower(parts[2]) {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Exporter receives finished spans.
+type Exporter interface {
+	Export(s *Span)
+}
+
+// Span kinds, numbered as in OTLP.
+const (
+	KindServer = 2
+	KindClient = 3
+)
+
+// Status codes, numbered as in OTLP.
+const (
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+)
+
+// Span is one timed operation.
+type Span struct {
+	Name          string
+	Kind          int
+	Context       SpanContext
+	Parent        SpanContext // zero for a root span
+	Start         time.Time
+	End           time.Time
+	StatusCode    int
+	StatusMessage string
+
+	mu         sync.Mutex
+	attributes map[string]any
+	tracer     *Tracer
+	ended      bool
+}
+
+// SetAttribute records a string, bool, int, int64 or float64 attribute.
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// Attributes returns a copy of the span's attributes.
+func (s *Span) Attributes() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]any, len(s.attributes))
+	for k, v := range s.attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// SetName renames the span, for names that depend on what is learned
+// while it runs.
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Name = name
+}
+
+// SetStatus sets the span status.
+func (s *Span) SetStatus(code int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.StatusCode, s.StatusMessage = code, message
+}
+
+// Finish ends the span and hands it to the exporter if it is sampled.
+// Later calls are ignored.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = s.tracer.now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Tracer starts spans and sends them to an exporter when they finish.
+type Tracer struct {
+	exporter Exporter
+
+	// now is the span clock. Overridden in tests.
+	now func() time.Time
+}
+
+// NewTracer creates a tracer exporting to exp.
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp, now: time.Now}
+}
+
+// Start begins a span. If parent is valid the span joins its trace and
+// inherits its sampling decision; otherwise it starts a new sampled trace.
+func (t *Tracer) Start(name string, kind int, parent SpanContext) *Span {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      t.now(),
+		attributes: make(map[string]any),
+		tracer:     t,
+	}
+	if parent.IsValid() {
+		s.Parent = parent
+		s.Context.TraceID = parent.TraceID
+		s.Context.Sampled = parent.Sampled
+	} else {
+		rand.Read(s.Context.TraceID[:])
+		s.Context.Sampled = true
+	}
+	rand.Read(s.Context.SpanID[:])
+	return s
+}
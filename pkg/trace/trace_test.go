@@ -0,0 +1,109 @@
+package trace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version with extra fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"version 00 with extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"invalid version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"uppercase hex", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"short trace id", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && sc.Sampled != tt.sampled {
+				t.Fatalf("Sampled = %v, want %v", sc.Sampled, tt.sampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, value := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	} {
+		sc, ok := ParseTraceparent(value)
+		if !ok {
+			t.Fatalf("ParseTraceparent(%q) failed", value)
+		}
+		if got := sc.Traceparent(); got != value {
+			t.Fatalf("Traceparent() = %q, want %q", got, value)
+		}
+	}
+}
+
+type recordingExporter struct {
+	spans []*Span
+}
+
+func (e *recordingExporter) Export(s *Span) { e.spans = append(e.spans, s) }
+
+func TestTracerStart(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer := NewTracer(exp)
+
+	root := tracer.Start("root", KindServer, SpanContext{})
+	if !root.Context.IsValid() || !root.Context.Sampled || root.Parent.IsValid() {
+		t.Fatalf("root span context = %+v, parent = %+v", root.Context, root.Parent)
+	}
+
+	child := tracer.Start("child", KindClient, root.Context)
+	if child.Context.TraceID != root.Context.TraceID {
+		t.Fatal("child did not inherit the trace ID")
+	}
+	if child.Context.SpanID == root.Context.SpanID {
+		t.Fatal("child reused the parent span ID")
+	}
+	if child.Parent != root.Context {
+		t.Fatal("child parent is not the root span")
+	}
+
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	dropped := tracer.Start("dropped", KindServer, unsampled)
+	if dropped.Context.Sampled {
+		t.Fatal("span did not inherit the parent's sampling decision")
+	}
+
+	child.Finish()
+	dropped.Finish()
+	root.Finish()
+	root.Finish()
+	if len(exp.spans) != 2 || exp.spans[0] != child || exp.spans[1] != root {
+		t.Fatalf("exported %d spans, want child then root once each", len(exp.spans))
+	}
+}
+
+func TestSpanFinishSetsEnd(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := start
+	tracer := NewTracer(nil)
+	tracer.now = func() time.Time { return clock }
+
+	s := tracer.Start("op", KindServer, SpanContext{})
+	clock = start.Add(250 * time.Millisecond)
+	s.Finish()
+
+	if s.End.Sub(s.Start) != 250*time.Millisecond {
+		t.Fatalf("duration = %s, want 250ms", s.End.Sub(s.Start))
+	}
+}
@@ -0,0 +1,66 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// csvHeader is the column layout of WriteCSV. Columns for dimensions a
+// report was not grouped by are left empty.
+var csvHeader = []string{
+	"period_start", "sandbox_id", "session_id", "provider", "model", "requests",
+	"input_tokens", "output_tokens", "cache_read_tokens", "cache_creation_tokens", "cost_usd",
+}
+
+// WriteCSV writes rows as CSV with a header line.
+func WriteCSV(w io.Writer, rows []Row) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		var period string
+		if r.PeriodStart != nil {
+			period = r.PeriodStart.Format(time.RFC3339)
+		}
+		record := []string{
+			period, r.SandboxID, r.SessionID, r.Provider, r.Model,
+			strconv.FormatInt(r.Requests, 10),
+			strconv.FormatInt(r.InputTokens, 10),
+			strconv.FormatInt(r.OutputTokens, 10),
+			strconv.FormatInt(r.CacheReadTokens, 10),
+			strconv.FormatInt(r.CacheCreationTokens, 10),
+			strconv.FormatFloat(r.CostUSD, 'f', 6, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSONL writes rows as newline-delimited JSON, one row per line.
+func WriteJSONL(w io.Writer, rows []Row) error {
+	enc := json.NewEncoder(w)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sum totals rows into a single ungrouped row.
+func Sum(rows []Row) Row {
+	var total Row
+	for _, r := range rows {
+		total.Requests += r.Requests
+		total.Usage.Add(r.Usage)
+		total.CostUSD += r.CostUSD
+	}
+	return total
+}
@@ -0,0 +1,152 @@
+package usage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BucketWidth is the time resolution usage is aggregated at.
+const BucketWidth = time.Hour
+
+// Record is the usage of one proxied request.
+type Record struct {
+	Time      time.Time
+	SessionID string
+	SandboxID string
+	Provider  string
+	Model     string
+	Usage     Usage
+
+	// CostUSD is the priced cost of Usage, zero if the model has no price.
+	CostUSD float64
+}
+
+// Bucket is the usage of one session against one model within a
+// BucketWidth-aligned time window.
+type Bucket struct {
+	Start     time.Time `json:"start"`
+	SessionID string    `json:"session_id"`
+	SandboxID string    `json:"sandbox_id,omitempty"`
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model,omitempty"`
+	Requests  int64     `json:"requests"`
+	Usage
+	CostUSD float64 `json:"cost_usd"`
+}
+
+type bucketKey struct {
+	start     time.Time
+	sessionID string
+	sandboxID string
+	provider  string
+	model     string
+}
+
+// Ledger aggregates usage records in memory.
+type Ledger struct {
+	retention time.Duration
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*Bucket
+	lastPrune time.Time
+
+	// now is the clock used for pruning. Overridden in tests.
+	now func() time.Time
+}
+
+// NewLedger creates an empty ledger that keeps buckets for retention. Zero
+// keeps them forever.
+func NewLedger(retention time.Duration) *Ledger {
+	return &Ledger{
+		retention: retention,
+		buckets:   make(map[bucketKey]*Bucket),
+		now:       time.Now,
+	}
+}
+
+// SessionID derives a stable identifier for a session from its store
+// token, so usage can be attributed without recording the token itself.
+func SessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Add records the usage of one request.
+func (l *Ledger) Add(r Record) {
+	if r.Time.IsZero() {
+		r.Time = l.now()
+	}
+	key := bucketKey{
+		start:     r.Time.UTC().Truncate(BucketWidth),
+		sessionID: r.SessionID,
+		sandboxID: r.SandboxID,
+		provider:  r.Provider,
+		model:     r.Model,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &Bucket{
+			Start:     key.start,
+			SessionID: r.SessionID,
+			SandboxID: r.SandboxID,
+			Provider:  r.Provider,
+			Model:     r.Model,
+		}
+		l.buckets[key] = b
+	}
+	b.Requests++
+	b.Usage.Add(r.Usage)
+	b.CostUSD += r.CostUSD
+
+	if now := l.now(); l.retention > 0 && now.Sub(l.lastPrune) >= BucketWidth {
+		l.prune(now)
+	}
+}
+
+// Buckets returns a copy of every bucket ordered by start time, then
+// sandbox, session, provider and model.
+func (l *Ledger) Buckets() []Bucket {
+	l.mu.Lock()
+	result := make([]Bucket, 0, len(l.buckets))
+	for _, b := range l.buckets {
+		result = append(result, *b)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if a.SandboxID != b.SandboxID {
+			return a.SandboxID < b.SandboxID
+		}
+		if a.SessionID != b.SessionID {
+			return a.SessionID < b.SessionID
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return a.Model < b.Model
+	})
+	return result
+}
+
+// prune drops buckets that ended more than retention ago. Callers must
+// hold l.mu.
+func (l *Ledger) prune(now time.Time) {
+	cutoff := now.Add(-l.retention)
+	for key := range l.buckets {
+		if key.start.Add(BucketWidth).Before(cutoff) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,66 @@
+package usage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLedger_AggregatesByBucket(t *testing.T) {
+	l := NewLedger(0)
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	add := func(at time.Duration, sandbox, model string, in, out int64) {
+		l.Add(Record{
+			Time:      base.Add(at),
+			SessionID: "s-" + sandbox,
+			SandboxID: sandbox,
+			Provider:  "anthropic",
+			Model:     model,
+			Usage:     Usage{InputTokens: in, OutputTokens: out},
+		})
+	}
+	add(5*time.Minute, "a", "claude-sonnet-4-5", 10, 1)
+	add(50*time.Minute, "a", "claude-sonnet-4-5", 20, 2)
+	add(50*time.Minute, "a", "claude-haiku-4-5", 1, 1)
+	add(70*time.Minute, "a", "claude-sonnet-4-5", 5, 5)
+	add(10*time.Minute, "b", "claude-sonnet-4-5", 7, 7)
+
+	buckets := l.Buckets()
+	if len(buckets) != 4 {
+		t.Fatalf("len(Buckets()) = %d, want 4: %+v", len(buckets), buckets)
+	}
+
+	first := buckets[0]
+	if !first.Start.Equal(base) || first.SandboxID != "a" || first.Model != "claude-haiku-4-5" {
+		t.Fatalf("buckets[0] = %+v", first)
+	}
+	second := buckets[1]
+	if second.Requests != 2 || second.InputTokens != 30 || second.OutputTokens != 3 {
+		t.Fatalf("buckets[1] = %+v, want two requests totalling 30/3", second)
+	}
+	if last := buckets[3]; !last.Start.Equal(base.Add(time.Hour)) {
+		t.Fatalf("buckets[3].Start = %v, want next hour", last.Start)
+	}
+}
+
+func TestLedger_Retention(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	l := NewLedger(24 * time.Hour)
+	l.now = func() time.Time { return now }
+
+	l.Add(Record{Time: now.Add(-72 * time.Hour), SessionID: "old", Provider: "openai"})
+	now = now.Add(2 * BucketWidth)
+	l.Add(Record{SessionID: "new", Provider: "openai"})
+
+	buckets := l.Buckets()
+	if len(buckets) != 1 || buckets[0].SessionID != "new" {
+		t.Fatalf("Buckets() = %+v, want only the recent bucket", buckets)
+	}
+}
+
+func TestSessionID(t *testing.T) {
+	id := SessionID("session-abc")
+	if len(id) != 12 || id == SessionID("session-abd") || id != SessionID("session-abc") {
+		t.Fatalf("SessionID() = %q, want a stable 12-char fingerprint", id)
+	}
+}
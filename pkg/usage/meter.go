@@ -0,0 +1,264 @@
+// Package usage measures the tokens consumed by proxied LLM requests and
+// aggregates them for reporting.
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// maxBuffered caps how much of a response the meter holds at once: a whole
+// JSON body, or a single SSE/NDJSON line. Anything larger is not metered.
+const maxBuffered = 4 << 20
+
+// Usage is a count of tokens. InputTokens excludes cached input so the
+// four fields add up to the total billed tokens.
+type Usage struct {
+	InputTokens         int64 `json:"input_tokens"`
+	OutputTokens        int64 `json:"output_tokens"`
+	CacheReadTokens     int64 `json:"cache_read_tokens"`
+	CacheCreationTokens int64 `json:"cache_creation_tokens"`
+}
+
+// Add accumulates o into u.
+func (u *Usage) Add(o Usage) {
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheReadTokens += o.CacheReadTokens
+	u.CacheCreationTokens += o.CacheCreationTokens
+}
+
+// Total returns the sum of all token counts.
+func (u Usage) Total() int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheReadTokens + u.CacheCreationTokens
+}
+
+// format is how a response body is framed.
+type format int
+
+const (
+	formatJSON format = iota
+	formatSSE
+	formatNDJSON
+)
+
+// Meter extracts token usage from a response body as it passes through.
+// It is an io.Writer meant to sit behind an io.TeeReader, so the body is
+// relayed to the client unchanged and without extra buffering. Meter
+// understands Anthropic messages (JSON and SSE), OpenAI chat/completions
+// (JSON and SSE with stream_options.include_usage), Gemini (JSON, JSON
+// array and SSE) and Ollama (JSON and NDJSON).
+type Meter struct {
+	format format
+	buf    bytes.Buffer
+	skip   bool // current line or body exceeded maxBuffered
+
+	model string
+	usage Usage
+	found bool
+}
+
+// NewMeter creates a meter for a response with the given Content-Type.
+func NewMeter(contentType string) *Meter {
+	m := &Meter{format: formatJSON}
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		m.format = formatSSE
+	case strings.Contains(contentType, "application/x-ndjson"):
+		m.format = formatNDJSON
+	}
+	return m
+}
+
+// Write feeds response bytes to the meter. It never fails.
+func (m *Meter) Write(p []byte) (int, error) {
+	n := len(p)
+	if m.format == formatJSON {
+		m.buffer(p)
+		return n, nil
+	}
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m.buffer(p)
+			break
+		}
+		m.buffer(p[:i])
+		if !m.skip {
+			m.line(m.buf.Bytes())
+		}
+		m.buf.Reset()
+		m.skip = false
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// buffer appends p unless that would exceed maxBuffered.
+func (m *Meter) buffer(p []byte) {
+	if m.skip {
+		return
+	}
+	if m.buf.Len()+len(p) > maxBuffered {
+		m.skip = true
+		m.buf.Reset()
+		return
+	}
+	m.buf.Write(p)
+}
+
+// line handles one complete SSE or NDJSON line.
+func (m *Meter) line(l []byte) {
+	l = bytes.TrimSpace(l)
+	if m.format == formatSSE {
+		data, ok := bytes.CutPrefix(l, []byte("data:"))
+		if !ok {
+			return
+		}
+		l = bytes.TrimSpace(data)
+	}
+	if len(l) > 0 && l[0] == '{' {
+		m.observe(l)
+	}
+}
+
+// Finish flushes any unterminated input and returns the model and usage
+// seen. ok is false if the response carried no usage information.
+func (m *Meter) Finish() (model string, u Usage, ok bool) {
+	if !m.skip && m.buf.Len() > 0 {
+		if m.format == formatJSON {
+			m.observe(m.buf.Bytes())
+		} else {
+			m.line(m.buf.Bytes())
+		}
+	}
+	m.buf.Reset()
+	return m.model, m.usage, m.found
+}
+
+// payload covers the fields carrying usage in every supported provider's
+// responses and stream events.
+type payload struct {
+	Model string    `json:"model"`
+	Usage *rawUsage `json:"usage"`
+
+	// Anthropic message_start nests the message.
+	Message *struct {
+		Model string    `json:"model"`
+		Usage *rawUsage `json:"usage"`
+	} `json:"message"`
+
+	// Ollama native API.
+	PromptEvalCount *int64 `json:"prompt_eval_count"`
+	EvalCount       *int64 `json:"eval_count"`
+
+	// Gemini. Every stream chunk carries the running totals.
+	ModelVersion  string `json:"modelVersion"`
+	UsageMetadata *struct {
+		// PromptTokenCount includes cached tokens.
+		PromptTokenCount        int64 `json:"promptTokenCount"`
+		CandidatesTokenCount    int64 `json:"candidatesTokenCount"`
+		CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+		ThoughtsTokenCount      int64 `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+type rawUsage struct {
+	// Anthropic.
+	InputTokens              *int64 `json:"input_tokens"`
+	OutputTokens             *int64 `json:"output_tokens"`
+	CacheCreationInputTokens *int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     *int64 `json:"cache_read_input_tokens"`
+
+	// OpenAI. prompt_tokens includes cached tokens.
+	PromptTokens        *int64 `json:"prompt_tokens"`
+	CompletionTokens    *int64 `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// observe merges usage from one JSON document. Counts present in a later
+// event replace earlier ones: Anthropic's message_delta carries the
+// cumulative output count, superseding the placeholder in message_start.
+func (m *Meter) observe(doc []byte) {
+	if doc = bytes.TrimSpace(doc); len(doc) > 0 && doc[0] == '[' {
+		// Gemini's :streamGenerateContent without alt=sse returns its
+		// chunks as one JSON array, each with the running totals.
+		var chunks []json.RawMessage
+		if err := json.Unmarshal(doc, &chunks); err != nil {
+			return
+		}
+		for _, c := range chunks {
+			if c = bytes.TrimSpace(c); len(c) > 0 && c[0] == '{' {
+				m.observe(c)
+			}
+		}
+		return
+	}
+
+	var p payload
+	if err := json.Unmarshal(doc, &p); err != nil {
+		return
+	}
+
+	if p.Message != nil {
+		m.setModel(p.Message.Model)
+		m.apply(p.Message.Usage)
+	}
+	m.setModel(p.Model)
+	m.apply(p.Usage)
+
+	if p.PromptEvalCount != nil {
+		m.usage.InputTokens = *p.PromptEvalCount
+		m.found = true
+	}
+	if p.EvalCount != nil {
+		m.usage.OutputTokens = *p.EvalCount
+		m.found = true
+	}
+
+	m.setModel(p.ModelVersion)
+	if g := p.UsageMetadata; g != nil {
+		// Thinking tokens are billed as output.
+		m.usage.InputTokens = g.PromptTokenCount - g.CachedContentTokenCount
+		m.usage.CacheReadTokens = g.CachedContentTokenCount
+		m.usage.OutputTokens = g.CandidatesTokenCount + g.ThoughtsTokenCount
+		m.found = true
+	}
+}
+
+func (m *Meter) setModel(model string) {
+	if model != "" {
+		m.model = model
+	}
+}
+
+func (m *Meter) apply(u *rawUsage) {
+	if u == nil {
+		return
+	}
+	set := func(dst *int64, src *int64) {
+		if src != nil {
+			*dst = *src
+			m.found = true
+		}
+	}
+	set(&m.usage.InputTokens, u.InputTokens)
+	set(&m.usage.OutputTokens, u.OutputTokens)
+	set(&m.usage.CacheCreationTokens, u.CacheCreationInputTokens)
+	set(&m.usage.CacheReadTokens, u.CacheReadInputTokens)
+
+	if u.PromptTokens != nil {
+		var cached int64
+		if u.PromptTokensDetails != nil {
+			cached = u.PromptTokensDetails.CachedTokens
+		}
+		m.usage.InputTokens = *u.PromptTokens - cached
+		m.usage.CacheReadTokens = cached
+		m.found = true
+	}
+	set(&m.usage.OutputTokens, u.CompletionTokens)
+}
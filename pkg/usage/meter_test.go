@@ -0,0 +1,172 @@
+package usage
+
+import (
+	"strings"
+	"testing"
+)
+
+const anthropicSSE = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-5","usage":{"input_tokens":25,"cache_creation_input_tokens":100,"cache_read_input_tokens":2000,"output_tokens":1}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+const openaiSSE = `data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o-2024-08-06","choices":[{"index":0,"delta":{"content":"Hi"}}],"usage":null}
+
+data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o-2024-08-06","choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":40,"total_tokens":1240,"prompt_tokens_details":{"cached_tokens":1024}}}
+
+data: [DONE]
+
+`
+
+const ollamaNDJSON = `{"model":"llama3.2","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"Hi"},"done":false}
+{"model":"llama3.2","created_at":"2025-01-01T00:00:01Z","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":26,"eval_count":290}
+`
+
+const geminiSSE = `data: {"candidates":[{"content":{"parts":[{"text":"Hi"}],"role":"model"},"index":0}],"usageMetadata":{"promptTokenCount":300,"totalTokenCount":300},"modelVersion":"gemini-2.5-flash"}
+
+data: {"candidates":[{"content":{"parts":[{"text":" there"}],"role":"model"},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":300,"candidatesTokenCount":12,"cachedContentTokenCount":256,"thoughtsTokenCount":30,"totalTokenCount":342},"modelVersion":"gemini-2.5-flash"}
+
+`
+
+func TestMeter(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantModel   string
+		want        Usage
+		wantOK      bool
+	}{
+		{
+			name:        "anthropic stream",
+			contentType: "text/event-stream; charset=utf-8",
+			body:        anthropicSSE,
+			wantModel:   "claude-sonnet-4-5",
+			want:        Usage{InputTokens: 25, OutputTokens: 15, CacheReadTokens: 2000, CacheCreationTokens: 100},
+			wantOK:      true,
+		},
+		{
+			name:        "anthropic json",
+			contentType: "application/json",
+			body:        `{"id":"msg_1","type":"message","model":"claude-haiku-4-5","content":[],"usage":{"input_tokens":10,"output_tokens":5}}`,
+			wantModel:   "claude-haiku-4-5",
+			want:        Usage{InputTokens: 10, OutputTokens: 5},
+			wantOK:      true,
+		},
+		{
+			name:        "openai stream with include_usage",
+			contentType: "text/event-stream",
+			body:        openaiSSE,
+			wantModel:   "gpt-4o-2024-08-06",
+			want:        Usage{InputTokens: 176, OutputTokens: 40, CacheReadTokens: 1024},
+			wantOK:      true,
+		},
+		{
+			name:        "openai json",
+			contentType: "application/json",
+			body:        `{"id":"c1","object":"chat.completion","model":"gpt-4o-mini","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":7,"total_tokens":19}}`,
+			wantModel:   "gpt-4o-mini",
+			want:        Usage{InputTokens: 12, OutputTokens: 7},
+			wantOK:      true,
+		},
+		{
+			name:        "gemini stream",
+			contentType: "text/event-stream",
+			body:        geminiSSE,
+			wantModel:   "gemini-2.5-flash",
+			want:        Usage{InputTokens: 44, OutputTokens: 42, CacheReadTokens: 256},
+			wantOK:      true,
+		},
+		{
+			name:        "gemini json",
+			contentType: "application/json; charset=UTF-8",
+			body:        `{"candidates":[],"usageMetadata":{"promptTokenCount":8,"candidatesTokenCount":5,"totalTokenCount":13},"modelVersion":"gemini-2.0-flash"}`,
+			wantModel:   "gemini-2.0-flash",
+			want:        Usage{InputTokens: 8, OutputTokens: 5},
+			wantOK:      true,
+		},
+		{
+			name:        "gemini json array",
+			contentType: "application/json; charset=UTF-8",
+			body: "[" + `{"candidates":[{"content":{"parts":[{"text":"Hi"}],"role":"model"}}],"usageMetadata":{"promptTokenCount":300,"totalTokenCount":300},"modelVersion":"gemini-2.5-flash"}` +
+				",\r\n" + `{"candidates":[{"content":{"parts":[{"text":" there"}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":300,"candidatesTokenCount":12,"cachedContentTokenCount":256,"thoughtsTokenCount":30,"totalTokenCount":342},"modelVersion":"gemini-2.5-flash"}` +
+				"]",
+			wantModel: "gemini-2.5-flash",
+			want:      Usage{InputTokens: 44, OutputTokens: 42, CacheReadTokens: 256},
+			wantOK:    true,
+		},
+		{
+			name:        "ollama ndjson",
+			contentType: "application/x-ndjson",
+			body:        ollamaNDJSON,
+			wantModel:   "llama3.2",
+			want:        Usage{InputTokens: 26, OutputTokens: 290},
+			wantOK:      true,
+		},
+		{
+			name:        "ollama json",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"model":"llama3.2","done":true,"prompt_eval_count":3,"eval_count":4}`,
+			wantModel:   "llama3.2",
+			want:        Usage{InputTokens: 3, OutputTokens: 4},
+			wantOK:      true,
+		},
+		{
+			name:        "openai stream without usage",
+			contentType: "text/event-stream",
+			body:        "data: {\"model\":\"gpt-4o\",\"choices\":[],\"usage\":null}\n\ndata: [DONE]\n\n",
+			wantModel:   "gpt-4o",
+		},
+		{
+			name:        "not json",
+			contentType: "text/plain",
+			body:        "ok",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Feed in awkward chunk sizes to exercise line reassembly.
+			for _, chunk := range []int{1, 7, len(tt.body)} {
+				m := NewMeter(tt.contentType)
+				for body := tt.body; len(body) > 0; {
+					n := min(chunk, len(body))
+					m.Write([]byte(body[:n]))
+					body = body[n:]
+				}
+				model, got, ok := m.Finish()
+				if ok != tt.wantOK || got != tt.want || model != tt.wantModel {
+					t.Fatalf("chunk %d: Finish() = %q, %+v, %v; want %q, %+v, %v",
+						chunk, model, got, ok, tt.wantModel, tt.want, tt.wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestMeter_UnterminatedFinalLine(t *testing.T) {
+	m := NewMeter("application/x-ndjson")
+	m.Write([]byte(`{"model":"llama3.2","done":true,"prompt_eval_count":1,"eval_count":2}`))
+	if _, got, ok := m.Finish(); !ok || got.OutputTokens != 2 {
+		t.Fatalf("Finish() = %+v, %v", got, ok)
+	}
+}
+
+func TestMeter_OversizedBodyIgnored(t *testing.T) {
+	m := NewMeter("application/json")
+	m.Write([]byte(`{"usage":{"input_tokens":1},"pad":"`))
+	m.Write([]byte(strings.Repeat("x", maxBuffered)))
+	m.Write([]byte(`"}`))
+	if _, _, ok := m.Finish(); ok {
+		t.Fatal("oversized body was metered")
+	}
+}
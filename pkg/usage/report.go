@@ -0,0 +1,159 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dimensions usage can be grouped by.
+const (
+	GroupSandbox  = "sandbox"
+	GroupSession  = "session"
+	GroupProvider = "provider"
+	GroupModel    = "model"
+	GroupHour     = "hour"
+	GroupDay      = "day"
+)
+
+// Filter selects buckets for a report. Zero fields match everything. From
+// and To are compared against bucket start times, so the range has
+// BucketWidth resolution.
+type Filter struct {
+	SandboxID string
+	Provider  string
+	Model     string
+	From      time.Time // inclusive
+	To        time.Time // exclusive
+}
+
+func (f Filter) match(b *Bucket) bool {
+	switch {
+	case f.SandboxID != "" && b.SandboxID != f.SandboxID,
+		f.Provider != "" && b.Provider != f.Provider,
+		f.Model != "" && b.Model != f.Model,
+		!f.From.IsZero() && b.Start.Before(f.From.Truncate(BucketWidth)),
+		!f.To.IsZero() && !b.Start.Before(f.To):
+		return false
+	}
+	return true
+}
+
+// Row is one line of a usage report. Only the fields named in the report's
+// grouping are set; the rest are zero.
+type Row struct {
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	SandboxID   string     `json:"sandbox_id,omitempty"`
+	SessionID   string     `json:"session_id,omitempty"`
+	Provider    string     `json:"provider,omitempty"`
+	Model       string     `json:"model,omitempty"`
+	Requests    int64      `json:"requests"`
+	Usage
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// ParseGroupBy splits a comma-separated list of dimensions and validates
+// it. At most one of hour and day may be given.
+func ParseGroupBy(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var dims []string
+	period := ""
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+		switch d {
+		case GroupSandbox, GroupSession, GroupProvider, GroupModel:
+		case GroupHour, GroupDay:
+			if period != "" {
+				return nil, fmt.Errorf("group_by can include only one of hour or day")
+			}
+			period = d
+		default:
+			return nil, fmt.Errorf("unknown group_by dimension %q", d)
+		}
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+// Report aggregates the buckets matching f into one row per distinct
+// combination of the groupBy dimensions, ordered by period and then by
+// the grouped fields. With no dimensions it returns a single total row.
+func (l *Ledger) Report(f Filter, groupBy []string) []Row {
+	grouped := make(map[string]bool, len(groupBy))
+	for _, d := range groupBy {
+		grouped[d] = true
+	}
+
+	type rowKey struct {
+		period                            int64
+		sandbox, session, provider, model string
+	}
+	rows := make(map[rowKey]*Row)
+	for _, b := range l.Buckets() {
+		if !f.match(&b) {
+			continue
+		}
+		var period time.Time
+		switch {
+		case grouped[GroupHour]:
+			period = b.Start
+		case grouped[GroupDay]:
+			period = b.Start.Truncate(24 * time.Hour)
+		}
+
+		row := Row{}
+		if !period.IsZero() {
+			row.PeriodStart = &period
+		}
+		if grouped[GroupSandbox] {
+			row.SandboxID = b.SandboxID
+		}
+		if grouped[GroupSession] {
+			row.SessionID = b.SessionID
+		}
+		if grouped[GroupProvider] {
+			row.Provider = b.Provider
+		}
+		if grouped[GroupModel] {
+			row.Model = b.Model
+		}
+
+		key := rowKey{period.Unix(), row.SandboxID, row.SessionID, row.Provider, row.Model}
+		existing, ok := rows[key]
+		if !ok {
+			existing = &row
+			rows[key] = existing
+		}
+		existing.Requests += b.Requests
+		existing.Usage.Add(b.Usage)
+		existing.CostUSD += b.CostUSD
+	}
+
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	if len(result) == 0 && len(groupBy) == 0 {
+		result = append(result, Row{})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.PeriodStart != nil && b.PeriodStart != nil && !a.PeriodStart.Equal(*b.PeriodStart) {
+			return a.PeriodStart.Before(*b.PeriodStart)
+		}
+		if a.SandboxID != b.SandboxID {
+			return a.SandboxID < b.SandboxID
+		}
+		if a.SessionID != b.SessionID {
+			return a.SessionID < b.SessionID
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return a.Model < b.Model
+	})
+	return result
+}
@@ -0,0 +1,138 @@
+package usage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newReportLedger() (*Ledger, time.Time) {
+	l := NewLedger(0)
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	add := func(at time.Duration, session, sandbox, provider, model string, in int64, cost float64) {
+		l.Add(Record{
+			Time: base.Add(at), SessionID: session, SandboxID: sandbox,
+			Provider: provider, Model: model,
+			Usage: Usage{InputTokens: in}, CostUSD: cost,
+		})
+	}
+	add(0, "s1", "a", "anthropic", "claude-sonnet-4-5", 100, 1)
+	add(time.Hour, "s1", "a", "anthropic", "claude-sonnet-4-5", 50, 0.5)
+	add(time.Hour, "s2", "a", "openai", "gpt-4o", 10, 0.1)
+	add(26*time.Hour, "s3", "b", "anthropic", "claude-haiku-4-5", 1, 0.01)
+	return l, base
+}
+
+func TestLedger_Report(t *testing.T) {
+	l, base := newReportLedger()
+
+	tests := []struct {
+		name      string
+		filter    Filter
+		groupBy   string
+		wantRows  int
+		wantFirst Row
+	}{
+		{
+			name:      "total",
+			wantRows:  1,
+			wantFirst: Row{Requests: 4, Usage: Usage{InputTokens: 161}, CostUSD: 1.61},
+		},
+		{
+			name:      "by sandbox",
+			groupBy:   "sandbox",
+			wantRows:  2,
+			wantFirst: Row{SandboxID: "a", Requests: 3, Usage: Usage{InputTokens: 160}, CostUSD: 1.6},
+		},
+		{
+			name:      "by provider and model filtered to sandbox",
+			filter:    Filter{SandboxID: "a"},
+			groupBy:   "provider,model",
+			wantRows:  2,
+			wantFirst: Row{Provider: "anthropic", Model: "claude-sonnet-4-5", Requests: 2, Usage: Usage{InputTokens: 150}, CostUSD: 1.5},
+		},
+		{
+			name:      "time range",
+			filter:    Filter{From: base.Add(time.Hour), To: base.Add(2 * time.Hour)},
+			groupBy:   "session",
+			wantRows:  2,
+			wantFirst: Row{SessionID: "s1", Requests: 1, Usage: Usage{InputTokens: 50}, CostUSD: 0.5},
+		},
+		{
+			name:     "by day",
+			groupBy:  "day",
+			wantRows: 2,
+		},
+		{
+			name:     "no match",
+			filter:   Filter{Provider: "ollama"},
+			groupBy:  "sandbox",
+			wantRows: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groupBy, err := ParseGroupBy(tt.groupBy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows := l.Report(tt.filter, groupBy)
+			if len(rows) != tt.wantRows {
+				t.Fatalf("len(rows) = %d, want %d: %+v", len(rows), tt.wantRows, rows)
+			}
+			if tt.wantFirst == (Row{}) {
+				return
+			}
+			got := rows[0]
+			got.CostUSD = float64(int(got.CostUSD*1000+0.5)) / 1000
+			if got != tt.wantFirst {
+				t.Fatalf("rows[0] = %+v, want %+v", got, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestLedger_ReportByHour(t *testing.T) {
+	l, base := newReportLedger()
+	groupBy, _ := ParseGroupBy("hour,sandbox")
+
+	rows := l.Report(Filter{}, groupBy)
+	if len(rows) != 3 {
+		t.Fatalf("len(rows) = %d, want 3", len(rows))
+	}
+	if rows[0].PeriodStart == nil || !rows[0].PeriodStart.Equal(base) {
+		t.Fatalf("rows[0].PeriodStart = %v, want %v", rows[0].PeriodStart, base)
+	}
+	if rows[1].Requests != 2 {
+		t.Fatalf("rows[1] = %+v, want both requests in the second hour", rows[1])
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	for _, bad := range []string{"color", "hour,day", "sandbox,"} {
+		if _, err := ParseGroupBy(bad); err == nil {
+			t.Fatalf("ParseGroupBy(%q) expected error", bad)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	l, _ := newReportLedger()
+	groupBy, _ := ParseGroupBy("day,sandbox")
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, l.Report(Filter{}, groupBy)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		"period_start,sandbox_id,session_id,provider,model,requests,input_tokens,output_tokens,cache_read_tokens,cache_creation_tokens,cost_usd",
+		"2026-03-01T00:00:00Z,a,,,,3,160,0,0,0,1.600000",
+		"2026-03-02T00:00:00Z,b,,,,1,1,0,0,0,0.010000",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("csv =\n%s\nwant\n%s", buf.String(), strings.Join(want, "\n"))
+	}
+}
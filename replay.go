@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"llm-proxy/pkg/capture"
+)
+
+// runReplay implements the replay subcommand: serve a capture directory as
+// a fake upstream. Point a session's upstream_url at it to rerun an agent
+// without network access.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	addr := fs.String("addr", ":8091", "Listen address for the fake upstream")
+	dir := fs.String("dir", "", "Capture directory (or one sandbox's subdirectory) to serve")
+	realtime := fs.Bool("realtime", false, "Reproduce the captured timing of response chunks")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: llm-proxy replay -dir <capture-dir> [-addr :8091] [-realtime]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" {
+		fs.Usage()
+		return 2
+	}
+
+	exchanges, err := capture.Load(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load captures: %v\n", err)
+		return 1
+	}
+	var opts []capture.ReplayOption
+	if *realtime {
+		opts = append(opts, capture.WithRealtime())
+	}
+
+	fmt.Fprintf(os.Stderr, "replaying %d captured exchanges on %s\n", len(exchanges), *addr)
+	if err := http.ListenAndServe(*addr, capture.NewReplayer(exchanges, opts...)); err != nil {
+		fmt.Fprintf(os.Stderr, "replay server: %v\n", err)
+		return 1
+	}
+	return 0
+}